@@ -0,0 +1,221 @@
+package grequests
+
+import (
+	"bytes"
+	"hash"
+	"io"
+	"net/http"
+)
+
+// Authenticator adds credentials to outgoing requests. Use the `UseAuthenticator`
+// option to set a custom one (`BasicAuth`, `BearerToken` and `APIKey` are
+// Authenticators themselves).
+type Authenticator interface {
+	// Authenticate signs or decorates the request before it is sent. It is
+	// called after the `BeforeRequest` hook, once the request is complete
+	Authenticate(req *http.Request) error
+
+	// Challenge is called when the server responded to the request with a 401
+	// status code. If it returns true the request is authenticated and sent
+	// again (once), otherwise the 401 response is returned to the caller.
+	Challenge(req *http.Request, resp *http.Response) (bool, error)
+}
+
+// AuthOption is an Authenticator that can also be used as an Option
+type AuthOption interface {
+	Option
+	Authenticator
+}
+
+// APIKeyLocation is where `APIKey` puts the key
+type APIKeyLocation int
+
+const (
+	// APIKeyInHeader sends the key as a request header
+	APIKeyInHeader APIKeyLocation = iota
+
+	// APIKeyInQuery sends the key as a query parameter
+	APIKeyInQuery
+)
+
+type basicAuth struct {
+	username, password string
+}
+
+func (b *basicAuth) Apply(ro *RequestOptions) {
+	ro.Auth = []string{b.username, b.password}
+	ro.Authenticator = b
+}
+
+func (b *basicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(b.username, b.password)
+	return nil
+}
+
+func (b *basicAuth) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	return false, nil
+}
+
+type bearerToken struct {
+	token string
+}
+
+func (b *bearerToken) Apply(ro *RequestOptions) {
+	ro.Authenticator = b
+}
+
+func (b *bearerToken) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	return nil
+}
+
+func (b *bearerToken) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	return false, nil
+}
+
+type apiKey struct {
+	location    APIKeyLocation
+	name, value string
+}
+
+func (a *apiKey) Apply(ro *RequestOptions) {
+	ro.Authenticator = a
+}
+
+func (a *apiKey) Authenticate(req *http.Request) error {
+	if a.location == APIKeyInQuery {
+		query := req.URL.Query()
+		query.Set(a.name, a.value)
+		req.URL.RawQuery = query.Encode()
+		return nil
+	}
+
+	req.Header.Set(a.name, a.value)
+	return nil
+}
+
+func (a *apiKey) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	return false, nil
+}
+
+// boundAuthenticator is implemented by authenticators that need the options
+// of the request they authenticate (to make requests of their own)
+type boundAuthenticator interface {
+	bind(ro *RequestOptions) Authenticator
+}
+
+// requestAuthenticator returns the Authenticator of the request – the legacy
+// `Auth` field is turned into a basic authenticator and the credential
+// providers are the last resort
+func requestAuthenticator(ro *RequestOptions) Authenticator {
+	if bound, ok := ro.Authenticator.(boundAuthenticator); ok {
+		return bound.bind(ro)
+	}
+
+	if ro.Authenticator != nil {
+		return ro.Authenticator
+	}
+
+	if len(ro.Auth) >= 2 {
+		return &basicAuth{username: ro.Auth[0], password: ro.Auth[1]}
+	}
+
+	if len(ro.CredentialProviders) != 0 {
+		return &providerAuth{providers: ro.CredentialProviders}
+	}
+	return nil
+}
+
+// mergeAuthenticator picks the Authenticator of the request over the one of
+// the session. Setting the legacy `Auth` field on a request also overrides
+// the Authenticator of the session.
+func mergeAuthenticator(ro, base *RequestOptions) Authenticator {
+	switch {
+	case ro.Authenticator != nil:
+		return ro.Authenticator
+	case ro.Auth != nil:
+		return nil
+	}
+	return base.Authenticator
+}
+
+// sendAuthenticatedRequest sends the request and, if the server challenges
+// us, lets the authenticator respond to the challenge once
+func sendAuthenticatedRequest(httpClient *http.Client, req *http.Request, ro *RequestOptions) (*http.Response, error) {
+	resp, err := sendRequest(httpClient, req, ro)
+
+	auth := requestAuthenticator(ro)
+	if err != nil || auth == nil || resp.StatusCode != http.StatusUnauthorized || !canReplay(req) {
+		return resp, err
+	}
+
+	retry, err := auth.Challenge(req, resp)
+	if err != nil {
+		drainBody(resp)
+		return nil, err
+	}
+
+	if !retry {
+		return resp, nil
+	}
+
+	next, err := cloneRequest(req.Context(), req)
+	if err != nil {
+		return resp, nil
+	}
+
+	drainBody(resp)
+
+	if err := auth.Authenticate(next); err != nil {
+		closeRequestBody(next)
+		return nil, err
+	}
+
+	return sendRequest(httpClient, next, ro)
+}
+
+// drainBody reads (a bit of) the body so that the connection can be reused
+func drainBody(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	_ = resp.Body.Close()
+}
+
+func hasBody(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody
+}
+
+// digestBody writes the body of the request into the hash, leaving the body
+// ready to be sent. A body that can't be read twice is buffered in memory.
+func digestBody(req *http.Request, h hash.Hash) error {
+	if req.GetBody == nil {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		req.ContentLength = int64(len(body))
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		_, _ = h.Write(body)
+		return nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(h, body)
+	_ = body.Close()
+	if err != nil {
+		return err
+	}
+
+	// The body may share its reader with the one we just consumed
+	_ = req.Body.Close()
+	req.Body, err = req.GetBody()
+	return err
+}
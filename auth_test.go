@@ -0,0 +1,244 @@
+package grequests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AuthSuite struct {
+	suite.Suite
+}
+
+func (s *AuthSuite) echo(srv *httptest.Server, session *Session, options ...Option) echoResponse {
+	var (
+		resp *Response
+		err  error
+	)
+	if session != nil {
+		resp, err = session.Get(context.Background(), srv.URL+"?a=b", options...)
+	} else {
+		resp, err = Get(context.Background(), srv.URL+"?a=b", options...)
+	}
+	s.Require().NoError(err)
+
+	var echo echoResponse
+	s.Require().NoError(resp.JSON(&echo))
+	return echo
+}
+
+func (s *AuthSuite) TestBuiltins() {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	echo := s.echo(srv, nil, BearerToken("token"))
+	s.Equal("Bearer token", echo.Headers.Get("Authorization"))
+
+	echo = s.echo(srv, nil, BasicAuth("user", "pass"))
+	s.Equal("Basic dXNlcjpwYXNz", echo.Headers.Get("Authorization"))
+
+	echo = s.echo(srv, nil, APIKey(APIKeyInHeader, "X-Api-Key", "secret"))
+	s.Equal("secret", echo.Headers.Get("X-Api-Key"))
+
+	echo = s.echo(srv, nil, APIKey(APIKeyInQuery, "api_key", "secret"))
+	s.Equal([]string{"secret"}, echo.Query["api_key"])
+	s.Equal([]string{"b"}, echo.Query["a"])
+}
+
+func (s *AuthSuite) TestAPIKeyRedirects() {
+	var received []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-Api-Key"))
+	}))
+	defer other.Close()
+
+	// The same server seen as another host
+	otherURL, err := url.Parse(other.URL)
+	s.Require().NoError(err)
+	otherURL.Host = "localhost:" + otherURL.Port()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-Api-Key"))
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/done", http.StatusFound)
+		case "/away":
+			http.Redirect(w, r, otherURL.String(), http.StatusFound)
+		}
+	}))
+	defer origin.Close()
+
+	for _, name := range []string{"X-Api-Key", "x-api-key"} {
+		received = nil
+		_, err = Get(context.Background(), origin.URL+"/same", APIKey(APIKeyInHeader, name, "secret"))
+		s.Require().NoError(err)
+		s.Equal([]string{"secret", "secret"}, received)
+
+		// The key never leaves the host, whatever the sensitive headers
+		for _, options := range [][]Option{nil, {SensitiveHTTPHeaders("X-Secret")}} {
+			received = nil
+			_, err = Get(context.Background(), origin.URL+"/away", append(options, APIKey(APIKeyInHeader, name, "secret"))...)
+			s.Require().NoError(err)
+			s.Equal([]string{"secret", ""}, received)
+		}
+	}
+}
+
+func (s *AuthSuite) TestLegacyAuthField() {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	echo := s.echo(srv, nil, FromRequestOptions(&RequestOptions{Auth: []string{"user", "pass"}}))
+	s.Equal("Basic dXNlcjpwYXNz", echo.Headers.Get("Authorization"))
+
+	// The Authenticator takes precedence
+	echo = s.echo(srv, nil, FromRequestOptions(&RequestOptions{Auth: []string{"user", "pass"}}), BearerToken("token"))
+	s.Equal("Bearer token", echo.Headers.Get("Authorization"))
+}
+
+func (s *AuthSuite) TestSessionAuthenticator() {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	session := NewSession(BearerToken("session"))
+	s.Equal("Bearer session", s.echo(srv, session).Headers.Get("Authorization"))
+	s.Equal("Bearer session", s.echo(srv, session).Headers.Get("Authorization"))
+	s.Equal("Bearer request", s.echo(srv, session, BearerToken("request")).Headers.Get("Authorization"))
+	s.Equal("Basic dXNlcjpwYXNz", s.echo(srv, session, FromRequestOptions(&RequestOptions{Auth: []string{"user", "pass"}})).Headers.Get("Authorization"))
+
+	custom := NewSession(UseAuthenticator(APIKey(APIKeyInHeader, "X-Key", "k")))
+	s.Equal("k", s.echo(srv, custom).Headers.Get("X-Key"))
+}
+
+// challengeAuth answers the challenge with the realm sent by the server
+type challengeAuth struct {
+	token      string
+	challenges int32
+	answer     bool
+	err        error
+}
+
+func (c *challengeAuth) Authenticate(req *http.Request) error {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Token "+c.token)
+	}
+	return nil
+}
+
+func (c *challengeAuth) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	atomic.AddInt32(&c.challenges, 1)
+	c.token = strings.TrimPrefix(resp.Header.Get("WWW-Authenticate"), "Token realm=")
+	return c.answer, c.err
+}
+
+// newChallengeServer only accepts the token it sends in its challenge and
+// echoes the request body
+func newChallengeServer(hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "Token abc" {
+			w.Header().Set("WWW-Authenticate", "Token realm=abc")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+}
+
+func (s *AuthSuite) TestChallenge() {
+	var hits int32
+	srv := newChallengeServer(&hits)
+	defer srv.Close()
+
+	auth := &challengeAuth{answer: true}
+	resp, err := Post(context.Background(), srv.URL, UseAuthenticator(auth), JSON(`{"a":1}`))
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal(`{"a":1}`, resp.String())
+	s.Equal(int32(2), atomic.LoadInt32(&hits))
+	s.Equal(int32(1), atomic.LoadInt32(&auth.challenges))
+
+	// The token is now known, no challenge is needed
+	resp, err = Post(context.Background(), srv.URL, UseAuthenticator(auth), RequestBody(strings.NewReader("again")))
+	s.Require().NoError(err)
+	s.Equal("again", resp.String())
+	s.Equal(int32(1), atomic.LoadInt32(&auth.challenges))
+}
+
+func (s *AuthSuite) TestChallengeReplaysSeekableBody() {
+	var hits int32
+	srv := newChallengeServer(&hits)
+	defer srv.Close()
+
+	resp, err := Put(context.Background(), srv.URL, UseAuthenticator(&challengeAuth{answer: true}), RequestBody(strings.NewReader("body")))
+	s.Require().NoError(err)
+	s.Equal("body", resp.String())
+}
+
+func (s *AuthSuite) TestChallengeIsAnsweredOnce() {
+	var hits int32
+	srv := newChallengeServer(&hits)
+	defer srv.Close()
+
+	// The server never accepts the credentials
+	auth := &challengeAuth{answer: true}
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("WWW-Authenticate", "Token realm=nope")
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	resp, err := Get(context.Background(), srv.URL, UseAuthenticator(auth))
+	s.Require().NoError(err)
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+	s.Equal(int32(2), atomic.LoadInt32(&hits))
+}
+
+func (s *AuthSuite) TestChallengeDeclined() {
+	var hits int32
+	srv := newChallengeServer(&hits)
+	defer srv.Close()
+
+	resp, err := Get(context.Background(), srv.URL, UseAuthenticator(&challengeAuth{}))
+	s.Require().NoError(err)
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+	s.Equal(int32(1), atomic.LoadInt32(&hits))
+
+	resp, err = Get(context.Background(), srv.URL, BearerToken("wrong"))
+	s.Require().NoError(err)
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+}
+
+func (s *AuthSuite) TestErrors() {
+	var hits int32
+	srv := newChallengeServer(&hits)
+	defer srv.Close()
+
+	challengeErr := errors.New("challenge")
+	_, err := Get(context.Background(), srv.URL, UseAuthenticator(&challengeAuth{err: challengeErr}))
+	s.ErrorIs(err, challengeErr)
+
+	authErr := errors.New("authenticate")
+	_, err = Get(context.Background(), srv.URL, UseAuthenticator(failingAuth{authErr}))
+	s.ErrorIs(err, authErr)
+}
+
+type failingAuth struct{ err error }
+
+func (f failingAuth) Authenticate(req *http.Request) error { return f.err }
+func (f failingAuth) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	return false, nil
+}
+
+func TestAuthSuite(t *testing.T) {
+	suite.Run(t, new(AuthSuite))
+}
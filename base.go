@@ -72,9 +72,7 @@ func Options(ctx context.Context, url string, options ...Option) (*Response, err
 // If you do not intend to use the `RequestOptions` you can just pass nil
 func Request(ctx context.Context, verb, url string, options ...Option) (*Response, error) {
 	ro := &RequestOptions{}
-	for _, opt := range options {
-		opt.Apply(ro)
-	}
+	applyOptions(ro, options)
 	if ctx != nil {
 		ro.Context = ctx
 	}
@@ -25,15 +25,15 @@ func (s *DeleteSuite) TestDeleteSessionCookies() {
 	srv := newCookieSetServer()
 	defer srv.Close()
 
-	session := NewSession(nil)
-	_, err := session.Get(context.Background(), srv.URL+"?one=two", nil)
+	session := NewSession()
+	_, err := session.Get(context.Background(), srv.URL+"?one=two")
 	s.Require().NoError(err)
-	_, err = session.Get(context.Background(), srv.URL+"?two=three", nil)
+	_, err = session.Get(context.Background(), srv.URL+"?two=three")
 	s.Require().NoError(err)
-	_, err = session.Get(context.Background(), srv.URL+"?three=four", nil)
+	_, err = session.Get(context.Background(), srv.URL+"?three=four")
 	s.Require().NoError(err)
 
-	_, err = session.Delete(context.Background(), srv.URL, nil)
+	_, err = session.Delete(context.Background(), srv.URL)
 	s.Require().NoError(err)
 
 	cookieURL, err := url.Parse(srv.URL)
@@ -42,8 +42,8 @@ func (s *DeleteSuite) TestDeleteSessionCookies() {
 }
 
 func (s *DeleteSuite) TestDeleteInvalidURLSession() {
-	session := NewSession(nil)
-	_, err := session.Delete(context.Background(), "%../dir/", nil)
+	session := NewSession()
+	_, err := session.Delete(context.Background(), "%../dir/")
 	s.Error(err)
 }
 
@@ -30,10 +30,10 @@ func (s *GetSuite) TestGetSessionCookies() {
 	srv := newCookieSetServer()
 	defer srv.Close()
 
-	session := NewSession(nil)
-	_, err := session.Get(context.Background(), srv.URL+"?one=two", nil)
+	session := NewSession()
+	_, err := session.Get(context.Background(), srv.URL+"?one=two")
 	s.Require().NoError(err)
-	_, err = session.Get(context.Background(), srv.URL+"?two=three", nil)
+	_, err = session.Get(context.Background(), srv.URL+"?two=three")
 	s.Require().NoError(err)
 
 	cookieURL, err := url.Parse(srv.URL)
@@ -26,12 +26,12 @@ func (s *HeadSuite) TestHeadSessionCookies() {
 	srv := newCookieSetServer()
 	defer srv.Close()
 
-	session := NewSession(nil)
-	_, err := session.Head(context.Background(), srv.URL+"?one=two", &RequestOptions{})
+	session := NewSession()
+	_, err := session.Head(context.Background(), srv.URL+"?one=two")
 	s.Require().NoError(err)
-	_, err = session.Head(context.Background(), srv.URL+"?two=three", &RequestOptions{})
+	_, err = session.Head(context.Background(), srv.URL+"?two=three")
 	s.Require().NoError(err)
-	_, err = session.Head(context.Background(), srv.URL+"?three=four", &RequestOptions{})
+	_, err = session.Head(context.Background(), srv.URL+"?three=four")
 	s.Require().NoError(err)
 
 	cookieURL, err := url.Parse(srv.URL)
@@ -40,8 +40,8 @@ func (s *HeadSuite) TestHeadSessionCookies() {
 }
 
 func (s *HeadSuite) TestHeadInvalidURLSession() {
-	session := NewSession(nil)
-	_, err := session.Head(context.Background(), "%../dir/", nil)
+	session := NewSession()
+	_, err := session.Head(context.Background(), "%../dir/")
 	s.Error(err)
 }
 
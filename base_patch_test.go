@@ -21,8 +21,8 @@ func (s *PatchSuite) TestPatchRequest() {
 }
 
 func (s *PatchSuite) TestPatchInvalidURLSession() {
-	session := NewSession(nil)
-	_, err := session.Patch(context.Background(), "%../dir/", nil)
+	session := NewSession()
+	_, err := session.Patch(context.Background(), "%../dir/")
 	s.Error(err)
 }
 
@@ -24,8 +24,8 @@ func (s *PostSuite) TestPostSession() {
 	srv := newPostServer()
 	defer srv.Close()
 
-	session := NewSession(nil)
-	resp, err := session.Post(context.Background(), srv.URL, &RequestOptions{Data: map[string]string{"one": "two"}})
+	session := NewSession()
+	resp, err := session.Post(context.Background(), srv.URL, FromRequestOptions(&RequestOptions{Data: map[string]string{"one": "two"}}))
 	s.Require().NoError(err)
 	s.True(resp.Ok)
 }
@@ -21,8 +21,8 @@ func (s *PutSuite) TestPutRequest() {
 }
 
 func (s *PutSuite) TestPutInvalidURLSession() {
-	session := NewSession(nil)
-	_, err := session.Put(context.Background(), "%../dir/", nil)
+	session := NewSession()
+	_, err := session.Put(context.Background(), "%../dir/")
 	s.Error(err)
 }
 
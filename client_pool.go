@@ -0,0 +1,283 @@
+package grequests
+
+import (
+	"container/list"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientPoolSize is the maximum number of HTTP clients that are kept around
+// to be reused by requests that are not made through a `Session`. When the pool
+// is full the least recently used client is evicted and its idle connections
+// are closed. You can change this globally by modifying this variable.
+var ClientPoolSize = 32
+
+var defaultClientPool = newClientPool()
+
+// clientKey holds every `RequestOptions` field that influences how the
+// transport or client is built. Requests with the same key share a client.
+type clientKey struct {
+	insecureSkipVerify  bool
+	disableCompression  bool
+	proxies             string
+	tlsHandshakeTimeout time.Duration
+	dialTimeout         time.Duration
+	dialKeepAlive       time.Duration
+	requestTimeout      time.Duration
+	localAddr           string
+	clientCertificates  string
+	rootCAs             *x509.CertPool
+	rootCAFiles         string
+	tlsMinVersion       uint16
+	tlsServerName       string
+	pinnedPublicKeys    string
+	unixSocket          string
+	resolveOverrides    string
+	resolver            *net.Resolver
+	dnsCacheTTL         time.Duration
+	noProxy             string
+	proxyConnectHeaders string
+	proxyAutoConfig     string
+	forceHTTP2          bool
+	disableHTTP2        bool
+	h2c                 bool
+	http2ReadIdle       time.Duration
+	http2PingTimeout    time.Duration
+}
+
+func newClientKey(ro RequestOptions) clientKey {
+	key := clientKey{
+		insecureSkipVerify:  ro.InsecureSkipVerify,
+		disableCompression:  ro.DisableCompression,
+		tlsHandshakeTimeout: ro.TLSHandshakeTimeout,
+		dialTimeout:         ro.DialTimeout,
+		dialKeepAlive:       ro.DialKeepAlive,
+		requestTimeout:      ro.RequestTimeout,
+		rootCAs:             ro.RootCAs,
+		tlsMinVersion:       ro.TLSMinVersion,
+		tlsServerName:       ro.TLSServerName,
+		unixSocket:          ro.UnixSocket,
+		resolveOverrides:    overridesClientKey(ro),
+		resolver:            ro.Resolver,
+		dnsCacheTTL:         ro.DNSCacheTTL,
+		proxyAutoConfig:     ro.ProxyAutoConfig,
+		forceHTTP2:          ro.ForceHTTP2,
+		disableHTTP2:        ro.DisableHTTP2,
+		h2c:                 ro.H2C,
+		http2ReadIdle:       ro.HTTP2ReadIdleTimeout,
+		http2PingTimeout:    ro.HTTP2PingTimeout,
+	}
+	key.noProxy, key.proxyConnectHeaders = proxyClientKey(ro)
+	key.clientCertificates, key.rootCAFiles = tlsClientKey(ro)
+	key.pinnedPublicKeys = pinsClientKey(ro)
+
+	if ro.LocalAddr != nil {
+		key.localAddr = ro.LocalAddr.String()
+	}
+
+	if len(ro.Proxies) != 0 {
+		proxies := make([]string, 0, len(ro.Proxies))
+		for scheme, u := range ro.Proxies {
+			proxies = append(proxies, scheme+"="+u.String())
+		}
+		sort.Strings(proxies)
+		key.proxies = strings.Join(proxies, ",")
+	}
+
+	return key
+}
+
+// transportOptions returns the options that requests made on behalf of ro
+// (to fetch a token for instance) share with it: the transport and client
+// settings, without anything describing the request itself
+func transportOptions(ro *RequestOptions) *RequestOptions {
+	return &RequestOptions{
+		InsecureSkipVerify:   ro.InsecureSkipVerify,
+		DisableCompression:   ro.DisableCompression,
+		UserAgent:            ro.UserAgent,
+		Proxies:              ro.Proxies,
+		TLSHandshakeTimeout:  ro.TLSHandshakeTimeout,
+		DialTimeout:          ro.DialTimeout,
+		DialKeepAlive:        ro.DialKeepAlive,
+		RequestTimeout:       ro.RequestTimeout,
+		HTTPClient:           ro.HTTPClient,
+		LocalAddr:            ro.LocalAddr,
+		RetryPolicy:          ro.RetryPolicy,
+		ClientCertificates:   ro.ClientCertificates,
+		RootCAs:              ro.RootCAs,
+		RootCAFiles:          ro.RootCAFiles,
+		TLSMinVersion:        ro.TLSMinVersion,
+		TLSServerName:        ro.TLSServerName,
+		TLSConfig:            ro.TLSConfig,
+		PinnedPublicKeys:     ro.PinnedPublicKeys,
+		PinMismatchReporter:  ro.PinMismatchReporter,
+		UnixSocket:           ro.UnixSocket,
+		DialContext:          ro.DialContext,
+		ResolveOverrides:     ro.ResolveOverrides,
+		Resolver:             ro.Resolver,
+		DNSCacheTTL:          ro.DNSCacheTTL,
+		ProxyConnectHeaders:  ro.ProxyConnectHeaders,
+		NoProxy:              ro.NoProxy,
+		ProxyAutoConfig:      ro.ProxyAutoConfig,
+		ForceHTTP2:           ro.ForceHTTP2,
+		DisableHTTP2:         ro.DisableHTTP2,
+		H2C:                  ro.H2C,
+		HTTP2ReadIdleTimeout: ro.HTTP2ReadIdleTimeout,
+		HTTP2PingTimeout:     ro.HTTP2PingTimeout,
+	}
+}
+
+type pooledClient struct {
+	key    clientKey
+	client *http.Client
+}
+
+// clientPool is a concurrency safe LRU cache of HTTP clients
+type clientPool struct {
+	mu      sync.Mutex
+	order   *list.List
+	clients map[clientKey]*list.Element
+}
+
+func newClientPool() *clientPool {
+	return &clientPool{order: list.New(), clients: make(map[clientKey]*list.Element)}
+}
+
+// get returns the client stored under the key, building (and caching) a new
+// one when there is none. Clients are built without holding the lock (it can
+// mean reading files or fetching a PAC file) so that requests using other
+// clients aren't held up
+func (p *clientPool) get(key clientKey, build func() *http.Client) *http.Client {
+	if client, ok := p.lookup(key); ok {
+		return client
+	}
+
+	client := build()
+
+	// A client that couldn't be built isn't kept, the error may be fixed (a
+	// missing file for instance) by the time of the next request
+	if _, failed := client.Transport.(failingTransport); failed {
+		return client
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Another request may have built a client for the key in the meantime,
+	// it is used instead of ours so that connections are still shared
+	if elem, ok := p.clients[key]; ok {
+		client.CloseIdleConnections()
+		p.order.MoveToFront(elem)
+		return elem.Value.(*pooledClient).client
+	}
+
+	p.clients[key] = p.order.PushFront(&pooledClient{key: key, client: client})
+
+	for p.order.Len() > ClientPoolSize && p.order.Len() > 1 {
+		p.evict(p.order.Back())
+	}
+
+	return client
+}
+
+// client returns a copy of the client pooled for ro with a cookie jar of
+// its own (cookie jars hold per request state, they are never pooled)
+func (p *clientPool) client(ro RequestOptions) *http.Client {
+	pooled := p.get(newClientKey(ro), func() *http.Client {
+		client := BuildHTTPClient(ro)
+		client.Jar = nil
+		return client
+	})
+
+	client := *pooled
+	client.Jar = buildCookieJar(ro)
+
+	return &client
+}
+
+func (p *clientPool) lookup(key clientKey) (*http.Client, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	elem, ok := p.clients[key]
+	if !ok {
+		return nil, false
+	}
+	p.order.MoveToFront(elem)
+	return elem.Value.(*pooledClient).client, true
+}
+
+func (p *clientPool) evict(elem *list.Element) {
+	pc := p.order.Remove(elem).(*pooledClient)
+	delete(p.clients, pc.key)
+	pc.client.CloseIdleConnections()
+}
+
+func (p *clientPool) closeIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for elem := p.order.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*pooledClient).client.CloseIdleConnections()
+	}
+}
+
+func (p *clientPool) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.order.Len()
+}
+
+func (p *clientPool) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.order.Len() > 0 {
+		p.evict(p.order.Back())
+	}
+}
+
+// ResetClientPool evicts every pooled HTTP client and closes their idle connections
+func ResetClientPool() {
+	defaultClientPool.reset()
+}
+
+// pooledHTTPClient returns a client for the request options. Clients with
+// custom transports are shared between requests with the same transport
+// settings so that keep-alive connections are reused. Pooled clients are
+// returned as a copy which the caller may modify (e.g. to set `CheckRedirect`).
+// `TLSConfig` functions, pin mismatch reporters and `DialContext` functions
+// can't be compared so those clients are never pooled (see singleUseHTTPClient).
+func pooledHTTPClient(ro RequestOptions) *http.Client {
+	if ro.HTTPClient != nil || !ro.dontUseDefaultClient() {
+		return BuildHTTPClient(ro)
+	}
+
+	if !ro.poolableTransport() {
+		return singleUseHTTPClient(ro)
+	}
+
+	return defaultClientPool.client(ro)
+}
+
+// poolableTransport reports if the transport settings of the options can be
+// told apart by a `clientKey`
+func (ro RequestOptions) poolableTransport() bool {
+	return len(ro.TLSConfig) == 0 && ro.PinMismatchReporter == nil && ro.DialContext == nil
+}
+
+// singleUseHTTPClient builds a client for options that can't be pooled. Its
+// connections are closed once the response has been read as nothing would
+// ever reuse (or close) them: a `Session` has to be used to keep them alive.
+func singleUseHTTPClient(ro RequestOptions) *http.Client {
+	client := BuildHTTPClient(ro)
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		transport.DisableKeepAlives = true
+	}
+	return client
+}
@@ -0,0 +1,192 @@
+package grequests
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ClientPoolSuite struct {
+	suite.Suite
+}
+
+func (s *ClientPoolSuite) SetupTest() {
+	ResetClientPool()
+}
+
+func (s *ClientPoolSuite) TestReusesConnections() {
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	for i := 0; i < 5; i++ {
+		resp, err := Get(context.Background(), srv.URL, DialTimeout(time.Second))
+		s.Require().NoError(err)
+		s.NoError(resp.Close())
+	}
+
+	s.Equal(int32(1), atomic.LoadInt32(&conns))
+	s.Equal(1, defaultClientPool.len())
+}
+
+func (s *ClientPoolSuite) TestKeyedOnTransportSettings() {
+	a := pooledHTTPClient(RequestOptions{DialTimeout: time.Second})
+	b := pooledHTTPClient(RequestOptions{DialTimeout: time.Second, UserAgent: "ua"})
+	c := pooledHTTPClient(RequestOptions{DialTimeout: 2 * time.Second})
+
+	s.Same(a.Transport, b.Transport)
+	s.NotSame(a.Transport, c.Transport)
+	s.NotSame(a, b)
+
+	proxyURL, _ := url.Parse("http://proxy")
+	d := pooledHTTPClient(RequestOptions{Proxies: map[string]*url.URL{"http": proxyURL}})
+	e := pooledHTTPClient(RequestOptions{Proxies: map[string]*url.URL{"http": proxyURL}})
+	s.Same(d.Transport, e.Transport)
+}
+
+func (s *ClientPoolSuite) TestCookieJarsAreNotShared() {
+	a := pooledHTTPClient(RequestOptions{UseCookieJar: true})
+	b := pooledHTTPClient(RequestOptions{UseCookieJar: true})
+	s.Same(a.Transport, b.Transport)
+	s.NotNil(a.Jar)
+	s.NotNil(b.Jar)
+	s.NotSame(a.Jar, b.Jar)
+
+	c := pooledHTTPClient(RequestOptions{Cookies: []*http.Cookie{{Name: "n"}}})
+	s.Nil(c.Jar)
+}
+
+func (s *ClientPoolSuite) TestDefaultAndCustomClientsAreNotPooled() {
+	s.Equal(http.DefaultClient, pooledHTTPClient(RequestOptions{}))
+	custom := &http.Client{}
+	s.Equal(custom, pooledHTTPClient(RequestOptions{HTTPClient: custom}))
+	s.Equal(0, defaultClientPool.len())
+}
+
+func (s *ClientPoolSuite) TestEviction() {
+	defer func(size int) { ClientPoolSize = size }(ClientPoolSize)
+	ClientPoolSize = 2
+
+	first := pooledHTTPClient(RequestOptions{DialTimeout: 1 * time.Second})
+	pooledHTTPClient(RequestOptions{DialTimeout: 2 * time.Second})
+	// Touch the first client so that the second becomes the least recently used
+	pooledHTTPClient(RequestOptions{DialTimeout: 1 * time.Second})
+	pooledHTTPClient(RequestOptions{DialTimeout: 3 * time.Second})
+
+	s.Equal(2, defaultClientPool.len())
+	s.Same(first.Transport, pooledHTTPClient(RequestOptions{DialTimeout: 1 * time.Second}).Transport)
+
+	ResetClientPool()
+	s.Equal(0, defaultClientPool.len())
+}
+
+func (s *ClientPoolSuite) TestConcurrentAccess() {
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pooledHTTPClient(RequestOptions{DialTimeout: time.Duration(i%5+1) * time.Second})
+		}(i)
+	}
+	wg.Wait()
+	s.Equal(5, defaultClientPool.len())
+}
+
+func (s *ClientPoolSuite) TestBuildDoesNotBlockOtherKeys() {
+	slow := newClientKey(RequestOptions{DialTimeout: time.Second})
+	fast := newClientKey(RequestOptions{DialTimeout: 2 * time.Second})
+
+	building, release := make(chan struct{}), make(chan struct{})
+	done := make(chan *http.Client)
+	go func() {
+		done <- defaultClientPool.get(slow, func() *http.Client {
+			close(building)
+			<-release
+			return &http.Client{Transport: &http.Transport{}}
+		})
+	}()
+	<-building
+
+	// Other keys are served while the slow client is built
+	fastClient := defaultClientPool.get(fast, func() *http.Client { return &http.Client{Transport: &http.Transport{}} })
+	s.NotNil(fastClient)
+	s.Equal(1, defaultClientPool.len())
+
+	// A client built concurrently for the same key loses to the pooled one
+	built := defaultClientPool.get(slow, func() *http.Client { return &http.Client{Transport: &http.Transport{}} })
+	close(release)
+	s.Same(built, <-done)
+	s.Equal(2, defaultClientPool.len())
+}
+
+func (s *ClientPoolSuite) TestUnpoolableTransports() {
+	var conns, dials int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt32(&conns, 1)
+		case http.StateClosed:
+			atomic.AddInt32(&conns, -1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	dial := DialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	})
+
+	// The connections of clients that can't be pooled aren't left open
+	for i := 0; i < 3; i++ {
+		resp, err := Get(context.Background(), srv.URL, dial)
+		s.Require().NoError(err)
+		s.NoError(resp.Close())
+	}
+	s.Equal(int32(3), atomic.LoadInt32(&dials))
+	s.Eventually(func() bool { return atomic.LoadInt32(&conns) == 0 }, time.Second, 10*time.Millisecond)
+	s.Zero(defaultClientPool.len())
+
+	// A session keeps the clients built with its own functions
+	atomic.StoreInt32(&dials, 0)
+	session := NewSession(dial)
+	for i := 0; i < 3; i++ {
+		resp, err := session.Get(context.Background(), srv.URL, DialTimeout(time.Second))
+		s.Require().NoError(err)
+		s.NoError(resp.Close())
+	}
+	s.Equal(int32(1), atomic.LoadInt32(&dials))
+	s.Equal(1, session.clients.len())
+
+	session.CloseIdleConnections()
+	s.Eventually(func() bool { return atomic.LoadInt32(&conns) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func (s *ClientPoolSuite) TestIdleConnTimeout() {
+	client := pooledHTTPClient(RequestOptions{DialTimeout: time.Second})
+	s.Equal(idleConnTimeout, client.Transport.(*http.Transport).IdleConnTimeout)
+}
+
+func TestClientPoolSuite(t *testing.T) {
+	suite.Run(t, new(ClientPoolSuite))
+}
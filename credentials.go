@@ -0,0 +1,308 @@
+package grequests
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Credentials are a user name and password found by a `CredentialProvider`
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// CredentialProvider looks up the credentials of a host. Providers are
+// consulted (in order) when the request has neither an `Authenticator` nor
+// `Auth`, the credentials found are sent with basic authentication and never
+// follow a redirect to another host.
+type CredentialProvider interface {
+	// Credentials returns the credentials of the host (which may include a
+	// port) or nil when there are none
+	Credentials(host string) (*Credentials, error)
+}
+
+// StaticCredentials returns a provider that looks up the credentials in the
+// map. The keys are host names, optionally with a port ("example.com:8443")
+// which takes precedence over the bare host name.
+func StaticCredentials(credentials map[string]Credentials) CredentialProvider {
+	return staticCredentials(credentials)
+}
+
+type staticCredentials map[string]Credentials
+
+func (s staticCredentials) Credentials(host string) (*Credentials, error) {
+	for _, key := range credentialHostKeys(host) {
+		if credentials, ok := s[key]; ok {
+			return &credentials, nil
+		}
+	}
+	return nil, nil
+}
+
+// EnvCredentials returns a provider that reads the credentials from the
+// environment variables <prefix><HOST>_USERNAME and <prefix><HOST>_PASSWORD.
+// HOST is the upper cased host name with every character that isn't a letter
+// or a digit replaced by an underscore, e.g. with the prefix "GREQUESTS_" the
+// user name of api.example.com is read from GREQUESTS_API_EXAMPLE_COM_USERNAME.
+func EnvCredentials(prefix string) CredentialProvider {
+	return envCredentials(prefix)
+}
+
+type envCredentials string
+
+func (e envCredentials) Credentials(host string) (*Credentials, error) {
+	for _, key := range credentialHostKeys(host) {
+		name := string(e) + strings.Map(func(r rune) rune {
+			if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				return unicode.ToUpper(r)
+			}
+			return '_'
+		}, key)
+
+		username, ok := os.LookupEnv(name + "_USERNAME")
+		if !ok {
+			continue
+		}
+		return &Credentials{Username: username, Password: os.Getenv(name + "_PASSWORD")}, nil
+	}
+	return nil, nil
+}
+
+// credentialHostKeys returns the keys a host is looked up with, the most
+// specific first
+func credentialHostKeys(host string) []string {
+	if hostname := stripPort(host); hostname != host {
+		return []string{host, hostname}
+	}
+	return []string{host}
+}
+
+func stripPort(host string) string {
+	if i := strings.LastIndexByte(host, ':'); i != -1 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// NetrcCredentials returns a provider that reads the credentials from a
+// .netrc file, the format used by curl, git and ftp. When path is empty the
+// file named by the NETRC environment variable or ~/.netrc (~/_netrc on
+// Windows) is used, and a missing file simply provides no credentials. The
+// file is read on the first lookup, errors are returned from the request.
+func NetrcCredentials(path string) CredentialProvider {
+	return &netrcCredentials{path: path}
+}
+
+type netrcCredentials struct {
+	path string
+
+	once    sync.Once
+	entries []netrcEntry
+	err     error
+}
+
+// netrcEntry is a machine (or the default, when machine is empty) of the file
+type netrcEntry struct {
+	machine string
+	Credentials
+}
+
+func (n *netrcCredentials) Credentials(host string) (*Credentials, error) {
+	n.once.Do(n.load)
+	if n.err != nil {
+		return nil, n.err
+	}
+
+	hostname := stripPort(host)
+	for _, entry := range n.entries {
+		if entry.machine == "" || strings.EqualFold(entry.machine, hostname) {
+			credentials := entry.Credentials
+			return &credentials, nil
+		}
+	}
+	return nil, nil
+}
+
+func (n *netrcCredentials) load() {
+	path := n.path
+	optional := path == ""
+	if optional {
+		path = defaultNetrcPath()
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		if optional && errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		n.err = fmt.Errorf("grequests: unable to read the netrc file: %w", err)
+		return
+	}
+	defer func() { _ = fd.Close() }()
+
+	n.entries, n.err = parseNetrc(fd)
+}
+
+func defaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// parseNetrc parses the tokens of a netrc file. The default entry is moved
+// last so that it only applies when no machine matches.
+func parseNetrc(r io.Reader) ([]netrcEntry, error) {
+	var (
+		entries    []netrcEntry
+		defaultAt  = -1
+		current    *netrcEntry
+		inMacro    bool
+		lineNumber int
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+
+		// A macro definition runs until the next blank line
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			token := fields[i]
+			if strings.HasPrefix(token, "#") {
+				break
+			}
+
+			switch token {
+			case "machine", "default":
+				entries = append(entries, netrcEntry{})
+				current = &entries[len(entries)-1]
+				if token == "default" {
+					defaultAt = len(entries) - 1
+					continue
+				}
+			case "login", "password", "account":
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+				continue
+			default:
+				return nil, fmt.Errorf("grequests: netrc line %d: unexpected token %q", lineNumber, token)
+			}
+
+			if i+1 == len(fields) {
+				return nil, fmt.Errorf("grequests: netrc line %d: %q has no value", lineNumber, token)
+			}
+			if current == nil {
+				return nil, fmt.Errorf("grequests: netrc line %d: %q outside of a machine", lineNumber, token)
+			}
+
+			i++
+			switch token {
+			case "machine":
+				current.machine = fields[i]
+			case "login":
+				current.Username = fields[i]
+			case "password":
+				current.Password = fields[i]
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if defaultAt != -1 {
+		entries = append(append(entries[:defaultAt:defaultAt], entries[defaultAt+1:]...), entries[defaultAt])
+	}
+	return entries, nil
+}
+
+// providerAuth sends the credentials a provider has for the host of the
+// request
+type providerAuth struct {
+	providers []CredentialProvider
+}
+
+func (p *providerAuth) Authenticate(req *http.Request) error {
+	// Credentials set explicitly as a header win
+	if req.Header.Get("Authorization") != "" {
+		return nil
+	}
+
+	for _, provider := range p.providers {
+		credentials, err := provider.Credentials(req.URL.Host)
+		if err != nil {
+			return err
+		}
+
+		if credentials != nil {
+			req.SetBasicAuth(credentials.Username, credentials.Password)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *providerAuth) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	return false, nil
+}
+
+// sensitiveHTTPHeaders returns the headers that must not follow a redirect
+// to another host. Credentials looked up by host and the header of an
+// `APIKey` are always part of them
+func sensitiveHTTPHeaders(ro *RequestOptions) map[string]struct{} {
+	headers := ro.SensitiveHTTPHeaders
+
+	var credentials []string
+	if len(ro.CredentialProviders) != 0 {
+		credentials = append(credentials, "Authorization")
+	}
+	if key, ok := ro.Authenticator.(*apiKey); ok && key.location == APIKeyInHeader {
+		credentials = append(credentials, http.CanonicalHeaderKey(key.name))
+	}
+
+	if len(credentials) == 0 {
+		return headers
+	}
+
+	if headers == nil {
+		headers = RequestSensitiveHTTPHeaders
+	}
+
+	missing := make(map[string]struct{}, len(credentials))
+	for _, header := range credentials {
+		if _, ok := headers[header]; !ok {
+			missing[header] = struct{}{}
+		}
+	}
+	if len(missing) == 0 {
+		return headers
+	}
+	return mergeMaps(headers, missing)
+}
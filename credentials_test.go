@@ -0,0 +1,188 @@
+package grequests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CredentialsSuite struct {
+	suite.Suite
+}
+
+const testNetrc = `# comment
+machine example.com login alice password secret
+machine other.example.com
+  login bob # trailing comment
+  password hunter2
+macdef init
+  cd /pub
+  get file
+
+default login anonymous password guest
+machine last.example.com login carol password pass
+`
+
+func (s *CredentialsSuite) writeNetrc(content string) string {
+	path := filepath.Join(s.T().TempDir(), ".netrc")
+	s.Require().NoError(os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func (s *CredentialsSuite) TestNetrc() {
+	provider := NetrcCredentials(s.writeNetrc(testNetrc))
+
+	tests := map[string]*Credentials{
+		"example.com":           {"alice", "secret"},
+		"EXAMPLE.com:8443":      {"alice", "secret"},
+		"other.example.com":     {"bob", "hunter2"},
+		"last.example.com":      {"carol", "pass"},
+		"unknown.example.com":   {"anonymous", "guest"},
+		"[::1]:8080":            {"anonymous", "guest"},
+		"sub.other.example.com": {"anonymous", "guest"},
+	}
+	for host, expected := range tests {
+		credentials, err := provider.Credentials(host)
+		s.Require().NoError(err, host)
+		s.Equal(expected, credentials, host)
+	}
+
+	credentials, err := NetrcCredentials(s.writeNetrc("machine example.com login alice password secret")).Credentials("other.com")
+	s.NoError(err)
+	s.Nil(credentials)
+}
+
+func (s *CredentialsSuite) TestNetrcErrors() {
+	for _, content := range []string{
+		"machine example.com login",
+		"login alice",
+		"machine example.com user alice",
+	} {
+		_, err := NetrcCredentials(s.writeNetrc(content)).Credentials("example.com")
+		s.Error(err, content)
+	}
+
+	_, err := NetrcCredentials(filepath.Join(s.T().TempDir(), "missing")).Credentials("example.com")
+	s.ErrorIs(err, os.ErrNotExist)
+}
+
+func (s *CredentialsSuite) TestDefaultNetrc() {
+	s.T().Setenv("NETRC", s.writeNetrc("machine example.com login alice password secret"))
+	credentials, err := NetrcCredentials("").Credentials("example.com")
+	s.Require().NoError(err)
+	s.Equal(&Credentials{"alice", "secret"}, credentials)
+
+	// A missing default file provides no credentials
+	s.T().Setenv("NETRC", filepath.Join(s.T().TempDir(), "missing"))
+	credentials, err = NetrcCredentials("").Credentials("example.com")
+	s.NoError(err)
+	s.Nil(credentials)
+}
+
+func (s *CredentialsSuite) TestEnvAndStatic() {
+	s.T().Setenv("TEST_API_EXAMPLE_COM_USERNAME", "env-user")
+	s.T().Setenv("TEST_API_EXAMPLE_COM_PASSWORD", "env-pass")
+	s.T().Setenv("TEST_API_EXAMPLE_COM_8443_USERNAME", "port-user")
+
+	env := EnvCredentials("TEST_")
+	credentials, err := env.Credentials("api.example.com")
+	s.Require().NoError(err)
+	s.Equal(&Credentials{"env-user", "env-pass"}, credentials)
+
+	credentials, err = env.Credentials("api.example.com:8443")
+	s.Require().NoError(err)
+	s.Equal(&Credentials{"port-user", ""}, credentials)
+
+	credentials, err = env.Credentials("other.example.com")
+	s.NoError(err)
+	s.Nil(credentials)
+
+	static := StaticCredentials(map[string]Credentials{
+		"example.com":      {"host", "pass"},
+		"example.com:8443": {"port", "pass"},
+	})
+	credentials, _ = static.Credentials("example.com:80")
+	s.Equal(&Credentials{"host", "pass"}, credentials)
+	credentials, _ = static.Credentials("example.com:8443")
+	s.Equal(&Credentials{"port", "pass"}, credentials)
+	credentials, _ = static.Credentials("other.com")
+	s.Nil(credentials)
+}
+
+// authorization returns the Authorization header received by the echo server
+func (s *CredentialsSuite) authorization(srv *httptest.Server, session *Session, options ...Option) string {
+	auth := AuthSuite{}
+	auth.SetT(s.T())
+	return auth.echo(srv, session, options...).Headers.Get("Authorization")
+}
+
+func (s *CredentialsSuite) TestRequest() {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	netrc := UseNetrc(s.writeNetrc("machine 127.0.0.1 login alice password secret"))
+	static := UseCredentialProvider(StaticCredentials(map[string]Credentials{host: {"static", "pass"}}))
+
+	s.Equal("Basic YWxpY2U6c2VjcmV0", s.authorization(srv, nil, netrc, static))
+	s.Equal("Basic c3RhdGljOnBhc3M=", s.authorization(srv, nil, static, netrc))
+
+	// Providers are only consulted when the request has no other authentication
+	s.Equal("Bearer token", s.authorization(srv, nil, netrc, BearerToken("token")))
+	s.Equal("Basic dXNlcjpwYXNz", s.authorization(srv, nil, netrc, FromRequestOptions(&RequestOptions{Auth: []string{"user", "pass"}})))
+	s.Equal("Custom", s.authorization(srv, nil, netrc, FromRequestOptions(&RequestOptions{Headers: map[string]string{"Authorization": "Custom"}})))
+
+	session := NewSession(netrc)
+	s.Equal("Basic YWxpY2U6c2VjcmV0", s.authorization(srv, session))
+
+	_, err := Get(context.Background(), srv.URL, UseNetrc(filepath.Join(s.T().TempDir(), "missing")))
+	s.ErrorIs(err, os.ErrNotExist)
+}
+
+func (s *CredentialsSuite) TestCredentialsStayOnHost() {
+	var received []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("Authorization"))
+	}))
+	defer other.Close()
+
+	// The same server seen as another host
+	otherURL, err := url.Parse(other.URL)
+	s.Require().NoError(err)
+	otherURL.Host = "localhost:" + otherURL.Port()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("Authorization"))
+		if r.URL.Path == "/same" {
+			http.Redirect(w, r, "/done", http.StatusFound)
+			return
+		}
+		if r.URL.Path == "/away" {
+			http.Redirect(w, r, otherURL.String(), http.StatusFound)
+		}
+	}))
+	defer origin.Close()
+
+	netrc := UseNetrc(s.writeNetrc("default login alice password secret"))
+
+	_, err = Get(context.Background(), origin.URL+"/same", netrc)
+	s.Require().NoError(err)
+	s.Equal([]string{"Basic YWxpY2U6c2VjcmV0", "Basic YWxpY2U6c2VjcmV0"}, received)
+
+	// Even when Authorization isn't part of the sensitive headers
+	received = nil
+	_, err = Get(context.Background(), origin.URL+"/away", netrc, SensitiveHTTPHeaders("X-Secret"))
+	s.Require().NoError(err)
+	s.Equal([]string{"Basic YWxpY2U6c2VjcmV0", ""}, received)
+}
+
+func TestCredentialsSuite(t *testing.T) {
+	suite.Run(t, new(CredentialsSuite))
+}
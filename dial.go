@@ -0,0 +1,121 @@
+package grequests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// unixSocketScheme is the scheme of URLs that name the Unix socket to connect
+// to in their (path escaped) host e.g. http+unix://%2Fvar%2Frun%2Fdocker.sock/info
+const unixSocketScheme = "http+unix://"
+
+var errUnixSocketHTTPClient = errors.New("grequests: http+unix URLs can't be used with a custom HTTPClient")
+
+// parseUnixSocketURL returns the socket named by an http+unix URL and the
+// http URL sent over it. ok is false for any other URL
+func parseUnixSocketURL(userURL string) (socket, httpURL string, ok bool, err error) {
+	if len(userURL) < len(unixSocketScheme) || !strings.EqualFold(userURL[:len(unixSocketScheme)], unixSocketScheme) {
+		return "", "", false, nil
+	}
+
+	rest := userURL[len(unixSocketScheme):]
+	end := strings.IndexAny(rest, "/?#")
+	if end == -1 {
+		end = len(rest)
+	}
+
+	socket, err = url.PathUnescape(rest[:end])
+	if err != nil {
+		return "", "", true, fmt.Errorf("grequests: invalid Unix socket in %q: %w", userURL, err)
+	}
+	if socket == "" {
+		return "", "", true, fmt.Errorf("grequests: no Unix socket in %q", userURL)
+	}
+
+	return socket, "http://localhost" + rest[end:], true, nil
+}
+
+// dialContext returns the function the transport connects with. Connections
+// are made with `DialContext` (when set) to the `UnixSocket` (when set) or to
+// the address requested, once `ResolveOverrides` have been applied, through
+// the SOCKS proxy chosen for the request (if any). `DialTimeout` applies to
+// every one of them while the DNS cache is only used by our own dialer.
+func (ro RequestOptions) dialContext() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	resolver := ro.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	lookup := resolver.LookupIPAddr
+	var cache *dnsCache
+	if ro.DNSCacheTTL > 0 {
+		cache = newDNSCache(resolver, ro.DNSCacheTTL)
+		lookup = cache.lookup
+	}
+
+	dial := ro.DialContext
+	if dial == nil {
+		dialer := &net.Dialer{
+			Timeout:   ro.DialTimeout,
+			KeepAlive: ro.DialKeepAlive,
+			LocalAddr: ro.LocalAddr,
+			Resolver:  ro.Resolver,
+		}
+		unixDialer := &net.Dialer{Timeout: ro.DialTimeout}
+
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			switch {
+			case network == "unix":
+				// A TCP local address can't be used to connect to a socket
+				return unixDialer.DialContext(ctx, network, addr)
+			case cache != nil:
+				return cache.dial(ctx, dialer, network, addr)
+			}
+			return dialer.DialContext(ctx, network, addr)
+		}
+	} else if ro.DialTimeout != 0 {
+		custom := dial
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, ro.DialTimeout)
+			defer cancel()
+			return custom(ctx, network, addr)
+		}
+	}
+
+	if ro.UnixSocket != "" {
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dial(ctx, "unix", ro.UnixSocket)
+		}
+	}
+
+	overrides := normalizeOverrides(ro.ResolveOverrides)
+	connect := contextDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dial(ctx, network, resolveOverride(overrides, addr))
+	})
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if routes := contextSOCKSRoutes(ctx); routes != nil {
+			if route, ok := routes.get(addr); ok {
+				conn, err := dialSOCKS(ctx, route.proxy, connect, lookup, network, resolveOverride(overrides, route.target))
+				if err != nil {
+					return nil, err
+				}
+				return &socksHandshakeConn{Conn: conn}, nil
+			}
+		}
+		return connect(ctx, network, addr)
+	}
+}
+
+func firstDialContext(values ...func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	for _, v := range values {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package grequests
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type DialSuite struct {
+	suite.Suite
+}
+
+func (s *DialSuite) SetupTest() {
+	ResetClientPool()
+}
+
+// unixServer serves requests on a Unix socket, responding with the name of
+// the server, the host and the URL of the request and setting a cookie
+func (s *DialSuite) unixServer(name string) string {
+	// Socket paths are limited to about a hundred bytes, test directories
+	// can be longer than that
+	dir, err := os.MkdirTemp("", "grequests")
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name+".sock")
+	listener, err := net.Listen("unix", path)
+	s.Require().NoError(err)
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, _ := r.Cookie("visited")
+		http.SetCookie(w, &http.Cookie{Name: "visited", Value: "yes"})
+		_, _ = fmt.Fprintf(w, "%s %s %s %v", name, r.Host, r.URL, cookie != nil)
+	})}
+	go func() { _ = srv.Serve(listener) }()
+	s.T().Cleanup(func() { _ = srv.Close() })
+
+	return path
+}
+
+func (s *DialSuite) TestUnixSocket() {
+	socket := s.unixServer("a")
+
+	resp, err := Get(context.Background(), "http://example.com/info?a=b", UnixSocket(socket))
+	s.Require().NoError(err)
+	s.Equal("a example.com /info?a=b false", resp.String())
+
+	// Transport settings still apply and proxies are never used
+	proxyURL, _ := url.Parse("http://127.0.0.1:1")
+	resp, err = Get(context.Background(), "http://example.com/info", UnixSocket(socket), DialTimeout(time.Second),
+		LocalAddr(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}), Proxies(map[string]*url.URL{"http": proxyURL}))
+	s.Require().NoError(err)
+	s.Equal("a example.com /info false", resp.String())
+
+	_, err = Get(context.Background(), "http://example.com/", UnixSocket(filepath.Join(s.T().TempDir(), "missing.sock")))
+	s.ErrorIs(err, os.ErrNotExist)
+}
+
+func (s *DialSuite) TestUnixSocketURL() {
+	a, b := s.unixServer("a"), s.unixServer("b")
+
+	resp, err := Get(context.Background(), "http+unix://"+url.PathEscape(a)+"/info?a=b")
+	s.Require().NoError(err)
+	s.Equal("a localhost /info?a=b false", resp.String())
+
+	resp, err = Get(context.Background(), "HTTP+UNIX://"+url.PathEscape(b), Host("docker"))
+	s.Require().NoError(err)
+	s.Equal("b docker / false", resp.String())
+
+	// Sessions connect to the socket of the URL with their cookies
+	session := NewSession(UseCookieJar())
+	defer session.CloseIdleConnections()
+	resp, err = session.Get(context.Background(), "http+unix://"+url.PathEscape(a)+"/one")
+	s.Require().NoError(err)
+	s.Equal("a localhost /one false", resp.String())
+
+	resp, err = session.Get(context.Background(), "http+unix://"+url.PathEscape(a)+"/two")
+	s.Require().NoError(err)
+	s.Equal("a localhost /two true", resp.String())
+
+	for _, invalid := range []string{"http+unix:///info", "http+unix://%zz/info"} {
+		_, err = Get(context.Background(), invalid)
+		s.Error(err, invalid)
+	}
+
+	_, err = Get(context.Background(), "http+unix://"+url.PathEscape(a), HTTPClient(http.DefaultClient))
+	s.ErrorIs(err, errUnixSocketHTTPClient)
+}
+
+func (s *DialSuite) TestDialContext() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.String()))
+	}))
+	defer srv.Close()
+
+	var (
+		mu     sync.Mutex
+		dialed []string
+	)
+	dial := DialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, network+" "+addr)
+		mu.Unlock()
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	})
+
+	resp, err := Get(context.Background(), srv.URL+"/direct", dial)
+	s.Require().NoError(err)
+	s.Equal("/direct", resp.String())
+
+	// The proxy is reached through the dialer
+	proxyURL, _ := url.Parse(srv.URL)
+	resp, err = Get(context.Background(), "http://example.com/proxied", dial, Proxies(map[string]*url.URL{"http": proxyURL}))
+	s.Require().NoError(err)
+	s.Equal("http://example.com/proxied", resp.String())
+
+	// And so is the socket
+	socket := s.unixServer("a")
+	resp, err = Get(context.Background(), "http+unix://"+url.PathEscape(socket), dial)
+	s.Require().NoError(err)
+	s.Equal("a localhost / false", resp.String())
+
+	s.Equal([]string{"tcp " + proxyURL.Host, "tcp " + proxyURL.Host, "unix " + socket}, dialed)
+}
+
+func (s *DialSuite) TestSessionRequestOptions() {
+	socket := s.unixServer("a")
+
+	session := NewSession()
+	defer session.CloseIdleConnections()
+
+	resp, err := session.Get(context.Background(), "http://example.com/one", UnixSocket(socket))
+	s.Require().NoError(err)
+	s.Equal("a example.com /one false", resp.String())
+
+	// The dialer of the request is used along with the session cookies
+	var dialed []string
+	resp, err = session.Get(context.Background(), "http://example.com/two",
+		DialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		}))
+	s.Require().NoError(err)
+	s.Equal("a example.com /two true", resp.String())
+	s.Equal([]string{"example.com:80"}, dialed)
+}
+
+func (s *DialSuite) TestDialTimeout() {
+	blocking := DialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	start := time.Now()
+	_, err := Get(context.Background(), "http://example.com/", blocking, DialTimeout(50*time.Millisecond))
+	s.ErrorIs(err, ErrTimeout)
+	s.Less(time.Since(start), 5*time.Second)
+}
+
+func TestDialSuite(t *testing.T) {
+	suite.Run(t, new(DialSuite))
+}
@@ -0,0 +1,264 @@
+package grequests
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// digestAuth implements RFC 7616 HTTP Digest authentication. The challenge of
+// every host is cached so that only the first request to a host needs an
+// extra round trip
+type digestAuth struct {
+	username, password string
+
+	mu         sync.Mutex
+	challenges map[string]*digestChallenge
+}
+
+// digestChallenge is the state of the digest authentication with a host
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	nc        uint32
+}
+
+var digestHashes = map[string]func() hash.Hash{
+	"MD5":     md5.New,
+	"SHA-256": sha256.New,
+}
+
+func (d *digestAuth) Apply(ro *RequestOptions) {
+	ro.Authenticator = d
+}
+
+func (d *digestAuth) Authenticate(req *http.Request) error {
+	d.mu.Lock()
+	challenge, ok := d.challenges[req.URL.Host]
+	if !ok {
+		d.mu.Unlock()
+		return nil
+	}
+	challenge.nc++
+	c := *challenge
+	d.mu.Unlock()
+
+	cnonce, err := newCnonce()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", d.authorization(&c, req.Method, req.URL.RequestURI(), cnonce))
+	return nil
+}
+
+func (d *digestAuth) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	var challenge *digestChallenge
+	stale := false
+	for _, c := range parseAuthChallenges(resp.Header.Values("WWW-Authenticate")) {
+		if !strings.EqualFold(c.scheme, "Digest") {
+			continue
+		}
+
+		if parsed, ok := newDigestChallenge(c.params); ok {
+			// Prefer the strongest algorithm offered
+			if challenge == nil || strings.HasPrefix(parsed.algorithm, "SHA-256") {
+				challenge = parsed
+				stale = strings.EqualFold(c.params["stale"], "true")
+			}
+		}
+	}
+
+	if challenge == nil {
+		return false, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Our credentials were rejected for a nonce that is still valid, sending
+	// them again won't help
+	if previous, ok := d.challenges[req.URL.Host]; ok && previous.nonce == challenge.nonce && !stale &&
+		req.Header.Get("Authorization") != "" {
+		return false, nil
+	}
+
+	if d.challenges == nil {
+		d.challenges = map[string]*digestChallenge{}
+	}
+	d.challenges[req.URL.Host] = challenge
+	return true, nil
+}
+
+func newDigestChallenge(params map[string]string) (*digestChallenge, bool) {
+	challenge := &digestChallenge{
+		realm:     params["realm"],
+		nonce:     params["nonce"],
+		opaque:    params["opaque"],
+		algorithm: strings.ToUpper(params["algorithm"]),
+	}
+
+	if challenge.nonce == "" {
+		return nil, false
+	}
+
+	if challenge.algorithm == "" {
+		challenge.algorithm = "MD5"
+	}
+
+	if _, ok := digestHashes[strings.TrimSuffix(challenge.algorithm, "-SESS")]; !ok {
+		return nil, false
+	}
+
+	// Only qop=auth is supported, without qop we fall back to RFC 2069
+	if qop, ok := params["qop"]; ok {
+		for _, option := range strings.Split(qop, ",") {
+			if strings.TrimSpace(option) == "auth" {
+				challenge.qop = "auth"
+			}
+		}
+		if challenge.qop == "" {
+			return nil, false
+		}
+	}
+
+	return challenge, true
+}
+
+func (d *digestAuth) authorization(c *digestChallenge, method, uri, cnonce string) string {
+	nc := fmt.Sprintf("%08x", c.nc)
+
+	fields := []string{
+		fmt.Sprintf(`username="%s"`, escapeQuotes(d.username)),
+		fmt.Sprintf(`realm="%s"`, escapeQuotes(c.realm)),
+		fmt.Sprintf(`nonce="%s"`, escapeQuotes(c.nonce)),
+		fmt.Sprintf(`uri="%s"`, escapeQuotes(uri)),
+		"algorithm=" + c.algorithm,
+		fmt.Sprintf(`response="%s"`, d.response(c, method, uri, nc, cnonce)),
+	}
+
+	if c.opaque != "" {
+		fields = append(fields, fmt.Sprintf(`opaque="%s"`, escapeQuotes(c.opaque)))
+	}
+
+	if c.qop != "" {
+		fields = append(fields, "qop="+c.qop, "nc="+nc, fmt.Sprintf(`cnonce="%s"`, cnonce))
+	}
+
+	return "Digest " + strings.Join(fields, ", ")
+}
+
+// response computes the request digest as described in RFC 7616 section 3.4.1
+func (d *digestAuth) response(c *digestChallenge, method, uri, nc, cnonce string) string {
+	sess := strings.HasSuffix(c.algorithm, "-SESS")
+	newHash := digestHashes[strings.TrimSuffix(c.algorithm, "-SESS")]
+	h := func(parts ...string) string {
+		digest := newHash()
+		digest.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(digest.Sum(nil))
+	}
+
+	ha1 := h(d.username, c.realm, d.password)
+	if sess {
+		ha1 = h(ha1, c.nonce, cnonce)
+	}
+
+	ha2 := h(method, uri)
+
+	if c.qop == "" {
+		return h(ha1, c.nonce, ha2)
+	}
+	return h(ha1, c.nonce, nc, cnonce, c.qop, ha2)
+}
+
+func newCnonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// authChallenge is a single challenge of a WWW-Authenticate header
+type authChallenge struct {
+	scheme string
+	params map[string]string
+}
+
+// parseAuthChallenges parses WWW-Authenticate header values (RFC 9110
+// section 11.6.1), a single value may hold several challenges
+func parseAuthChallenges(values []string) []authChallenge {
+	var challenges []authChallenge
+
+	for _, value := range values {
+		for len(value) != 0 {
+			value = strings.TrimLeft(value, " \t,")
+			if value == "" {
+				break
+			}
+
+			var token string
+			token, value = readAuthToken(value)
+			if token == "" {
+				// Skip the character we don't understand
+				value = value[1:]
+				continue
+			}
+
+			rest := strings.TrimLeft(value, " \t")
+			if !strings.HasPrefix(rest, "=") || len(challenges) == 0 {
+				challenges = append(challenges, authChallenge{scheme: token, params: map[string]string{}})
+				continue
+			}
+
+			var paramValue string
+			paramValue, value = readAuthParamValue(strings.TrimLeft(rest[1:], " \t"))
+			challenges[len(challenges)-1].params[strings.ToLower(token)] = paramValue
+		}
+	}
+
+	return challenges
+}
+
+func readAuthToken(s string) (string, string) {
+	end := strings.IndexAny(s, " \t,=\"")
+	if end == -1 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+func readAuthParamValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexAny(s, " \t,")
+		if end == -1 {
+			return s, ""
+		}
+		return s[:end], s[end:]
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), ""
+}
@@ -0,0 +1,305 @@
+package grequests
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type DigestAuthSuite struct {
+	suite.Suite
+}
+
+// digestServer checks the digest of every request against the password
+// "Circle of Life" and echoes the request body
+type digestServer struct {
+	*httptest.Server
+
+	algorithm string
+	qop       string
+	// nonceUses is how many requests a nonce is valid for (0 is unlimited)
+	nonceUses int
+	// unavailable is how many authenticated requests are answered with a 503
+	unavailable int
+
+	mu         sync.Mutex
+	hits       int
+	challenges int
+	nonce      int
+	uses       int
+	ncs        []string
+}
+
+func newDigestServer(algorithm string) *digestServer {
+	ds := &digestServer{algorithm: algorithm, qop: `"auth,auth-int"`}
+	ds.Server = httptest.NewServer(http.HandlerFunc(ds.handle))
+	return ds
+}
+
+func (ds *digestServer) handle(w http.ResponseWriter, r *http.Request) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.hits++
+
+	body, _ := io.ReadAll(r.Body)
+
+	stale, ok := ds.check(r)
+	if !ok {
+		ds.challenges++
+		challenge := fmt.Sprintf(`Digest realm="test@grequests", nonce="nonce-%d", opaque="opaque", algorithm=%s`, ds.nonce, ds.algorithm)
+		if ds.qop != "" {
+			challenge += ", qop=" + ds.qop
+		}
+		if stale {
+			challenge += ", stale=true"
+		}
+		w.Header().Add("WWW-Authenticate", `Basic realm="test@grequests"`)
+		w.Header().Add("WWW-Authenticate", challenge)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if ds.unavailable > 0 {
+		ds.unavailable--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	_, _ = w.Write(body)
+}
+
+// check returns whether the nonce used by the request is stale and whether
+// the request is authenticated
+func (ds *digestServer) check(r *http.Request) (bool, bool) {
+	challenges := parseAuthChallenges([]string{r.Header.Get("Authorization")})
+	if len(challenges) != 1 || challenges[0].scheme != "Digest" {
+		return false, false
+	}
+	params := challenges[0].params
+
+	if params["nonce"] != fmt.Sprintf("nonce-%d", ds.nonce) {
+		return true, false
+	}
+
+	if ds.nonceUses != 0 && ds.uses >= ds.nonceUses {
+		ds.nonce++
+		ds.uses = 0
+		return true, false
+	}
+
+	var newHash func() hash.Hash = md5.New
+	if strings.HasPrefix(ds.algorithm, "SHA-256") {
+		newHash = sha256.New
+	}
+	h := func(s string) string {
+		digest := newHash()
+		digest.Write([]byte(s))
+		return hex.EncodeToString(digest.Sum(nil))
+	}
+
+	ha1 := h("Mufasa:test@grequests:Circle of Life")
+	if strings.HasSuffix(ds.algorithm, "-sess") {
+		ha1 = h(ha1 + ":" + params["nonce"] + ":" + params["cnonce"])
+	}
+	ha2 := h(r.Method + ":" + r.URL.RequestURI())
+
+	expected := h(ha1 + ":" + params["nonce"] + ":" + ha2)
+	if ds.qop != "" {
+		expected = h(ha1 + ":" + params["nonce"] + ":" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+	}
+
+	if params["response"] != expected || params["uri"] != r.URL.RequestURI() || params["opaque"] != "opaque" {
+		return false, false
+	}
+
+	ds.uses++
+	ds.ncs = append(ds.ncs, params["nc"])
+	return false, true
+}
+
+func (ds *digestServer) stats() (int, int) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.hits, ds.challenges
+}
+
+func (s *DigestAuthSuite) TestAlgorithms() {
+	for _, algorithm := range []string{"MD5", "MD5-sess", "SHA-256", "SHA-256-sess"} {
+		s.Run(algorithm, func() {
+			srv := newDigestServer(algorithm)
+			defer srv.Close()
+
+			resp, err := Get(context.Background(), srv.URL+"/dir/index.html?a=b", DigestAuth("Mufasa", "Circle of Life"))
+			s.Require().NoError(err)
+			s.Equal(http.StatusOK, resp.StatusCode)
+
+			hits, challenges := srv.stats()
+			s.Equal(2, hits)
+			s.Equal(1, challenges)
+		})
+	}
+}
+
+func (s *DigestAuthSuite) TestWithoutQop() {
+	srv := newDigestServer("MD5")
+	srv.qop = ""
+	defer srv.Close()
+
+	resp, err := Get(context.Background(), srv.URL, DigestAuth("Mufasa", "Circle of Life"))
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+}
+
+func (s *DigestAuthSuite) TestSessionCachesNonce() {
+	srv := newDigestServer("SHA-256")
+	defer srv.Close()
+
+	session := NewSession(DigestAuth("Mufasa", "Circle of Life"))
+	for i := 0; i < 3; i++ {
+		resp, err := session.Get(context.Background(), srv.URL)
+		s.Require().NoError(err)
+		s.Equal(http.StatusOK, resp.StatusCode)
+	}
+
+	hits, challenges := srv.stats()
+	s.Equal(4, hits)
+	s.Equal(1, challenges)
+	s.Equal([]string{"00000001", "00000002", "00000003"}, srv.ncs)
+}
+
+func (s *DigestAuthSuite) TestStaleNonce() {
+	srv := newDigestServer("MD5")
+	srv.nonceUses = 2
+	defer srv.Close()
+
+	session := NewSession(DigestAuth("Mufasa", "Circle of Life"))
+	for i := 0; i < 3; i++ {
+		resp, err := session.Get(context.Background(), srv.URL)
+		s.Require().NoError(err)
+		s.Equal(http.StatusOK, resp.StatusCode)
+	}
+
+	hits, challenges := srv.stats()
+	s.Equal(5, hits)
+	s.Equal(2, challenges)
+	s.Equal([]string{"00000001", "00000002", "00000001"}, srv.ncs)
+}
+
+func (s *DigestAuthSuite) TestRetriesUseNewNonceCount() {
+	srv := newDigestServer("SHA-256")
+	srv.unavailable = 2
+	defer srv.Close()
+
+	session := NewSession(DigestAuth("Mufasa", "Circle of Life"), Retry(fastRetry))
+	for i := 0; i < 2; i++ {
+		resp, err := session.Get(context.Background(), srv.URL)
+		s.Require().NoError(err)
+		s.Equal(http.StatusOK, resp.StatusCode)
+	}
+
+	// Every attempt has its own nonce count, including the retries
+	s.Equal([]string{"00000001", "00000002", "00000003", "00000004"}, srv.ncs)
+}
+
+func (s *DigestAuthSuite) TestReplaysBody() {
+	srv := newDigestServer("SHA-256")
+	defer srv.Close()
+
+	resp, err := Post(context.Background(), srv.URL, DigestAuth("Mufasa", "Circle of Life"), JSON(`{"a":1}`))
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal(`{"a":1}`, resp.String())
+}
+
+func (s *DigestAuthSuite) TestWrongPassword() {
+	srv := newDigestServer("MD5")
+	defer srv.Close()
+
+	session := NewSession(DigestAuth("Mufasa", "wrong"))
+	resp, err := session.Get(context.Background(), srv.URL)
+	s.Require().NoError(err)
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	hits, _ := srv.stats()
+	s.Equal(2, hits)
+
+	// The cached nonce is known to be rejected, the challenge isn't answered
+	resp, err = session.Get(context.Background(), srv.URL)
+	s.Require().NoError(err)
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	hits, _ = srv.stats()
+	s.Equal(3, hits)
+}
+
+func (s *DigestAuthSuite) TestUnsupportedChallenge() {
+	for name, srv := range map[string]*digestServer{
+		"algorithm": newDigestServer("SHA-512-256"),
+		"qop":       newDigestServer("MD5"),
+	} {
+		s.Run(name, func() {
+			defer srv.Close()
+			if name == "qop" {
+				srv.qop = "auth-int"
+			}
+
+			resp, err := Get(context.Background(), srv.URL, DigestAuth("Mufasa", "Circle of Life"))
+			s.Require().NoError(err)
+			s.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+			hits, _ := srv.stats()
+			s.Equal(1, hits)
+		})
+	}
+}
+
+func (s *DigestAuthSuite) TestResponse() {
+	// The examples of RFC 2617 section 3.5 and RFC 7616 section 3.9.1
+	tests := []struct {
+		password, realm, nonce, cnonce, algorithm, expected string
+	}{
+		{"Circle Of Life", "testrealm@host.com", "dcd98b7102dd2f0e8b11d0f600bfb0c093", "0a4f113b", "MD5", "6629fae49393a05397450978507c4ef1"},
+		{"Circle of Life", "http-auth@example.org", "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", "MD5", "8ca523f5e9506fed4657c9700eebdbec"},
+		{"Circle of Life", "http-auth@example.org", "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", "SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	}
+
+	for _, test := range tests {
+		auth := &digestAuth{username: "Mufasa", password: test.password}
+		challenge := &digestChallenge{realm: test.realm, nonce: test.nonce, algorithm: test.algorithm, qop: "auth"}
+		s.Equal(test.expected, auth.response(challenge, "GET", "/dir/index.html", "00000001", test.cnonce))
+	}
+}
+
+func (s *DigestAuthSuite) TestParseAuthChallenges() {
+	challenges := parseAuthChallenges([]string{
+		`Basic realm="simple", Digest realm="a \"quoted\" realm", qop="auth, auth-int", nonce=abc,algorithm=MD5`,
+		`Newauth`,
+	})
+
+	s.Require().Len(challenges, 3)
+	s.Equal("Basic", challenges[0].scheme)
+	s.Equal(map[string]string{"realm": "simple"}, challenges[0].params)
+	s.Equal("Digest", challenges[1].scheme)
+	s.Equal(map[string]string{
+		"realm":     `a "quoted" realm`,
+		"qop":       "auth, auth-int",
+		"nonce":     "abc",
+		"algorithm": "MD5",
+	}, challenges[1].params)
+	s.Equal("Newauth", challenges[2].scheme)
+}
+
+func TestDigestAuthSuite(t *testing.T) {
+	suite.Run(t, new(DigestAuthSuite))
+}
@@ -0,0 +1,397 @@
+package grequests
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned by `Download` when the downloaded file
+// doesn't match the expected SHA-256 checksum or a digest sent by the server
+var ErrChecksumMismatch = errors.New("grequests: checksum mismatch")
+
+// Download fetches the URL into the file dest. The file is first written to
+// `dest + ".part"` and only renamed to dest once it is complete (and verified),
+// so dest is never left truncated.
+//
+// If a previous download was interrupted it is resumed using a `Range` request
+// guarded by `If-Range`, so the partial file is only reused if the resource
+// didn't change. The file is verified against the `ExpectSHA256` option and
+// against the `Repr-Digest`, `Content-Digest` and `Digest` headers sent by the
+// server (SHA-256 and SHA-512 are supported). The modification time of the
+// file is set from the `Last-Modified` header.
+//
+// With the `DownloadSegments` option the file is fetched with concurrent range
+// requests when the server advertises `Accept-Ranges: bytes`, each segment is
+// retried on its own according to the `Retry` policy (segments aren't retried
+// without one). Servers without range support are downloaded with a single
+// request.
+//
+// The returned response has already been read and closed.
+func Download(ctx context.Context, url, dest string, options ...Option) (*Response, error) {
+	ro := &RequestOptions{}
+	applyOptions(ro, options)
+
+	return download(ctx, dest, ro, func(ctx context.Context, verb string, extra ...Option) (*Response, error) {
+		return Request(ctx, verb, url, append(append([]Option(nil), options...), extra...)...)
+	})
+}
+
+// Download fetches the URL into the file dest using the session. See
+// `Download` for the details
+func (s *Session) Download(ctx context.Context, url, dest string, options ...Option) (*Response, error) {
+	ro := &RequestOptions{}
+	applyOptions(ro, options)
+	ro = s.combineRequestOptions(ro)
+
+	return download(ctx, dest, ro, func(ctx context.Context, verb string, extra ...Option) (*Response, error) {
+		return s.Request(ctx, verb, url, append(append([]Option(nil), options...), extra...)...)
+	})
+}
+
+// downloadRequester sends a request for the resource being downloaded, the
+// extra options are applied after the ones provided by the user
+type downloadRequester func(ctx context.Context, verb string, extra ...Option) (*Response, error)
+
+// withHeaders sets the headers (on top of the ones already set)
+func withHeaders(headers map[string]string) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.Headers = mergeMaps(ro.Headers, headers)
+	})
+}
+
+// downloadState is stored next to the partial file, it holds the validators
+// that are needed to safely resume the download
+type downloadState struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func newDownloadState(header http.Header) downloadState {
+	state := downloadState{LastModified: header.Get("Last-Modified")}
+
+	// Only strong validators can be used with If-Range
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		state.ETag = etag
+	}
+	return state
+}
+
+func (d downloadState) validator() string {
+	if d.ETag != "" {
+		return d.ETag
+	}
+	return d.LastModified
+}
+
+type downloadFiles struct {
+	part  string
+	state string
+}
+
+func newDownloadFiles(dest string) downloadFiles {
+	return downloadFiles{part: dest + ".part", state: dest + ".part.json"}
+}
+
+// resumeOffset returns the size of the partial file and the validator to use
+// with If-Range (zero if the download cannot be resumed)
+func (f downloadFiles) resumeOffset() (int64, string) {
+	info, err := os.Stat(f.part)
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+		return 0, ""
+	}
+
+	b, err := os.ReadFile(f.state)
+	if err != nil {
+		return 0, ""
+	}
+
+	var state downloadState
+	if json.Unmarshal(b, &state) != nil || state.validator() == "" {
+		return 0, ""
+	}
+	return info.Size(), state.validator()
+}
+
+func (f downloadFiles) saveState(state downloadState) error {
+	if state.validator() == "" {
+		// Without a validator the download cannot be safely resumed
+		if err := os.Remove(f.state); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.state, b, 0o644)
+}
+
+func (f downloadFiles) remove() {
+	_ = os.Remove(f.part)
+	_ = os.Remove(f.state)
+}
+
+func download(ctx context.Context, dest string, ro *RequestOptions, get downloadRequester) (*Response, error) {
+	var expected []byte
+	if ro.ExpectedSHA256 != "" {
+		var err error
+		if expected, err = hex.DecodeString(ro.ExpectedSHA256); err != nil || len(expected) != sha256.Size {
+			return nil, fmt.Errorf("grequests: invalid SHA-256 checksum %q", ro.ExpectedSHA256)
+		}
+	}
+
+	files := newDownloadFiles(dest)
+
+	// An interrupted download is always resumed as a single stream
+	if offset, _ := files.resumeOffset(); offset == 0 && ro.DownloadSegments > 1 {
+		if resp, handled, err := downloadSegments(ctx, files, dest, expected, ro, get); handled {
+			return resp, err
+		}
+	}
+
+	return downloadStream(ctx, files, dest, expected, get)
+}
+
+// downloadStream downloads (or resumes downloading) the file with a single request
+func downloadStream(ctx context.Context, files downloadFiles, dest string, expected []byte, get downloadRequester) (*Response, error) {
+	offset, validator := files.resumeOffset()
+
+	headers := map[string]string{}
+	if offset > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+		headers["If-Range"] = validator
+	}
+
+	resp, err := get(ctx, "GET", withHeaders(headers))
+
+	if offset > 0 && resp != nil && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// The partial file doesn't match the resource anymore – start over
+		_ = resp.Close()
+		files.remove()
+		return downloadStream(ctx, files, dest, expected, get)
+	}
+
+	if err != nil {
+		return resp, err
+	}
+
+	defer func() { _ = resp.Close() }()
+
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			return resp, fmt.Errorf("grequests: unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+	case resp.Ok:
+		// The resource changed (or the server doesn't support ranges)
+		offset = 0
+	default:
+		return resp, resp.RaiseForStatus()
+	}
+
+	verifier := newDownloadVerifier(expected, resp)
+
+	fd, err := os.OpenFile(files.part, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return resp, err
+	}
+
+	if err := prepareDownloadFile(fd, offset, verifier); err != nil {
+		_ = fd.Close()
+		return resp, err
+	}
+
+	if err := files.saveState(newDownloadState(resp.Header)); err != nil {
+		_ = fd.Close()
+		return resp, err
+	}
+
+	// On failure the partial file is kept so that the download can be resumed
+	if _, err := io.Copy(io.MultiWriter(fd, verifier), resp); err != nil {
+		_ = fd.Close()
+		return resp, err
+	}
+
+	if err := verifier.verify(); err != nil {
+		_ = fd.Close()
+		files.remove()
+		return resp, err
+	}
+
+	return resp, files.complete(fd, dest, resp.Header)
+}
+
+// complete syncs the partial file and moves it to dest
+func (f downloadFiles) complete(fd *os.File, dest string, header http.Header) error {
+	if err := fd.Sync(); err != nil {
+		_ = fd.Close()
+		return err
+	}
+
+	if err := fd.Close(); err != nil {
+		return err
+	}
+
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		if err := os.Chtimes(f.part, lastModified, lastModified); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(f.part, dest); err != nil {
+		return err
+	}
+
+	_ = os.Remove(f.state)
+	return nil
+}
+
+// prepareDownloadFile truncates the file to offset and hashes the bytes that
+// are kept
+func prepareDownloadFile(fd *os.File, offset int64, verifier *downloadVerifier) error {
+	if err := fd.Truncate(offset); err != nil {
+		return err
+	}
+
+	if offset > 0 {
+		if _, err := io.Copy(verifier.existing(), io.LimitReader(fd, offset)); err != nil {
+			return err
+		}
+	}
+
+	_, err := fd.Seek(offset, io.SeekStart)
+	return err
+}
+
+// contentRangeStart parses the first byte position of a `bytes` Content-Range
+func contentRangeStart(contentRange string) (int64, bool) {
+	rangeSpec, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, false
+	}
+
+	start, _, ok := strings.Cut(rangeSpec, "-")
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(start, 10, 64)
+	return n, err == nil
+}
+
+// digestCheck compares the hash of the download with the expected value
+type digestCheck struct {
+	source   string
+	hash     hash.Hash
+	expected []byte
+
+	// contentOnly checks only cover the bytes received in this response
+	contentOnly bool
+}
+
+type downloadVerifier struct {
+	checks []*digestCheck
+}
+
+func newDownloadVerifier(expectedSHA256 []byte, resp *Response) *downloadVerifier {
+	verifier := &downloadVerifier{}
+
+	if expectedSHA256 != nil {
+		verifier.checks = append(verifier.checks, &digestCheck{source: "SHA-256", hash: sha256.New(), expected: expectedSHA256})
+	}
+
+	// The digests sent by the server cover the encoded body – which we no
+	// longer have if the transport decompressed it for us
+	if resp.RawResponse != nil && resp.RawResponse.Uncompressed {
+		return verifier
+	}
+
+	for _, header := range []string{"Repr-Digest", "Content-Digest", "Digest"} {
+		for algorithm, expected := range parseDigestHeader(header, resp.Header.Get(header)) {
+			newHash := digestAlgorithms[algorithm]
+			verifier.checks = append(verifier.checks, &digestCheck{
+				source:      header + " " + algorithm,
+				hash:        newHash(),
+				expected:    expected,
+				contentOnly: header == "Content-Digest",
+			})
+		}
+	}
+
+	return verifier
+}
+
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// parseDigestHeader parses both the RFC 9530 (`sha-256=:base64:`) and the
+// RFC 3230 (`SHA-256=base64`) formats. Unsupported algorithms are skipped.
+func parseDigestHeader(header, value string) map[string][]byte {
+	digests := map[string][]byte{}
+	for _, member := range strings.Split(value, ",") {
+		algorithm, encoded, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok {
+			continue
+		}
+
+		algorithm = strings.ToLower(algorithm)
+		if _, supported := digestAlgorithms[algorithm]; !supported {
+			continue
+		}
+
+		if header != "Digest" {
+			// Structured field byte sequences are wrapped in colons
+			encoded = strings.TrimSuffix(strings.TrimPrefix(encoded, ":"), ":")
+		}
+
+		if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			digests[algorithm] = decoded
+		}
+	}
+	return digests
+}
+
+// existing returns a writer for the bytes that were downloaded previously
+func (v *downloadVerifier) existing() io.Writer {
+	var writers []io.Writer
+	for _, check := range v.checks {
+		if !check.contentOnly {
+			writers = append(writers, check.hash)
+		}
+	}
+	return io.MultiWriter(writers...)
+}
+
+// Write receives the bytes of this response
+func (v *downloadVerifier) Write(p []byte) (int, error) {
+	for _, check := range v.checks {
+		check.hash.Write(p)
+	}
+	return len(p), nil
+}
+
+func (v *downloadVerifier) verify() error {
+	for _, check := range v.checks {
+		if actual := check.hash.Sum(nil); !bytes.Equal(actual, check.expected) {
+			return fmt.Errorf("%w: %s expected %x, got %x", ErrChecksumMismatch, check.source, check.expected, actual)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package grequests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errResourceChanged is returned when the server stops honouring our range
+// requests, which means that the resource changed while we were downloading it
+var errResourceChanged = errors.New("grequests: the resource changed during the download")
+
+// segment is an inclusive byte range of the file, start moves forward as the
+// bytes are written
+type segment struct {
+	start, end int64
+}
+
+func splitSegments(size int64, count int) []*segment {
+	if int64(count) > size {
+		count = int(size)
+	}
+
+	segmentSize := (size + int64(count) - 1) / int64(count)
+	segments := make([]*segment, 0, count)
+	for start := int64(0); start < size; start += segmentSize {
+		end := start + segmentSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		segments = append(segments, &segment{start: start, end: end})
+	}
+	return segments
+}
+
+// downloadSegments downloads the file with concurrent range requests. It
+// returns false (without downloading anything) when the server doesn't
+// support range requests so that the caller can fall back to a single stream.
+func downloadSegments(ctx context.Context, files downloadFiles, dest string, expected []byte, ro *RequestOptions, get downloadRequester) (*Response, bool, error) {
+	// The transport asks for gzip unless told otherwise, which would give us the
+	// size of the compressed representation
+	identity := withHeaders(map[string]string{"Accept-Encoding": "identity"})
+
+	head, err := get(ctx, "HEAD", identity)
+	if err != nil || !head.Ok {
+		return nil, false, nil
+	}
+	_ = head.Close()
+
+	size := head.RawResponse.ContentLength
+	if size < int64(ro.DownloadSegments) || !acceptsByteRanges(head.Header) {
+		return nil, false, nil
+	}
+
+	// A segmented partial file has holes, it can never be resumed
+	if err := os.Remove(files.state); err != nil && !os.IsNotExist(err) {
+		return head, true, err
+	}
+
+	fd, err := os.OpenFile(files.part, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return head, true, err
+	}
+
+	if err := fd.Truncate(size); err != nil {
+		_ = fd.Close()
+		files.remove()
+		return head, true, err
+	}
+
+	var tracker *progressTracker
+	if ro.DownloadProgress != nil {
+		tracker = newProgressTracker(size, ro.DownloadProgress, ro)
+	}
+
+	if err := fetchSegments(ctx, fd, splitSegments(size, ro.DownloadSegments), newDownloadState(head.Header).validator(), ro, tracker, get); err != nil {
+		_ = fd.Close()
+		files.remove()
+		return head, true, err
+	}
+
+	if tracker != nil {
+		tracker.add(0, true)
+	}
+
+	// The segments arrive out of order so the file is hashed once complete
+	verifier := newDownloadVerifier(expected, head)
+	if len(verifier.checks) != 0 {
+		if _, err = fd.Seek(0, io.SeekStart); err == nil {
+			_, err = io.Copy(verifier, fd)
+		}
+		if err == nil {
+			err = verifier.verify()
+		}
+	}
+	if err != nil {
+		_ = fd.Close()
+		files.remove()
+		return head, true, err
+	}
+
+	return head, true, files.complete(fd, dest, head.Header)
+}
+
+func acceptsByteRanges(header http.Header) bool {
+	for _, unit := range strings.Split(header.Get("Accept-Ranges"), ",") {
+		if strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchSegments downloads every segment concurrently, the first error cancels
+// the other segments
+func fetchSegments(ctx context.Context, fd *os.File, segments []*segment, validator string, ro *RequestOptions, tracker *progressTracker, get downloadRequester) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	policy := ro.RetryPolicy
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+
+	for _, seg := range segments {
+		wg.Add(1)
+		go func(seg *segment) {
+			defer wg.Done()
+			if err := fetchSegmentWithRetry(ctx, fd, seg, validator, policy, tracker, get); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(seg)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// fetchSegmentWithRetry retries the segment (from where it stopped) until it
+// is complete or the policy gives up. Segments aren't retried without a policy
+func fetchSegmentWithRetry(ctx context.Context, fd *os.File, seg *segment, validator string, policy *RetryPolicy, tracker *progressTracker, get downloadRequester) error {
+	for attempt := 1; ; attempt++ {
+		err := fetchSegment(ctx, fd, seg, validator, tracker, get)
+		if err == nil {
+			return nil
+		}
+
+		if policy == nil || ctx.Err() != nil || attempt >= policy.maxAttempts() || !policy.retryableSegmentError(err) {
+			return err
+		}
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryableSegmentError reports if the policy retries a segment that failed
+// with err. Errors are classified like the ones of requests, a connection
+// dropped in the middle of the segment is a reset
+func (p *RetryPolicy) retryableSegmentError(err error) bool {
+	var httpErr *HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return p.retryableStatus(httpErr.StatusCode)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return p.RetryableErrors == 0 || p.RetryableErrors&RetryOnConnReset != 0
+	}
+	return p.retryableError(err)
+}
+
+func fetchSegment(ctx context.Context, fd *os.File, seg *segment, validator string, tracker *progressTracker, get downloadRequester) error {
+	headers := map[string]string{
+		"Range":           fmt.Sprintf("bytes=%d-%d", seg.start, seg.end),
+		"Accept-Encoding": "identity",
+	}
+	if validator != "" {
+		headers["If-Range"] = validator
+	}
+
+	// Progress is reported for the whole file instead of every segment and
+	// segments are retried by fetchSegmentWithRetry only
+	segmentOptions := optionFunc(func(ro *RequestOptions) {
+		ro.DownloadProgress = nil
+		ro.RetryPolicy = nil
+	})
+
+	resp, err := get(ctx, "GET", withHeaders(headers), segmentOptions)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != seg.start {
+			return fmt.Errorf("grequests: unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+	case http.StatusOK:
+		return errResourceChanged
+	default:
+		return resp.RaiseForStatus()
+	}
+
+	buf := make([]byte, 32*1024)
+	body := io.LimitReader(resp, seg.end-seg.start+1)
+	for seg.start <= seg.end {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, writeErr := fd.WriteAt(buf[:n], seg.start); writeErr != nil {
+				return writeErr
+			}
+			seg.start += int64(n)
+			if tracker != nil {
+				tracker.add(int64(n), false)
+			}
+		}
+
+		if err == io.EOF && seg.start <= seg.end {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
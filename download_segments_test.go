@@ -0,0 +1,195 @@
+package grequests
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type DownloadSegmentsSuite struct {
+	downloadTest
+}
+
+func (s *DownloadSegmentsSuite) TestSegmentedDownload() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	resp, err := Download(context.Background(), srv.URL, s.dest, DownloadSegments(4),
+		ExpectSHA256(hex.EncodeToString(downloadChecksum[:])))
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.assertDownloaded()
+
+	ranges := srv.receivedRanges()
+	sort.Strings(ranges)
+	s.Equal([]string{"bytes=0-16383", "bytes=16384-32767", "bytes=32768-49151", "bytes=49152-65535"}, ranges)
+	s.Equal("HEAD ", srv.receivedRequests()[0])
+}
+
+func (s *DownloadSegmentsSuite) TestFailedSegmentIsRetried() {
+	srv := newDownloadServer()
+	defer srv.Close()
+	srv.failRanges = 1
+
+	_, err := Download(context.Background(), srv.URL, s.dest, DownloadSegments(4), Retry(fastRetry))
+	s.Require().NoError(err)
+	s.assertDownloaded()
+
+	// The retry only asks for what is missing from the segment
+	s.Len(srv.receivedRanges(), 5)
+}
+
+func (s *DownloadSegmentsSuite) TestFailedSegmentGivesUp() {
+	srv := newDownloadServer()
+	defer srv.Close()
+	srv.failRanges = 100
+
+	policy := fastRetry
+	policy.MaxAttempts = 2
+	_, err := Download(context.Background(), srv.URL, s.dest, DownloadSegments(2), Retry(policy))
+	s.Require().Error(err)
+	s.NoFileExists(s.dest)
+	s.NoFileExists(s.dest + ".part")
+	s.NoFileExists(s.dest + ".part.json")
+}
+
+// assertNotRetried checks that no range has been requested twice, the
+// segments that haven't started when the first one fails are never requested
+func (s *DownloadSegmentsSuite) assertNotRetried(srv *downloadServer) {
+	ranges := srv.receivedRanges()
+	s.NotEmpty(ranges)
+
+	seen := make(map[string]bool)
+	for _, r := range ranges {
+		s.False(seen[r], "%s was retried", r)
+		seen[r] = true
+	}
+}
+
+func (s *DownloadSegmentsSuite) TestFailedSegmentWithoutPolicy() {
+	srv := newDownloadServer()
+	defer srv.Close()
+	srv.failRanges = 1
+
+	_, err := Download(context.Background(), srv.URL, s.dest, DownloadSegments(4))
+	s.Require().Error(err)
+	s.assertNotRetried(srv)
+}
+
+func (s *DownloadSegmentsSuite) TestSegmentRetriesFollowThePolicy() {
+	srv := newDownloadServer()
+	defer srv.Close()
+	srv.unavailableRanges = 100
+
+	// 503 isn't retried by this policy
+	policy := fastRetry
+	policy.RetryableStatusCodes = []int{http.StatusBadGateway}
+	_, err := Download(context.Background(), srv.URL, s.dest, DownloadSegments(2), Retry(policy))
+	var httpErr *HTTPError
+	s.Require().ErrorAs(err, &httpErr)
+	s.Equal(http.StatusServiceUnavailable, httpErr.StatusCode)
+	s.assertNotRetried(srv)
+
+	// Neither are dropped connections
+	dropped := newDownloadServer()
+	defer dropped.Close()
+	dropped.failRanges = 1
+
+	policy = fastRetry
+	policy.RetryableErrors = RetryOnDialError
+	_, err = Download(context.Background(), dropped.URL, s.dest, DownloadSegments(2), Retry(policy))
+	s.Require().Error(err)
+	s.assertNotRetried(dropped)
+}
+
+func (s *DownloadSegmentsSuite) TestSegmentRetriesAreNotCompounded() {
+	srv := newDownloadServer()
+	defer srv.Close()
+	srv.unavailableRanges = 100
+
+	policy := fastRetry
+	policy.MaxAttempts = 3
+	_, err := Download(context.Background(), srv.URL, s.dest, DownloadSegments(2), Retry(policy))
+	s.Require().Error(err)
+
+	// Every segment is attempted MaxAttempts times (and not MaxAttempts²)
+	s.Len(srv.receivedRanges(), 6)
+}
+
+func (s *DownloadSegmentsSuite) TestFallbackWithoutRangeSupport() {
+	srv := newDownloadServer()
+	defer srv.Close()
+	srv.noRanges = true
+
+	_, err := Download(context.Background(), srv.URL, s.dest, DownloadSegments(4))
+	s.Require().NoError(err)
+
+	b, err := os.ReadFile(s.dest)
+	s.Require().NoError(err)
+	s.Equal(downloadContent, b)
+	s.Equal([]string{"HEAD ", "GET "}, srv.receivedRequests())
+}
+
+func (s *DownloadSegmentsSuite) TestResourceChanged() {
+	srv := newDownloadServer()
+	defer srv.Close()
+	srv.etagAfterHead = `"v2"`
+
+	_, err := Download(context.Background(), srv.URL, s.dest, DownloadSegments(4), Retry(fastRetry))
+	s.True(errors.Is(err, errResourceChanged), err)
+	s.NoFileExists(s.dest)
+	s.NoFileExists(s.dest + ".part")
+}
+
+func (s *DownloadSegmentsSuite) TestInterruptedDownloadIsResumedAsStream() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	s.writePartial(1000, `"v1"`)
+	_, err := Download(context.Background(), srv.URL, s.dest, DownloadSegments(4))
+	s.Require().NoError(err)
+	s.assertDownloaded()
+	s.Equal([]string{"GET bytes=1000-"}, srv.receivedRequests())
+}
+
+func (s *DownloadSegmentsSuite) TestProgressCoversTheWholeFile() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	progress := &progressRecorder{}
+	_, err := Download(context.Background(), srv.URL, s.dest, DownloadSegments(4),
+		DownloadProgress(progress.record), ProgressThrottle(0, 4096))
+	s.Require().NoError(err)
+
+	total := int64(len(downloadContent))
+	s.Equal([2]int64{total, total}, progress.last())
+	for i := 1; i < len(progress.reports); i++ {
+		s.Greater(progress.reports[i][0], progress.reports[i-1][0])
+		s.Equal(total, progress.reports[i][1])
+	}
+}
+
+func (s *DownloadSegmentsSuite) TestChecksumMismatch() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	_, err := Download(context.Background(), srv.URL, s.dest, DownloadSegments(4), ExpectSHA256(hex.EncodeToString(make([]byte, 32))))
+	s.ErrorIs(err, ErrChecksumMismatch)
+	s.NoFileExists(s.dest)
+	s.NoFileExists(s.dest + ".part")
+}
+
+func (s *DownloadSegmentsSuite) TestSplitSegments() {
+	s.Equal([]*segment{{0, 3}, {4, 7}, {8, 9}}, splitSegments(10, 3))
+	s.Equal([]*segment{{0, 0}, {1, 1}}, splitSegments(2, 8))
+}
+
+func TestDownloadSegmentsSuite(t *testing.T) {
+	suite.Run(t, new(DownloadSegmentsSuite))
+}
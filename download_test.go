@@ -0,0 +1,360 @@
+package grequests
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// downloadTest holds the helpers shared by the download suites
+type downloadTest struct {
+	suite.Suite
+	dest string
+}
+
+type DownloadSuite struct {
+	downloadTest
+}
+
+func (s *downloadTest) SetupTest() {
+	s.dest = filepath.Join(s.T().TempDir(), "file.bin")
+}
+
+var (
+	downloadContent  = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	downloadModTime  = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	downloadChecksum = sha256.Sum256(downloadContent)
+)
+
+// downloadServer serves content (honouring Range and If-Range) and records the
+// Range headers it received
+type downloadServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	etag     string
+	content  []byte
+	ranges   []string
+	requests []string
+
+	// cutAfter makes the server hang up after sending that many bytes
+	cutAfter int
+	// headers are added to every response
+	headers http.Header
+	// failRanges makes the server hang up halfway through that many range requests
+	failRanges int
+	// unavailableRanges makes the server answer that many range requests with
+	// a 503 status
+	unavailableRanges int
+	// noRanges makes the server ignore range requests
+	noRanges bool
+	// etagAfterHead replaces the ETag once a HEAD request has been served
+	etagAfterHead string
+}
+
+func newDownloadServer() *downloadServer {
+	d := &downloadServer{etag: `"v1"`, content: downloadContent}
+	d.Server = httptest.NewServer(http.HandlerFunc(d.serve))
+	return d
+}
+
+func (d *downloadServer) serve(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	if r.Method == http.MethodGet {
+		d.ranges = append(d.ranges, r.Header.Get("Range"))
+	}
+	d.requests = append(d.requests, r.Method+" "+r.Header.Get("Range"))
+	cutAfter, etag, content := d.cutAfter, d.etag, d.content
+	for k, v := range d.headers {
+		w.Header()[k] = v
+	}
+	failRange := d.failRanges > 0 && r.Header.Get("Range") != ""
+	if failRange {
+		d.failRanges--
+	}
+	unavailable := d.unavailableRanges > 0 && r.Header.Get("Range") != ""
+	if unavailable {
+		d.unavailableRanges--
+	}
+	if r.Method == http.MethodHead && d.etagAfterHead != "" {
+		d.etag = d.etagAfterHead
+	}
+	noRanges := d.noRanges
+	d.mu.Unlock()
+
+	if noRanges {
+		_, _ = w.Write(content)
+		return
+	}
+
+	if unavailable {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if failRange {
+		var start, end int
+		_, _ = fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(content[start : start+(end-start)/2])
+		w.(http.Flusher).Flush()
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		_ = conn.Close()
+		return
+	}
+
+	if r.URL.Path == "/missing" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if cutAfter > 0 && r.Header.Get("Range") == "" {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(content[:cutAfter])
+		w.(http.Flusher).Flush()
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		_ = conn.Close()
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "file.bin", downloadModTime, bytes.NewReader(content))
+}
+
+func (d *downloadServer) receivedRanges() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.ranges...)
+}
+
+func (d *downloadServer) receivedRequests() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.requests...)
+}
+
+func (s *downloadTest) assertDownloaded() {
+	b, err := os.ReadFile(s.dest)
+	s.Require().NoError(err)
+	s.Equal(downloadContent, b)
+
+	info, err := os.Stat(s.dest)
+	s.Require().NoError(err)
+	s.True(info.ModTime().Equal(downloadModTime), info.ModTime())
+
+	s.NoFileExists(s.dest + ".part")
+	s.NoFileExists(s.dest + ".part.json")
+}
+
+func (s *downloadTest) writePartial(n int, etag string) {
+	s.Require().NoError(os.WriteFile(s.dest+".part", downloadContent[:n], 0o644))
+	s.Require().NoError(os.WriteFile(s.dest+".part.json", []byte(`{"etag":`+strconv.Quote(etag)+`}`), 0o644))
+}
+
+func (s *DownloadSuite) TestDownload() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	resp, err := Download(context.Background(), srv.URL, s.dest, ExpectSHA256(hex.EncodeToString(downloadChecksum[:])))
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.assertDownloaded()
+	s.Equal([]string{""}, srv.receivedRanges())
+}
+
+func (s *DownloadSuite) TestResume() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	s.writePartial(1000, `"v1"`)
+
+	resp, err := Download(context.Background(), srv.URL, s.dest, ExpectSHA256(hex.EncodeToString(downloadChecksum[:])))
+	s.Require().NoError(err)
+	s.Equal(http.StatusPartialContent, resp.StatusCode)
+	s.assertDownloaded()
+	s.Equal([]string{"bytes=1000-"}, srv.receivedRanges())
+}
+
+func (s *DownloadSuite) TestResumeChangedResource() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	// The partial file belongs to an older version of the resource
+	s.Require().NoError(os.WriteFile(s.dest+".part", []byte("stale content"), 0o644))
+	s.Require().NoError(os.WriteFile(s.dest+".part.json", []byte(`{"etag":"\"v0\""}`), 0o644))
+
+	resp, err := Download(context.Background(), srv.URL, s.dest)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.assertDownloaded()
+}
+
+func (s *DownloadSuite) TestPartialFileWithoutStateIsDiscarded() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	s.Require().NoError(os.WriteFile(s.dest+".part", []byte("garbage"), 0o644))
+
+	_, err := Download(context.Background(), srv.URL, s.dest)
+	s.Require().NoError(err)
+	s.assertDownloaded()
+	s.Equal([]string{""}, srv.receivedRanges())
+}
+
+func (s *DownloadSuite) TestUnsatisfiableRangeStartsOver() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	// The partial file is larger than the resource
+	s.Require().NoError(os.WriteFile(s.dest+".part", append(downloadContent, 'x'), 0o644))
+	s.Require().NoError(os.WriteFile(s.dest+".part.json", []byte(`{"etag":"\"v1\""}`), 0o644))
+
+	_, err := Download(context.Background(), srv.URL, s.dest)
+	s.Require().NoError(err)
+	s.assertDownloaded()
+	s.Equal([]string{"bytes=" + strconv.Itoa(len(downloadContent)+1) + "-", ""}, srv.receivedRanges())
+}
+
+func (s *DownloadSuite) TestInterruptedDownloadIsResumed() {
+	srv := newDownloadServer()
+	defer srv.Close()
+	srv.cutAfter = 5000
+
+	_, err := Download(context.Background(), srv.URL, s.dest)
+	s.Require().Error(err)
+
+	// The destination is never left truncated
+	s.NoFileExists(s.dest)
+	partial, err := os.ReadFile(s.dest + ".part")
+	s.Require().NoError(err)
+	s.Equal(downloadContent[:5000], partial)
+
+	_, err = Download(context.Background(), srv.URL, s.dest)
+	s.Require().NoError(err)
+	s.assertDownloaded()
+	s.Equal([]string{"", "bytes=5000-"}, srv.receivedRanges())
+}
+
+func (s *DownloadSuite) TestChecksumMismatch() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	_, err := Download(context.Background(), srv.URL, s.dest, ExpectSHA256(hex.EncodeToString(make([]byte, 32))))
+	s.ErrorIs(err, ErrChecksumMismatch)
+	s.NoFileExists(s.dest)
+	s.NoFileExists(s.dest + ".part")
+	s.NoFileExists(s.dest + ".part.json")
+
+	_, err = Download(context.Background(), srv.URL, s.dest, ExpectSHA256("not hex"))
+	s.ErrorContains(err, "invalid SHA-256 checksum")
+}
+
+func (s *DownloadSuite) TestDigestHeaders() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	sum512 := sha512.Sum512(downloadContent)
+	srv.headers = http.Header{
+		"Repr-Digest": {"sha-256=:" + base64.StdEncoding.EncodeToString(downloadChecksum[:]) + ":, md5=:AAAA:"},
+		"Digest":      {"SHA-512=" + base64.StdEncoding.EncodeToString(sum512[:])},
+	}
+
+	// The partial file is verified as part of the whole representation
+	s.writePartial(100, `"v1"`)
+	_, err := Download(context.Background(), srv.URL, s.dest)
+	s.Require().NoError(err)
+	s.assertDownloaded()
+
+	srv.headers = http.Header{"Repr-Digest": {"sha-256=:" + base64.StdEncoding.EncodeToString(make([]byte, 32)) + ":"}}
+	_, err = Download(context.Background(), srv.URL, filepath.Join(s.T().TempDir(), "other"))
+	s.ErrorIs(err, ErrChecksumMismatch)
+	s.ErrorContains(err, "Repr-Digest sha-256")
+}
+
+func (s *DownloadSuite) TestContentDigestCoversTheResponseOnly() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	rest := sha256.Sum256(downloadContent[100:])
+	srv.headers = http.Header{"Content-Digest": {"sha-256=:" + base64.StdEncoding.EncodeToString(rest[:]) + ":"}}
+
+	s.writePartial(100, `"v1"`)
+	_, err := Download(context.Background(), srv.URL, s.dest)
+	s.Require().NoError(err)
+	s.assertDownloaded()
+}
+
+func (s *DownloadSuite) TestHTTPError() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	_, err := Download(context.Background(), srv.URL+"/missing", s.dest)
+	var httpErr *HTTPError
+	s.Require().True(errors.As(err, &httpErr))
+	s.Equal(http.StatusNotFound, httpErr.StatusCode)
+	s.NoFileExists(s.dest)
+	s.NoFileExists(s.dest + ".part")
+}
+
+func (s *DownloadSuite) TestSessionDownload() {
+	srv := newDownloadServer()
+	defer srv.Close()
+
+	session := NewSession(ExpectSHA256(hex.EncodeToString(downloadChecksum[:])))
+	_, err := session.Download(context.Background(), srv.URL, s.dest)
+	s.Require().NoError(err)
+	s.assertDownloaded()
+
+	_, err = session.Download(context.Background(), srv.URL, s.dest, ExpectSHA256(hex.EncodeToString(make([]byte, 32))))
+	s.ErrorIs(err, ErrChecksumMismatch)
+}
+
+func (s *DownloadSuite) TestConnectionError() {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	addr := ln.Addr().String()
+	s.Require().NoError(ln.Close())
+
+	_, err = Download(context.Background(), "http://"+addr, s.dest)
+	s.ErrorIs(err, ErrConnRefused)
+	s.NoFileExists(s.dest + ".part")
+}
+
+func (s *DownloadSuite) TestParseDigestHeader() {
+	s.Equal(map[string][]byte{"sha-256": {1, 2, 3}}, parseDigestHeader("Repr-Digest", "sha-256=:AQID:, unknown=:AQID:"))
+	s.Equal(map[string][]byte{"sha-512": {1, 2, 3}}, parseDigestHeader("Digest", "SHA-512=AQID"))
+	s.Empty(parseDigestHeader("Digest", "garbage"))
+}
+
+func (s *DownloadSuite) TestContentRangeStart() {
+	start, ok := contentRangeStart("bytes 100-199/200")
+	s.True(ok)
+	s.Equal(int64(100), start)
+
+	_, ok = contentRangeStart("items 1-2/3")
+	s.False(ok)
+}
+
+func TestDownloadSuite(t *testing.T) {
+	suite.Run(t, new(DownloadSuite))
+}
@@ -0,0 +1,234 @@
+package grequests
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+var (
+	// ErrTimeout is matched (using `errors.Is`) by errors caused by a timeout
+	ErrTimeout = errors.New("grequests: request timed out")
+
+	// ErrDNS is matched (using `errors.Is`) by errors caused by a failed DNS lookup
+	ErrDNS = errors.New("grequests: DNS lookup failed")
+
+	// ErrTLSVerify is matched (using `errors.Is`) by errors caused by a server
+	// certificate that could not be verified
+	ErrTLSVerify = errors.New("grequests: TLS certificate verification failed")
+
+	// ErrTLSHandshake is matched (using `errors.Is`) by errors caused by a failed TLS handshake
+	ErrTLSHandshake = errors.New("grequests: TLS handshake failed")
+
+	// ErrConnRefused is matched (using `errors.Is`) by errors caused by a refused connection
+	ErrConnRefused = errors.New("grequests: connection refused")
+
+	// ErrConnReset is matched (using `errors.Is`) by errors caused by the peer
+	// resetting the connection
+	ErrConnReset = errors.New("grequests: connection reset by peer")
+
+	// ErrBodyTooLarge is the error returned when a response body is larger than
+	// allowed by the `MaxBodySize` option
+	ErrBodyTooLarge = errors.New("grequests: response body too large")
+)
+
+// ErrorKind is the class of an error returned by grequests
+type ErrorKind int
+
+const (
+	// KindUnknown is any error we cannot classify
+	KindUnknown ErrorKind = iota
+
+	// KindTimeout see `ErrTimeout`
+	KindTimeout
+
+	// KindDNS see `ErrDNS`
+	KindDNS
+
+	// KindTLSVerify see `ErrTLSVerify`
+	KindTLSVerify
+
+	// KindTLSHandshake see `ErrTLSHandshake`
+	KindTLSHandshake
+
+	// KindConnRefused see `ErrConnRefused`
+	KindConnRefused
+
+	// KindConnReset see `ErrConnReset`
+	KindConnReset
+
+	// KindRedirectLimitExceeded see `ErrRedirectLimitExceeded`
+	KindRedirectLimitExceeded
+
+	// KindBodyTooLarge see `ErrBodyTooLarge`
+	KindBodyTooLarge
+)
+
+var errorKindNames = map[ErrorKind]string{
+	KindUnknown:               "unknown",
+	KindTimeout:               "timeout",
+	KindDNS:                   "dns",
+	KindTLSVerify:             "tls_verify",
+	KindTLSHandshake:          "tls_handshake",
+	KindConnRefused:           "conn_refused",
+	KindConnReset:             "conn_reset",
+	KindRedirectLimitExceeded: "redirect_limit_exceeded",
+	KindBodyTooLarge:          "body_too_large",
+}
+
+// String returns a short name for the kind which is suitable for metrics labels
+func (k ErrorKind) String() string {
+	if name, ok := errorKindNames[k]; ok {
+		return name
+	}
+	return errorKindNames[KindUnknown]
+}
+
+// Sentinel returns the sentinel error matching the kind (nil for `KindUnknown`)
+func (k ErrorKind) Sentinel() error {
+	switch k {
+	case KindTimeout:
+		return ErrTimeout
+	case KindDNS:
+		return ErrDNS
+	case KindTLSVerify:
+		return ErrTLSVerify
+	case KindTLSHandshake:
+		return ErrTLSHandshake
+	case KindConnRefused:
+		return ErrConnRefused
+	case KindConnReset:
+		return ErrConnReset
+	case KindRedirectLimitExceeded:
+		return ErrRedirectLimitExceeded
+	case KindBodyTooLarge:
+		return ErrBodyTooLarge
+	}
+	return nil
+}
+
+// ClassifyError returns the kind of the error provided. It understands both
+// the errors returned by grequests and the raw errors returned by net/http.
+func ClassifyError(err error) ErrorKind {
+	if err == nil {
+		return KindUnknown
+	}
+
+	var transportErr *TransportError
+	if errors.As(err, &transportErr) {
+		return transportErr.Kind
+	}
+
+	var (
+		dnsErr       *net.DNSError
+		netErr       net.Error
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+
+	switch {
+	case errors.Is(err, ErrRedirectLimitExceeded):
+		return KindRedirectLimitExceeded
+	case errors.Is(err, ErrBodyTooLarge):
+		return KindBodyTooLarge
+	case errors.As(err, &dnsErr):
+		return KindDNS
+	case errors.As(err, &verifyErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr), errors.Is(err, ErrPinMismatch):
+		return KindTLSVerify
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return KindTimeout
+	case isTLSHandshakeError(err):
+		return KindTLSHandshake
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return KindConnRefused
+	case errors.Is(err, syscall.ECONNRESET):
+		return KindConnReset
+	}
+
+	return KindUnknown
+}
+
+// TransportError wraps an error returned while sending a request together
+// with its classification. `errors.Is` matches both the sentinel error of its
+// kind (e.g. `ErrTimeout`) and the original error.
+type TransportError struct {
+	// Kind is the class of the error
+	Kind ErrorKind
+
+	// Err is the original error
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the original error
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is the sentinel error of the kind
+func (e *TransportError) Is(target error) bool {
+	sentinel := e.Kind.Sentinel()
+	return sentinel != nil && target == sentinel
+}
+
+// classifyTransportError wraps the error in a `TransportError` when we know
+// what kind of error it is
+func classifyTransportError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var transportErr *TransportError
+	if errors.As(err, &transportErr) {
+		return err
+	}
+
+	if kind := ClassifyError(err); kind != KindUnknown {
+		return &TransportError{Kind: kind, Err: err}
+	}
+	return err
+}
+
+// limitedBody returns ErrBodyTooLarge once more than limit bytes have been read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+
+	// Read one byte more than allowed so that we can tell if the body is too large
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n + int(l.remaining), ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// limitResponseBody makes sure that we never read more than limit bytes of the body
+func limitResponseBody(resp *http.Response, limit int64) error {
+	if resp.ContentLength > limit {
+		return ErrBodyTooLarge
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: limit}
+	return nil
+}
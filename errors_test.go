@@ -0,0 +1,173 @@
+package grequests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ErrorsSuite struct {
+	suite.Suite
+}
+
+func (s *ErrorsSuite) assertKind(err error, kind ErrorKind) {
+	s.Require().Error(err)
+	s.Equal(kind, ClassifyError(err), err.Error())
+	s.ErrorIs(err, kind.Sentinel())
+
+	// The original error is still available
+	var urlErr *url.Error
+	s.True(errors.As(err, &urlErr), err.Error())
+}
+
+func (s *ErrorsSuite) TestConnRefused() {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	addr := ln.Addr().String()
+	s.Require().NoError(ln.Close())
+
+	resp, err := Get(context.Background(), "http://"+addr)
+	s.assertKind(err, KindConnRefused)
+	s.Equal(err, resp.Error)
+}
+
+func (s *ErrorsSuite) TestDNS() {
+	_, err := Get(context.Background(), "http://grequests.invalid")
+	s.assertKind(err, KindDNS)
+
+	var dnsErr *net.DNSError
+	s.True(errors.As(err, &dnsErr))
+}
+
+func (s *ErrorsSuite) TestTLSVerify() {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	_, err := Get(context.Background(), srv.URL)
+	s.assertKind(err, KindTLSVerify)
+
+	_, err = Get(context.Background(), srv.URL, DisableTLSCertValidation())
+	s.NoError(err)
+}
+
+func (s *ErrorsSuite) TestTimeout() {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	_, err := Get(context.Background(), srv.URL, RequestTimeout(50*time.Millisecond))
+	s.assertKind(err, KindTimeout)
+}
+
+func (s *ErrorsSuite) TestConnReset() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		// Closing with a zero linger sends a RST instead of a FIN
+		_ = conn.(*net.TCPConn).SetLinger(0)
+		_ = conn.Close()
+	}))
+	defer srv.Close()
+
+	_, err := Get(context.Background(), srv.URL)
+	s.assertKind(err, KindConnReset)
+}
+
+func (s *ErrorsSuite) TestRedirectLimitExceeded() {
+	srv := newRedirectServer()
+	defer srv.Close()
+
+	_, err := Get(context.Background(), srv.URL+"/3", RedirectLimit(1))
+	s.assertKind(err, KindRedirectLimitExceeded)
+}
+
+func (s *ErrorsSuite) TestMaxBodySize() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chunked") != "" {
+			w.(http.Flusher).Flush()
+		}
+		_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer srv.Close()
+
+	// The Content-Length is too large
+	resp, err := Get(context.Background(), srv.URL, MaxBodySize(10))
+	s.ErrorIs(err, ErrBodyTooLarge)
+	s.Equal(KindBodyTooLarge, ClassifyError(resp.Error))
+
+	// The body turns out to be too large while reading it
+	resp, err = Get(context.Background(), srv.URL+"?chunked=1", MaxBodySize(10))
+	s.Require().NoError(err)
+	b, err := io.ReadAll(resp)
+	s.ErrorIs(err, ErrBodyTooLarge)
+	s.Len(b, 10)
+
+	resp, err = Get(context.Background(), srv.URL+"?chunked=1", MaxBodySize(100))
+	s.Require().NoError(err)
+	s.Len(resp.String(), 100)
+	s.NoError(resp.Error)
+}
+
+func (s *ErrorsSuite) TestSessionMaxBodySize() {
+	srv := newGetServer()
+	defer srv.Close()
+
+	session := NewSession(MaxBodySize(1))
+	_, err := session.Get(context.Background(), srv.URL)
+	s.ErrorIs(err, ErrBodyTooLarge)
+}
+
+func (s *ErrorsSuite) TestClassifyError() {
+	cases := map[error]ErrorKind{
+		nil:                      KindUnknown,
+		errors.New("boom"):       KindUnknown,
+		context.Canceled:         KindUnknown,
+		context.DeadlineExceeded: KindTimeout,
+		ErrBodyTooLarge:          KindBodyTooLarge,
+		syscall.ECONNRESET:       KindConnReset,
+		&net.DNSError{Err: "no such host", Name: "x"}:                  KindDNS,
+		fmt.Errorf("wrapped: %w", syscall.ECONNREFUSED):                KindConnRefused,
+		&TransportError{Kind: KindTLSVerify, Err: errors.New("inner")}: KindTLSVerify,
+	}
+
+	for err, kind := range cases {
+		s.Equal(kind, ClassifyError(err), fmt.Sprint(err))
+	}
+}
+
+func (s *ErrorsSuite) TestErrorKindString() {
+	s.Equal("timeout", KindTimeout.String())
+	s.Equal("conn_refused", KindConnRefused.String())
+	s.Equal("unknown", ErrorKind(1000).String())
+	s.Nil(KindUnknown.Sentinel())
+}
+
+func (s *ErrorsSuite) TestUnknownErrorsAreNotWrapped() {
+	err := errors.New("boom")
+	s.Equal(err, classifyTransportError(err))
+
+	wrapped := classifyTransportError(syscall.ECONNRESET)
+	s.Equal(wrapped, classifyTransportError(wrapped))
+	s.Equal(syscall.ECONNRESET.Error(), wrapped.Error())
+	s.NotErrorIs(wrapped, ErrConnRefused)
+}
+
+func TestErrorsSuite(t *testing.T) {
+	suite.Run(t, new(ErrorsSuite))
+}
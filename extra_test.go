@@ -2,6 +2,8 @@ package grequests
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"net"
@@ -27,6 +29,7 @@ func (s *OptionFuncSuite) TestOptions() {
 	ctx := context.WithValue(context.Background(), contextKey{}, "v")
 	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
 	proxyURL, _ := url.Parse("http://proxy")
+	pool := x509.NewCertPool()
 	opts := []struct {
 		opt   Option
 		check func(*RequestOptions)
@@ -38,11 +41,42 @@ func (s *OptionFuncSuite) TestOptions() {
 		{DisableTLSCertValidation(), func(ro *RequestOptions) { s.True(ro.InsecureSkipVerify) }},
 		{DisableCompression(), func(ro *RequestOptions) { s.True(ro.DisableCompression) }},
 		{Host("h"), func(ro *RequestOptions) { s.Equal("h", ro.Host) }},
-		{BasicAuth("u", "p"), func(ro *RequestOptions) { s.Equal([]string{"u", "p"}, ro.Auth) }},
+		{BasicAuth("u", "p"), func(ro *RequestOptions) {
+			s.Equal([]string{"u", "p"}, ro.Auth)
+			s.NotNil(ro.Authenticator)
+		}},
+		{BearerToken("t"), func(ro *RequestOptions) { s.NotNil(ro.Authenticator) }},
+		{DigestAuth("u", "p"), func(ro *RequestOptions) { s.NotNil(ro.Authenticator) }},
+		{OAuth2ClientCredentials("u", "i", "s", nil), func(ro *RequestOptions) { s.NotNil(ro.Authenticator) }},
+		{OAuth2RefreshToken("u", "i", "s", "r", nil), func(ro *RequestOptions) { s.NotNil(ro.Authenticator) }},
+		{SigV4(AWSCredentials{}, "r", "s"), func(ro *RequestOptions) { s.NotNil(ro.Authenticator) }},
+		{HTTPSignature("k", []byte("secret")), func(ro *RequestOptions) { s.NotNil(ro.Authenticator) }},
+		{UseNetrc(""), func(ro *RequestOptions) { s.Len(ro.CredentialProviders, 1) }},
+		{UseCredentialProvider(EnvCredentials("P_"), StaticCredentials(nil)), func(ro *RequestOptions) { s.Len(ro.CredentialProviders, 2) }},
+		{UseAuthenticator(BearerToken("t")), func(ro *RequestOptions) { s.NotNil(ro.Authenticator) }},
 		{IsAJAX(), func(ro *RequestOptions) { s.True(ro.IsAjax) }},
 		{Cookies([]*http.Cookie{{Name: "n"}}), func(ro *RequestOptions) { s.Len(ro.Cookies, 1) }},
 		{UseCookieJar(), func(ro *RequestOptions) { s.True(ro.UseCookieJar) }},
 		{Proxies(map[string]*url.URL{"http": proxyURL}), func(ro *RequestOptions) { s.Equal(proxyURL, ro.Proxies["http"]) }},
+		{ClientCertificate([]byte("cert"), []byte("key")), func(ro *RequestOptions) { s.Equal([]byte("cert"), ro.ClientCertificates[0].CertPEM) }},
+		{ClientCertificateFiles("cert.pem", "key.pem"), func(ro *RequestOptions) { s.Equal("key.pem", ro.ClientCertificates[0].KeyFile) }},
+		{RootCAs(pool), func(ro *RequestOptions) { s.Same(pool, ro.RootCAs) }},
+		{RootCAFiles("a.pem", "b.pem"), func(ro *RequestOptions) { s.Equal([]string{"a.pem", "b.pem"}, ro.RootCAFiles) }},
+		{TLSMinVersion(tls.VersionTLS13), func(ro *RequestOptions) { s.Equal(uint16(tls.VersionTLS13), ro.TLSMinVersion) }},
+		{TLSServerName("example.com"), func(ro *RequestOptions) { s.Equal("example.com", ro.TLSServerName) }},
+		{TLSConfig(func(*tls.Config) {}), func(ro *RequestOptions) { s.Len(ro.TLSConfig, 1) }},
+		{PinnedPublicKeys(map[string][]string{"example.com": {"pin"}}), func(ro *RequestOptions) { s.Equal([]string{"pin"}, ro.PinnedPublicKeys["example.com"]) }},
+		{PinReportOnly(nil), func(ro *RequestOptions) { s.NotNil(ro.PinMismatchReporter) }},
+		{ProxyConnectHeaders(map[string]string{"X-Proxy": "v"}), func(ro *RequestOptions) { s.Equal("v", ro.ProxyConnectHeaders["X-Proxy"]) }},
+		{NoProxy([]string{"10.0.0.0/8", ".internal"}), func(ro *RequestOptions) { s.Equal([]string{"10.0.0.0/8", ".internal"}, ro.NoProxy) }},
+		{ProxyAutoConfig("http://wpad/wpad.dat"), func(ro *RequestOptions) { s.Equal("http://wpad/wpad.dat", ro.ProxyAutoConfig) }},
+		{ForceHTTP2(), func(ro *RequestOptions) { s.True(ro.ForceHTTP2) }},
+		{DisableHTTP2(), func(ro *RequestOptions) { s.True(ro.DisableHTTP2) }},
+		{H2C(), func(ro *RequestOptions) { s.True(ro.H2C) }},
+		{HTTP2HealthCheck(time.Second, 2*time.Second), func(ro *RequestOptions) {
+			s.Equal(time.Second, ro.HTTP2ReadIdleTimeout)
+			s.Equal(2*time.Second, ro.HTTP2PingTimeout)
+		}},
 		{TLSHandshakeTimeout(time.Second), func(ro *RequestOptions) { s.Equal(time.Second, ro.TLSHandshakeTimeout) }},
 		{DialTimeout(time.Second), func(ro *RequestOptions) { s.Equal(time.Second, ro.DialTimeout) }},
 		{DialKeepAlive(time.Second), func(ro *RequestOptions) { s.Equal(time.Second, ro.DialKeepAlive) }},
@@ -54,7 +88,25 @@ func (s *OptionFuncSuite) TestOptions() {
 		{CookieJar(jar), func(ro *RequestOptions) { s.Equal(jar, ro.CookieJar) }},
 		{Context(ctx), func(ro *RequestOptions) { s.Equal(ctx, ro.Context) }},
 		{BeforeRequest(func(req *http.Request) error { return nil }), func(ro *RequestOptions) { s.NotNil(ro.BeforeRequest) }},
+		{UnixSocket("/run/app.sock"), func(ro *RequestOptions) { s.Equal("/run/app.sock", ro.UnixSocket) }},
+		{DialContext((&net.Dialer{}).DialContext), func(ro *RequestOptions) { s.NotNil(ro.DialContext) }},
+		{ResolveOverride(map[string]string{"example.com:443": "127.0.0.1"}), func(ro *RequestOptions) { s.Equal("127.0.0.1", ro.ResolveOverrides["example.com:443"]) }},
+		{Resolver(net.DefaultResolver), func(ro *RequestOptions) { s.Same(net.DefaultResolver, ro.Resolver) }},
+		{DNSCache(time.Minute), func(ro *RequestOptions) { s.Equal(time.Minute, ro.DNSCacheTTL) }},
 		{LocalAddr(addr), func(ro *RequestOptions) { s.Equal(addr, ro.LocalAddr) }},
+		{Retry(RetryPolicy{MaxAttempts: 2}), func(ro *RequestOptions) { s.Equal(2, ro.RetryPolicy.MaxAttempts) }},
+		{Use(func(next Doer) Doer { return next }), func(ro *RequestOptions) { s.Len(ro.Middleware, 1) }},
+		{AfterResponse(ExpectStatusClass(2)), func(ro *RequestOptions) { s.Len(ro.AfterResponse, 1) }},
+		{ErrorOnStatus(), func(ro *RequestOptions) { s.True(ro.ErrorOnStatus) }},
+		{MaxBodySize(10), func(ro *RequestOptions) { s.Equal(int64(10), ro.MaxBodySize) }},
+		{UploadProgress(func(sent, total int64) {}), func(ro *RequestOptions) { s.NotNil(ro.UploadProgress) }},
+		{DownloadProgress(func(received, total int64) {}), func(ro *RequestOptions) { s.NotNil(ro.DownloadProgress) }},
+		{ExpectSHA256("abc"), func(ro *RequestOptions) { s.Equal("abc", ro.ExpectedSHA256) }},
+		{DownloadSegments(4), func(ro *RequestOptions) { s.Equal(4, ro.DownloadSegments) }},
+		{ProgressThrottle(time.Second, 10), func(ro *RequestOptions) {
+			s.Equal(time.Second, ro.ProgressInterval)
+			s.Equal(int64(10), ro.ProgressBytes)
+		}},
 	}
 	for _, tc := range opts {
 		ro := &RequestOptions{}
@@ -129,7 +181,7 @@ func (s *RedirectSuite) TestAddRedirectFunctionality() {
 type SessionSuite struct{ suite.Suite }
 
 func (s *SessionSuite) TestCombineRequestOptions() {
-	session := NewSession(&RequestOptions{UserAgent: "ua", Headers: map[string]string{"A": "1"}})
+	session := NewSession(FromRequestOptions(&RequestOptions{Headers: map[string]string{"A": "1"}}), UserAgent("ua"))
 	ro := &RequestOptions{Headers: map[string]string{"B": "2"}}
 	out := session.combineRequestOptions(ro)
 	s.Equal("ua", out.UserAgent)
@@ -220,8 +272,11 @@ func (s *InternalFuncsSuite) TestCreateMultiPartPostRequestErrors() {
 	_, err := createMultiPartPostRequest("POST", "http://x", &RequestOptions{Files: []FileUpload{{FileContents: nil}}})
 	s.Error(err)
 
-	_, err = createMultiPartPostRequest("POST", "http://x", &RequestOptions{Files: []FileUpload{{FileName: "f", FileContents: errReader{err: fmt.Errorf("bad")}}}})
-	s.Error(err)
+	// The body is streamed so the read error surfaces while reading it
+	req, err := createMultiPartPostRequest("POST", "http://x", &RequestOptions{Files: []FileUpload{{FileName: "f", FileContents: errReader{err: fmt.Errorf("bad")}}}})
+	s.Require().NoError(err)
+	_, err = io.ReadAll(req.Body)
+	s.EqualError(err, "bad")
 }
 
 func (s *InternalFuncsSuite) TestCreateBasicJSONRequestError() {
@@ -242,14 +297,14 @@ func (s *InternalFuncsSuite) TestBuildRequestParamsAndQueryStruct() {
 	}))
 	defer srv.Close()
 
-	_, err := buildRequest("GET", srv.URL, &RequestOptions{Params: map[string]string{"a": "b"}}, nil)
+	_, err := DoRegularRequest("GET", srv.URL, &RequestOptions{Params: map[string]string{"a": "b"}})
 	s.NoError(err)
 	s.Equal("a=b", <-q)
 
 	type qs struct {
 		A string `url:"a"`
 	}
-	_, err = buildRequest("GET", srv.URL, &RequestOptions{QueryStruct: qs{A: "1"}}, nil)
+	_, err = DoRegularRequest("GET", srv.URL, &RequestOptions{QueryStruct: qs{A: "1"}})
 	s.NoError(err)
 	s.Equal("a=1", <-q)
 }
@@ -282,6 +337,7 @@ func (s *InternalFuncsSuite) TestAddHTTPHeaders() {
 	ro := &RequestOptions{Headers: map[string]string{"X": "Y"}, UserAgent: "ua", Host: "h", Auth: []string{"u", "p"}, IsAjax: true}
 	req := httptest.NewRequest("GET", "http://x", nil)
 	addHTTPHeaders(ro, req)
+	s.NoError(requestAuthenticator(ro).Authenticate(req))
 	s.Equal("Y", req.Header.Get("X"))
 	s.Equal("ua", req.Header.Get("User-Agent"))
 	s.Equal("h", req.Host)
@@ -318,6 +374,6 @@ func (s *InternalFuncsSuite) TestBuildHTTPClient() {
 }
 
 func (s *InternalFuncsSuite) TestCloseIdleConnections() {
-	sess := NewSession(nil)
+	sess := NewSession()
 	sess.CloseIdleConnections()
 }
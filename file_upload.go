@@ -22,6 +22,11 @@ type FileUpload struct {
 	// FileMime represents which mimetime should be sent along with the file.
 	// When empty, defaults to application/octet-stream
 	FileMime string
+
+	// GetFileContents (optional) returns a new copy of the file contents. It is
+	// used to send the file again when the request is redirected or retried.
+	// FileUploadFromDisk and FileUploadFromGlob set it for you
+	GetFileContents func() (io.ReadCloser, error)
 }
 
 // FileUploadFromDisk allows you to create a FileUpload struct slice by just specifying a location on the disk
@@ -32,7 +37,7 @@ func FileUploadFromDisk(fileName string) ([]FileUpload, error) {
 		return nil, err
 	}
 
-	return []FileUpload{{FileContents: fd, FileName: fileName}}, nil
+	return []FileUpload{{FileContents: fd, FileName: fileName, GetFileContents: reopenFile(fileName)}}, nil
 
 }
 
@@ -59,10 +64,16 @@ func FileUploadFromGlob(fileSystemGlob string) ([]FileUpload, error) {
 		// ignoring error because I can stat the file
 		fd, _ := os.Open(f)
 
-		filesToUpload = append(filesToUpload, FileUpload{FileContents: fd, FileName: filepath.Base(fd.Name())})
+		filesToUpload = append(filesToUpload, FileUpload{FileContents: fd, FileName: filepath.Base(fd.Name()), GetFileContents: reopenFile(f)})
 
 	}
 
 	return filesToUpload, nil
 
 }
+
+func reopenFile(fileName string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return os.Open(fileName)
+	}
+}
@@ -0,0 +1,60 @@
+package grequests
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+var errHTTP2Conflict = errors.New("grequests: DisableHTTP2 can't be used with ForceHTTP2, H2C or HTTP2HealthCheck")
+
+// useHTTP2 reports if HTTP/2 has been asked for. Transports with a custom
+// dialer or TLS configuration (all of ours) only use HTTP/1.1 otherwise
+func (ro RequestOptions) useHTTP2() bool {
+	return ro.ForceHTTP2 || ro.H2C || ro.HTTP2ReadIdleTimeout != 0 || ro.HTTP2PingTimeout != 0
+}
+
+// configureHTTP2 sets up HTTP/2 on the transport: over TLS (negotiated with
+// ALPN) when it is used and in clear text with prior knowledge for http URLs
+// with `H2C`. Clear text HTTP/2 connections don't go through proxies
+func configureHTTP2(transport *http.Transport, ro RequestOptions) error {
+	switch {
+	case ro.DisableHTTP2 && ro.useHTTP2():
+		return errHTTP2Conflict
+
+	case ro.DisableHTTP2:
+		// A non nil empty map disables HTTP/2
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		return nil
+
+	case !ro.useHTTP2():
+		return nil
+	}
+
+	h2, err := http2.ConfigureTransports(transport)
+	if err != nil {
+		return err
+	}
+	h2.ReadIdleTimeout = ro.HTTP2ReadIdleTimeout
+	h2.PingTimeout = ro.HTTP2PingTimeout
+
+	if ro.H2C {
+		dial := transport.DialContext
+		transport.RegisterProtocol("http", &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+			DisableCompression: ro.DisableCompression,
+			ReadIdleTimeout:    ro.HTTP2ReadIdleTimeout,
+			PingTimeout:        ro.HTTP2PingTimeout,
+		})
+	}
+
+	return nil
+}
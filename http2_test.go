@@ -0,0 +1,134 @@
+package grequests
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+type HTTP2Suite struct {
+	suite.Suite
+
+	tlsServer *httptest.Server
+	h2cServer *httptest.Server
+	caPool    *x509.CertPool
+}
+
+func (s *HTTP2Suite) SetupSuite() {
+	proto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	})
+
+	s.tlsServer = httptest.NewUnstartedServer(proto)
+	s.tlsServer.EnableHTTP2 = true
+	s.tlsServer.StartTLS()
+
+	s.caPool = x509.NewCertPool()
+	s.caPool.AddCert(s.tlsServer.Certificate())
+
+	s.h2cServer = httptest.NewServer(h2c.NewHandler(proto, &http2.Server{}))
+}
+
+func (s *HTTP2Suite) TearDownSuite() {
+	s.tlsServer.Close()
+	s.h2cServer.Close()
+}
+
+func (s *HTTP2Suite) SetupTest() {
+	ResetClientPool()
+}
+
+func (s *HTTP2Suite) proto(url string, options ...Option) string {
+	resp, err := Get(context.Background(), url, options...)
+	s.Require().NoError(err)
+	s.Equal(resp.String(), resp.Proto())
+	return resp.Proto()
+}
+
+func (s *HTTP2Suite) TestTLS() {
+	// Our transports don't use HTTP/2 by themselves
+	s.Equal("HTTP/1.1", s.proto(s.tlsServer.URL, RootCAs(s.caPool)))
+
+	s.Equal("HTTP/2.0", s.proto(s.tlsServer.URL, RootCAs(s.caPool), ForceHTTP2()))
+	s.Equal("HTTP/2.0", s.proto(s.tlsServer.URL, RootCAs(s.caPool), HTTP2HealthCheck(time.Minute, time.Second)))
+	s.Equal("HTTP/1.1", s.proto(s.tlsServer.URL, RootCAs(s.caPool), DisableHTTP2()))
+
+	// Servers without HTTP/2 are still reached
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}))
+	defer srv.Close()
+	s.Equal("HTTP/1.1", s.proto(srv.URL, DisableTLSCertValidation(), ForceHTTP2()))
+}
+
+func (s *HTTP2Suite) TestH2C() {
+	s.Equal("HTTP/1.1", s.proto(s.h2cServer.URL, ForceHTTP2()))
+	s.Equal("HTTP/2.0", s.proto(s.h2cServer.URL, H2C()))
+	s.Equal("HTTP/2.0", s.proto(s.h2cServer.URL, H2C(), HTTP2HealthCheck(50*time.Millisecond, time.Second)))
+
+	// TLS requests use HTTP/2 as well
+	s.Equal("HTTP/2.0", s.proto(s.tlsServer.URL, RootCAs(s.caPool), H2C()))
+
+	session := NewSession(H2C(), HTTP2HealthCheck(50*time.Millisecond, time.Second))
+	defer session.CloseIdleConnections()
+
+	for i := 0; i < 2; i++ {
+		resp, err := session.Get(context.Background(), s.h2cServer.URL)
+		s.Require().NoError(err)
+		s.Equal("HTTP/2.0", resp.Proto())
+
+		// The health check pings (answered by the server) keep the connection usable
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (s *HTTP2Suite) TestSessionRequestOptions() {
+	session := NewSession(RootCAs(s.caPool))
+	defer session.CloseIdleConnections()
+
+	for _, test := range []struct {
+		url     string
+		options []Option
+		proto   string
+	}{
+		{s.tlsServer.URL, nil, "HTTP/1.1"},
+		{s.tlsServer.URL, []Option{ForceHTTP2()}, "HTTP/2.0"},
+		{s.tlsServer.URL, []Option{HTTP2HealthCheck(time.Minute, time.Second)}, "HTTP/2.0"},
+		{s.h2cServer.URL, []Option{H2C()}, "HTTP/2.0"},
+		{s.tlsServer.URL, []Option{DisableHTTP2()}, "HTTP/1.1"},
+	} {
+		resp, err := session.Get(context.Background(), test.url, test.options...)
+		s.Require().NoError(err)
+		s.Equal(test.proto, resp.Proto(), test.url)
+	}
+
+	session = NewSession(RootCAs(s.caPool), ForceHTTP2())
+	defer session.CloseIdleConnections()
+
+	_, err := session.Get(context.Background(), s.tlsServer.URL, DisableHTTP2())
+	s.ErrorIs(err, errHTTP2Conflict)
+}
+
+func (s *HTTP2Suite) TestConflict() {
+	for _, option := range []Option{ForceHTTP2(), H2C(), HTTP2HealthCheck(time.Second, 0)} {
+		_, err := Get(context.Background(), s.h2cServer.URL, DisableHTTP2(), option)
+		s.ErrorIs(err, errHTTP2Conflict)
+	}
+}
+
+func (s *HTTP2Suite) TestProtoWithoutResponse() {
+	resp, err := Get(context.Background(), "http://127.0.0.1:1")
+	s.Error(err)
+	s.Equal("", resp.Proto())
+}
+
+func TestHTTP2Suite(t *testing.T) {
+	suite.Run(t, new(HTTP2Suite))
+}
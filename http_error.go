@@ -0,0 +1,199 @@
+package grequests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// HTTPErrorBodyLimit is the maximum number of bytes of the response body that
+// are captured within an `HTTPError`. You can change this globally by
+// modifying this variable.
+var HTTPErrorBodyLimit = 4096
+
+// HTTPError is returned by `Response.RaiseForStatus` (and by requests made
+// with the `ErrorOnStatus` option) when the server didn't respond with a 2xx
+// status code. Use `errors.As` to retrieve it.
+type HTTPError struct {
+	// StatusCode is the HTTP Status Code returned by the server
+	StatusCode int
+
+	// Status is the HTTP Status line returned by the server e.g. "404 Not Found"
+	Status string
+
+	// Method is the HTTP method of the request
+	Method string
+
+	// URL is the URL of the request that returned the error. The password and
+	// the values of the query string (which may hold API keys or signatures)
+	// are redacted
+	URL string
+
+	// Header contains the response headers
+	Header http.Header
+
+	// Body holds the beginning of the response body, it is at most
+	// `HTTPErrorBodyLimit` bytes long
+	Body []byte
+
+	// Problem is populated when the server responded with an RFC 7807
+	// `application/problem+json` body
+	Problem *ProblemDetails
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("grequests: %s %s returned %s", e.Method, e.URL, e.Status)
+	if e.Problem != nil {
+		switch {
+		case e.Problem.Title != "" && e.Problem.Detail != "":
+			msg += ": " + e.Problem.Title + ": " + e.Problem.Detail
+		case e.Problem.Title != "":
+			msg += ": " + e.Problem.Title
+		case e.Problem.Detail != "":
+			msg += ": " + e.Problem.Detail
+		}
+	}
+	return msg
+}
+
+// ProblemDetails is the RFC 7807 representation of an error returned by an HTTP API
+type ProblemDetails struct {
+	// Type is a URI reference that identifies the problem type
+	Type string `json:"type,omitempty"`
+
+	// Title is a short, human-readable summary of the problem type
+	Title string `json:"title,omitempty"`
+
+	// Status is the HTTP status code generated by the origin server
+	Status int `json:"status,omitempty"`
+
+	// Detail is a human-readable explanation specific to this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+
+	// Instance is a URI reference that identifies the specific occurrence of the problem
+	Instance string `json:"instance,omitempty"`
+
+	// Extensions holds any additional members of the problem details object
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// UnmarshalJSON decodes the standard members of a problem details object and
+// stores every other member within `Extensions`
+func (p *ProblemDetails) UnmarshalJSON(data []byte) error {
+	type standardMembers ProblemDetails
+	if err := json.Unmarshal(data, (*standardMembers)(p)); err != nil {
+		return err
+	}
+
+	var members map[string]interface{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+
+	for _, known := range []string{"type", "title", "status", "detail", "instance"} {
+		delete(members, known)
+	}
+
+	if len(members) != 0 {
+		p.Extensions = members
+	}
+	return nil
+}
+
+// RaiseForStatus returns an `*HTTPError` if the server didn't respond with a
+// 2xx status code. The captured part of the body is not consumed – the whole
+// body can still be read from the response afterwards.
+func (r *Response) RaiseForStatus() error {
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if r.Ok {
+		return nil
+	}
+
+	return newHTTPError(r)
+}
+
+func newHTTPError(r *Response) *HTTPError {
+	httpErr := &HTTPError{
+		StatusCode: r.StatusCode,
+		Header:     r.Header,
+		Body:       r.bodySnippet(HTTPErrorBodyLimit),
+	}
+
+	if r.RawResponse != nil {
+		httpErr.Status = r.RawResponse.Status
+		if req := r.RawResponse.Request; req != nil {
+			httpErr.Method = req.Method
+			httpErr.URL = redactURL(req.URL)
+		}
+	}
+
+	if httpErr.Status == "" {
+		httpErr.Status = fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode))
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mediaType == "application/problem+json" {
+		problem := &ProblemDetails{}
+		if json.Unmarshal(httpErr.Body, problem) == nil {
+			httpErr.Problem = problem
+		}
+	}
+
+	return httpErr
+}
+
+// redactURL returns the URL without its password (see `url.URL.Redacted`) and
+// with the values of the query string replaced by "xxxxx"
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.Fragment, redacted.RawFragment = "", ""
+
+	if redacted.RawQuery != "" {
+		query, _ := url.ParseQuery(redacted.RawQuery)
+		for key, values := range query {
+			for i := range values {
+				values[i] = "xxxxx"
+			}
+			query[key] = values
+		}
+		redacted.RawQuery = query.Encode()
+	}
+
+	return redacted.Redacted()
+}
+
+// bodySnippet returns (at most) the first `limit` bytes of the body without
+// consuming them
+func (r *Response) bodySnippet(limit int) []byte {
+	if r.internalByteBuffer != nil && r.internalByteBuffer.Len() != 0 {
+		b := r.internalByteBuffer.Bytes()
+		if len(b) > limit {
+			b = b[:limit]
+		}
+		return append([]byte(nil), b...)
+	}
+
+	if r.RawResponse == nil || r.RawResponse.Body == nil {
+		return nil
+	}
+
+	body := r.RawResponse.Body
+	snippet, err := io.ReadAll(io.LimitReader(body, int64(limit)))
+	if err != nil && len(snippet) == 0 {
+		return nil
+	}
+
+	// Put the bytes we have read back in front of the body
+	r.RawResponse.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(snippet), body), body}
+
+	return snippet
+}
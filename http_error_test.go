@@ -0,0 +1,162 @@
+package grequests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type HTTPErrorSuite struct {
+	suite.Suite
+}
+
+func newErrorServer(status int, contentType, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Request-Id", "abc")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+}
+
+func (s *HTTPErrorSuite) TestErrorOnStatus() {
+	srv := newErrorServer(http.StatusInternalServerError, "text/plain", "boom")
+	defer srv.Close()
+
+	resp, err := Post(context.Background(), srv.URL+"/path", ErrorOnStatus())
+	s.Require().Error(err)
+
+	var httpErr *HTTPError
+	s.Require().True(errors.As(err, &httpErr))
+	s.Equal(http.StatusInternalServerError, httpErr.StatusCode)
+	s.Equal("500 Internal Server Error", httpErr.Status)
+	s.Equal("POST", httpErr.Method)
+	s.Equal(srv.URL+"/path", httpErr.URL)
+	s.Equal("abc", httpErr.Header.Get("X-Request-Id"))
+	s.Equal("boom", string(httpErr.Body))
+	s.Nil(httpErr.Problem)
+	s.Equal("grequests: POST "+srv.URL+"/path returned 500 Internal Server Error", err.Error())
+
+	s.Equal(err, resp.Error)
+	s.Equal(http.StatusInternalServerError, resp.StatusCode)
+}
+
+func (s *HTTPErrorSuite) TestURLIsRedacted() {
+	srv := newErrorServer(http.StatusInternalServerError, "text/plain", "boom")
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	u.User = url.UserPassword("user", "secret")
+	u.Path = "/path"
+	u.RawQuery = "api_key=SECRETKEY&X-Amz-Signature=SIGNATURE&page=2"
+
+	_, err := Get(context.Background(), u.String(), ErrorOnStatus())
+	s.Require().Error(err)
+	for _, secret := range []string{"secret", "SECRETKEY", "SIGNATURE"} {
+		s.NotContains(err.Error(), secret)
+	}
+
+	var httpErr *HTTPError
+	s.Require().True(errors.As(err, &httpErr))
+	s.Equal("http://user:xxxxx@"+u.Host+"/path?X-Amz-Signature=xxxxx&api_key=xxxxx&page=xxxxx", httpErr.URL)
+
+	// APIKey in the query string
+	_, err = Get(context.Background(), srv.URL, ErrorOnStatus(), APIKey(APIKeyInQuery, "key", "SECRETKEY"))
+	s.Require().Error(err)
+	s.NotContains(err.Error(), "SECRETKEY")
+}
+
+func (s *HTTPErrorSuite) TestErrorOnStatusSuccess() {
+	srv := newGetServer()
+	defer srv.Close()
+
+	resp, err := Get(context.Background(), srv.URL, ErrorOnStatus())
+	s.Require().NoError(err)
+	s.NotEmpty(resp.String())
+}
+
+func (s *HTTPErrorSuite) TestRaiseForStatus() {
+	srv := newErrorServer(http.StatusNotFound, "text/plain", "missing")
+	defer srv.Close()
+
+	resp, err := Get(context.Background(), srv.URL)
+	s.Require().NoError(err)
+
+	err = resp.RaiseForStatus()
+	var httpErr *HTTPError
+	s.Require().True(errors.As(err, &httpErr))
+	s.Equal(http.StatusNotFound, httpErr.StatusCode)
+	s.Equal("missing", string(httpErr.Body))
+
+	// The body is left intact
+	s.Equal("missing", resp.String())
+	s.Equal(resp.RaiseForStatus().Error(), err.Error())
+
+	ok := &Response{Ok: true, StatusCode: http.StatusOK}
+	s.NoError(ok.RaiseForStatus())
+
+	transportErr := errors.New("transport")
+	s.Equal(transportErr, (&Response{Error: transportErr}).RaiseForStatus())
+}
+
+func (s *HTTPErrorSuite) TestBodyIsCapped() {
+	defer func(limit int) { HTTPErrorBodyLimit = limit }(HTTPErrorBodyLimit)
+	HTTPErrorBodyLimit = 10
+
+	body := strings.Repeat("x", 100)
+	srv := newErrorServer(http.StatusBadGateway, "text/plain", body)
+	defer srv.Close()
+
+	resp, err := Get(context.Background(), srv.URL)
+	s.Require().NoError(err)
+
+	var httpErr *HTTPError
+	s.Require().True(errors.As(resp.RaiseForStatus(), &httpErr))
+	s.Len(httpErr.Body, 10)
+	s.Equal(body, resp.String())
+}
+
+func (s *HTTPErrorSuite) TestProblemDetails() {
+	problem := `{
+		"type": "https://example.com/probs/out-of-credit",
+		"title": "You do not have enough credit.",
+		"status": 403,
+		"detail": "Your current balance is 30, but that costs 50.",
+		"instance": "/account/12345/msgs/abc",
+		"balance": 30
+	}`
+	srv := newErrorServer(http.StatusForbidden, "application/problem+json; charset=utf-8", problem)
+	defer srv.Close()
+
+	_, err := Get(context.Background(), srv.URL, ErrorOnStatus())
+	var httpErr *HTTPError
+	s.Require().True(errors.As(err, &httpErr))
+	s.Require().NotNil(httpErr.Problem)
+	s.Equal("https://example.com/probs/out-of-credit", httpErr.Problem.Type)
+	s.Equal("You do not have enough credit.", httpErr.Problem.Title)
+	s.Equal(403, httpErr.Problem.Status)
+	s.Equal("Your current balance is 30, but that costs 50.", httpErr.Problem.Detail)
+	s.Equal("/account/12345/msgs/abc", httpErr.Problem.Instance)
+	s.Equal(map[string]interface{}{"balance": float64(30)}, httpErr.Problem.Extensions)
+	s.Contains(err.Error(), "You do not have enough credit.: Your current balance is 30, but that costs 50.")
+}
+
+func (s *HTTPErrorSuite) TestSessionErrorOnStatus() {
+	srv := newStatusServer(http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	session := NewSession(ErrorOnStatus())
+	_, err := session.Get(context.Background(), srv.URL)
+	var httpErr *HTTPError
+	s.True(errors.As(err, &httpErr))
+}
+
+func TestHTTPErrorSuite(t *testing.T) {
+	suite.Run(t, new(HTTPErrorSuite))
+}
@@ -0,0 +1,543 @@
+package grequests
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSignature is returned (wrapped) by `HTTPSignatureVerifier` when
+// a message isn't signed or its signature doesn't hold
+var ErrInvalidSignature = errors.New("grequests: invalid HTTP message signature")
+
+// HTTPSigner signs requests with HTTP Message Signatures (RFC 9421), adding
+// the `Signature-Input` and `Signature` headers. The algorithm is picked from
+// the type of the key: a []byte is an HMAC-SHA256 secret, an
+// `ed25519.PrivateKey` signs with Ed25519 and an `*ecdsa.PrivateKey` (P-256
+// or P-384) with ECDSA.
+type HTTPSigner struct {
+	// KeyID is sent as the keyid parameter so the verifier can find the key
+	KeyID string
+	Key   crypto.PrivateKey
+
+	// Components are the components covered by the signature: derived
+	// components such as "@method", "@authority", "@path", "@query",
+	// "@target-uri", "@scheme" and "@request-target" or header names. When
+	// the "content-digest" header is covered it is computed from the body.
+	Components []string
+
+	// Label is the name of the signature, it defaults to "sig1"
+	Label string
+
+	// Tag is an optional application specific tag parameter
+	Tag string
+
+	// Expires adds an expires parameter that far from the creation time
+	Expires time.Duration
+
+	now func() time.Time
+}
+
+// defaultSignatureComponents are covered by the signature when the signer
+// doesn't list any
+var defaultSignatureComponents = []string{"@method", "@authority", "@path", "@query"}
+
+func (s *HTTPSigner) Apply(ro *RequestOptions) {
+	ro.Authenticator = s
+}
+
+func (s *HTTPSigner) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	return false, nil
+}
+
+// Authenticate signs the request
+func (s *HTTPSigner) Authenticate(req *http.Request) error {
+	alg, err := signatureAlgorithm(s.Key)
+	if err != nil {
+		return err
+	}
+
+	components := s.Components
+	if components == nil {
+		components = defaultSignatureComponents
+		if hasBody(req) {
+			components = append(components[:len(components):len(components)], "content-digest")
+		}
+	}
+
+	if containsComponent(components, "content-digest") && req.Header.Get("Content-Digest") == "" {
+		digest := sha256.New()
+		if hasBody(req) {
+			if err := digestBody(req, digest); err != nil {
+				return err
+			}
+		}
+		req.Header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(digest.Sum(nil))+":")
+	}
+
+	params := s.signatureParams(components)
+	base, err := signatureBase(requestComponents(req), components, params)
+	if err != nil {
+		return err
+	}
+
+	signature, err := signMessage(alg, s.Key, base)
+	if err != nil {
+		return err
+	}
+
+	label := s.Label
+	if label == "" {
+		label = "sig1"
+	}
+
+	req.Header.Set("Signature-Input", label+"="+params)
+	req.Header.Set("Signature", label+"=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	return nil
+}
+
+// signatureParams serializes the covered components and the signature
+// parameters
+func (s *HTTPSigner) signatureParams(components []string) string {
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+
+	quoted := make([]string, len(components))
+	for i, component := range components {
+		quoted[i] = strconv.Quote(strings.ToLower(component))
+	}
+
+	params := "(" + strings.Join(quoted, " ") + ");created=" + strconv.FormatInt(now.Unix(), 10)
+	if s.Expires > 0 {
+		params += ";expires=" + strconv.FormatInt(now.Add(s.Expires).Unix(), 10)
+	}
+	params += ";keyid=" + strconv.Quote(s.KeyID)
+	if s.Tag != "" {
+		params += ";tag=" + strconv.Quote(s.Tag)
+	}
+	return params
+}
+
+// HTTPSignatureVerifier verifies the HTTP Message Signatures (RFC 9421) of
+// requests (e.g. incoming webhooks) and responses. Use `VerifyResponse` as an
+// `AfterResponse` hook.
+type HTTPSignatureVerifier struct {
+	// Keys maps key IDs to keys: a []byte is an HMAC-SHA256 secret, the
+	// other keys are `ed25519.PublicKey` or `*ecdsa.PublicKey`
+	Keys map[string]crypto.PublicKey
+
+	// Required are the components every signature has to cover
+	Required []string
+
+	// MaxAge rejects signatures created longer ago than that. Zero means that
+	// only the expires parameter is checked
+	MaxAge time.Duration
+
+	now func() time.Time
+}
+
+// VerifyRequest verifies the signature of the request. A covered
+// `Content-Digest` is checked against the body, which is buffered.
+func (v *HTTPSignatureVerifier) VerifyRequest(req *http.Request) error {
+	return v.verify(req.Header, requestComponents(req), func() ([]byte, error) {
+		if req.Body == nil || req.Body == http.NoBody {
+			return nil, nil
+		}
+
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		return body, err
+	})
+}
+
+// VerifyResponse verifies the signature of the response. A covered
+// `Content-Digest` is checked against the body, which is buffered so it can
+// still be read afterwards.
+func (v *HTTPSignatureVerifier) VerifyResponse(resp *Response) error {
+	return v.verify(resp.Header, responseComponents(resp), func() ([]byte, error) {
+		body := resp.Bytes()
+		return body, resp.Error
+	})
+}
+
+func (v *HTTPSignatureVerifier) verify(header http.Header, components func(string) (string, error), body func() ([]byte, error)) error {
+	inputs := parseDictionary(strings.Join(header.Values("Signature-Input"), ", "))
+	signatures := parseDictionary(strings.Join(header.Values("Signature"), ", "))
+
+	labels := make([]string, 0, len(inputs))
+	for label := range inputs {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		covered, params, err := parseSignatureInput(inputs[label])
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+
+		key, ok := v.Keys[params["keyid"]]
+		if !ok {
+			continue
+		}
+
+		if err := v.verifySignature(key, inputs[label], signatures[label], covered, params, components, body); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidSignature, label, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: no signature made with a known key", ErrInvalidSignature)
+}
+
+func (v *HTTPSignatureVerifier) verifySignature(key crypto.PublicKey, input, signature string, covered []string, params map[string]string, components func(string) (string, error), body func() ([]byte, error)) error {
+	alg, err := signatureAlgorithm(key)
+	if err != nil {
+		return err
+	}
+	if params["alg"] != "" && params["alg"] != alg {
+		return fmt.Errorf("the key can't verify %q signatures", params["alg"])
+	}
+
+	for _, required := range v.Required {
+		if !containsComponent(covered, required) {
+			return fmt.Errorf("%q is not covered", required)
+		}
+	}
+
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+
+	if expires, ok := params["expires"]; ok {
+		if unix, err := strconv.ParseInt(expires, 10, 64); err != nil || now.After(time.Unix(unix, 0)) {
+			return errors.New("the signature expired")
+		}
+	}
+
+	if v.MaxAge > 0 {
+		created, err := strconv.ParseInt(params["created"], 10, 64)
+		if err != nil || now.Sub(time.Unix(created, 0)) > v.MaxAge {
+			return errors.New("the signature is too old")
+		}
+	}
+
+	if !strings.HasPrefix(signature, ":") || !strings.HasSuffix(signature, ":") || len(signature) < 2 {
+		return errors.New("missing signature")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(signature[1 : len(signature)-1])
+	if err != nil {
+		return err
+	}
+
+	base, err := signatureBase(components, covered, input)
+	if err != nil {
+		return err
+	}
+
+	if err := verifyMessage(alg, key, base, decoded); err != nil {
+		return err
+	}
+
+	if containsComponent(covered, "content-digest") {
+		digest, err := components("content-digest")
+		if err != nil {
+			return err
+		}
+
+		content, err := body()
+		if err != nil {
+			return err
+		}
+		return verifyContentDigest(digest, content)
+	}
+	return nil
+}
+
+// signatureBase builds the signature base of RFC 9421 section 2.5
+func signatureBase(components func(string) (string, error), covered []string, params string) (string, error) {
+	var b strings.Builder
+	for _, name := range covered {
+		name = strings.ToLower(name)
+		value, err := components(name)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(strconv.Quote(name) + ": " + value + "\n")
+	}
+	b.WriteString(`"@signature-params": ` + params)
+	return b.String(), nil
+}
+
+// requestComponents resolves the components of a request, it works for
+// outgoing and incoming requests
+func requestComponents(req *http.Request) func(string) (string, error) {
+	return func(name string) (string, error) {
+		authority := req.Host
+		if authority == "" {
+			authority = req.URL.Host
+		}
+		authority = strings.ToLower(authority)
+
+		scheme := strings.ToLower(req.URL.Scheme)
+		if scheme == "" {
+			scheme = "http"
+			if req.TLS != nil {
+				scheme = "https"
+			}
+		}
+
+		switch name {
+		case "@method":
+			return req.Method, nil
+		case "@authority", "host":
+			return authority, nil
+		case "@scheme":
+			return scheme, nil
+		case "@target-uri":
+			return scheme + "://" + authority + req.URL.RequestURI(), nil
+		case "@request-target":
+			return req.URL.RequestURI(), nil
+		case "@path":
+			if path := req.URL.EscapedPath(); path != "" {
+				return path, nil
+			}
+			return "/", nil
+		case "@query":
+			return "?" + req.URL.RawQuery, nil
+		case "content-length":
+			if _, ok := req.Header["Content-Length"]; !ok && req.ContentLength > 0 {
+				return strconv.FormatInt(req.ContentLength, 10), nil
+			}
+		}
+		return headerComponent(req.Header, name)
+	}
+}
+
+func responseComponents(resp *Response) func(string) (string, error) {
+	return func(name string) (string, error) {
+		if name == "@status" {
+			return strconv.Itoa(resp.StatusCode), nil
+		}
+		return headerComponent(resp.Header, name)
+	}
+}
+
+func headerComponent(header http.Header, name string) (string, error) {
+	values := header.Values(name)
+	if strings.HasPrefix(name, "@") || len(values) == 0 {
+		return "", fmt.Errorf("grequests: the message has no %q component", name)
+	}
+
+	for i, value := range values {
+		values[i] = strings.TrimSpace(value)
+	}
+	return strings.Join(values, ", "), nil
+}
+
+func containsComponent(components []string, name string) bool {
+	for _, component := range components {
+		if strings.EqualFold(component, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// signatureAlgorithm returns the RFC 9421 algorithm name for a private or
+// public key
+func signatureAlgorithm(key interface{}) (string, error) {
+	switch k := key.(type) {
+	case []byte:
+		return "hmac-sha256", nil
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return "ed25519", nil
+	case *ecdsa.PrivateKey:
+		return ecdsaAlgorithm(k.Curve)
+	case *ecdsa.PublicKey:
+		return ecdsaAlgorithm(k.Curve)
+	}
+	return "", fmt.Errorf("grequests: unsupported HTTP signature key %T", key)
+}
+
+func ecdsaAlgorithm(curve elliptic.Curve) (string, error) {
+	switch curve {
+	case elliptic.P256():
+		return "ecdsa-p256-sha256", nil
+	case elliptic.P384():
+		return "ecdsa-p384-sha384", nil
+	}
+	return "", fmt.Errorf("grequests: unsupported ECDSA curve %s", curve.Params().Name)
+}
+
+func ecdsaDigest(alg, base string) []byte {
+	if alg == "ecdsa-p384-sha384" {
+		sum := sha512.Sum384([]byte(base))
+		return sum[:]
+	}
+	sum := sha256.Sum256([]byte(base))
+	return sum[:]
+}
+
+func signMessage(alg string, key crypto.PrivateKey, base string) ([]byte, error) {
+	switch k := key.(type) {
+	case []byte:
+		return hmacSHA256(k, base), nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, []byte(base)), nil
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, ecdsaDigest(alg, base))
+		if err != nil {
+			return nil, err
+		}
+
+		// The signature is the concatenation of r and s (RFC 9421 section 3.3.4)
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature := make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+		return signature, nil
+	}
+	return nil, fmt.Errorf("grequests: unsupported HTTP signature key %T", key)
+}
+
+func verifyMessage(alg string, key crypto.PublicKey, base string, signature []byte) error {
+	valid := false
+	switch k := key.(type) {
+	case []byte:
+		valid = hmac.Equal(hmacSHA256(k, base), signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(k, []byte(base), signature)
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			valid = ecdsa.Verify(k, ecdsaDigest(alg, base), r, s)
+		}
+	}
+
+	if !valid {
+		return errors.New("the signature doesn't match")
+	}
+	return nil
+}
+
+// verifyContentDigest checks the body against every supported digest of the
+// Content-Digest header (RFC 9530)
+func verifyContentDigest(header string, body []byte) error {
+	hashes := map[string]func() hash.Hash{"sha-256": sha256.New, "sha-512": sha512.New}
+
+	checked := false
+	for algorithm, value := range parseDictionary(header) {
+		newHash, ok := hashes[algorithm]
+		if !ok {
+			continue
+		}
+
+		h := newHash()
+		h.Write(body)
+		if value != ":"+base64.StdEncoding.EncodeToString(h.Sum(nil))+":" {
+			return fmt.Errorf("the %s Content-Digest doesn't match the body", algorithm)
+		}
+		checked = true
+	}
+
+	if !checked {
+		return errors.New("no supported Content-Digest")
+	}
+	return nil
+}
+
+// parseDictionary splits a structured field dictionary (RFC 8941) into its
+// members, the values are returned as they were serialized
+func parseDictionary(s string) map[string]string {
+	members := map[string]string{}
+
+	var (
+		start    int
+		depth    int
+		inString bool
+	)
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			switch c := s[i]; {
+			case inString && c == '\\':
+				i++
+				continue
+			case c == '"':
+				inString = !inString
+				continue
+			case inString:
+				continue
+			case c == '(':
+				depth++
+				continue
+			case c == ')':
+				depth--
+				continue
+			case c != ',' || depth > 0:
+				continue
+			}
+		}
+
+		if key, value, ok := strings.Cut(strings.TrimSpace(s[start:i]), "="); ok {
+			members[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		start = i + 1
+	}
+	return members
+}
+
+// parseSignatureInput parses the covered components (an inner list of
+// strings) and the parameters of a signature
+func parseSignatureInput(input string) ([]string, map[string]string, error) {
+	end := strings.LastIndex(input, ")")
+	if !strings.HasPrefix(input, "(") || end == -1 {
+		return nil, nil, fmt.Errorf("malformed signature input %q", input)
+	}
+
+	var components []string
+	for _, item := range strings.Fields(input[1:end]) {
+		name, err := strconv.Unquote(item)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unsupported component %s", item)
+		}
+		components = append(components, name)
+	}
+
+	params := map[string]string{}
+	for _, param := range strings.Split(input[end+1:], ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if key == "" {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		params[key] = value
+	}
+
+	return components, params, nil
+}
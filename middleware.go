@@ -0,0 +1,31 @@
+package grequests
+
+import "net/http"
+
+// Doer is the interface that wraps the execution of a single request
+type Doer interface {
+	Do(req *http.Request) (*Response, error)
+}
+
+// DoerFunc is an adapter to allow the use of ordinary functions as a `Doer`
+type DoerFunc func(req *http.Request) (*Response, error)
+
+// Do calls f(req)
+func (f DoerFunc) Do(req *http.Request) (*Response, error) {
+	return f(req)
+}
+
+// Middleware wraps a `Doer` with additional behaviour. A middleware may
+// rewrite the request before passing it on, short-circuit the chain by not
+// calling `next` at all, or inspect and replace the response and error that
+// `next` returned.
+type Middleware func(next Doer) Doer
+
+// chainMiddleware wraps the doer with the middleware – the first middleware
+// is the outermost one
+func chainMiddleware(middleware []Middleware, doer Doer) Doer {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		doer = middleware[i](doer)
+	}
+	return doer
+}
@@ -0,0 +1,144 @@
+package grequests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MiddlewareSuite struct {
+	suite.Suite
+}
+
+func recordingMiddleware(name string, calls *[]string) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*Response, error) {
+			*calls = append(*calls, name+":before")
+			resp, err := next.Do(req)
+			*calls = append(*calls, name+":after")
+			return resp, err
+		})
+	}
+}
+
+func (s *MiddlewareSuite) TestOrder() {
+	srv := newGetServer()
+	defer srv.Close()
+
+	var calls []string
+	resp, err := Get(context.Background(), srv.URL,
+		Use(recordingMiddleware("one", &calls), recordingMiddleware("two", &calls)),
+		Use(recordingMiddleware("three", &calls)),
+	)
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	s.Equal([]string{
+		"one:before", "two:before", "three:before",
+		"three:after", "two:after", "one:after",
+	}, calls)
+}
+
+func (s *MiddlewareSuite) TestRewriteRequest() {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	addHeader := func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*Response, error) {
+			req.Header.Set("X-Middleware", "yes")
+			return next.Do(req)
+		})
+	}
+
+	resp, err := Get(context.Background(), srv.URL, Use(addHeader))
+	s.Require().NoError(err)
+	var echo echoResponse
+	s.Require().NoError(resp.JSON(&echo))
+	s.Equal("yes", echo.Headers.Get("X-Middleware"))
+}
+
+func (s *MiddlewareSuite) TestShortCircuit() {
+	cached := func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*Response, error) {
+			return NewResponse(&http.Response{
+				StatusCode:    http.StatusOK,
+				Header:        http.Header{"X-Cache": {"hit"}},
+				Body:          io.NopCloser(strings.NewReader("cached")),
+				ContentLength: int64(len("cached")),
+				Request:       req,
+			}), nil
+		})
+	}
+
+	// Nothing is listening here – the request must never be sent
+	resp, err := Get(context.Background(), "http://127.0.0.1:1", Use(cached))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	s.Equal("hit", resp.Header.Get("X-Cache"))
+	s.Equal("cached", resp.String())
+}
+
+func (s *MiddlewareSuite) TestObserveAndReplaceErrors() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	addr := l.Addr().String()
+	s.Require().NoError(l.Close())
+
+	var observed error
+	fallback := func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*Response, error) {
+			resp, err := next.Do(req)
+			if err != nil {
+				observed = err
+				return NewResponse(&http.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Header:     http.Header{},
+					Body:       http.NoBody,
+				}), nil
+			}
+			return resp, err
+		})
+	}
+
+	resp, err := Get(context.Background(), "http://"+addr, Use(fallback))
+	s.Require().NoError(err)
+	s.Error(observed)
+	s.False(resp.Ok)
+	s.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+
+	failing := func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*Response, error) {
+			return nil, errors.New("denied")
+		})
+	}
+	resp, err = Get(context.Background(), "http://"+addr, Use(failing))
+	s.EqualError(err, "denied")
+	s.Require().NotNil(resp)
+	s.EqualError(resp.Error, "denied")
+}
+
+func (s *MiddlewareSuite) TestSessionMiddleware() {
+	srv := newGetServer()
+	defer srv.Close()
+
+	var calls []string
+	session := NewSession(Use(recordingMiddleware("session", &calls)))
+
+	_, err := session.Get(context.Background(), srv.URL, Use(recordingMiddleware("request", &calls)))
+	s.Require().NoError(err)
+	s.Equal([]string{"session:before", "request:before", "request:after", "session:after"}, calls)
+
+	calls = nil
+	_, err = session.Get(context.Background(), srv.URL)
+	s.Require().NoError(err)
+	s.Equal([]string{"session:before", "session:after"}, calls)
+}
+
+func TestMiddlewareSuite(t *testing.T) {
+	suite.Run(t, new(MiddlewareSuite))
+}
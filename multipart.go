@@ -0,0 +1,245 @@
+package grequests
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// multipartBody is a multipart/form-data body which is streamed to the server
+// (through a pipe) instead of being buffered in memory
+type multipartBody struct {
+	boundary string
+
+	// files have their FieldName (and FileName) resolved
+	files []FileUpload
+
+	data map[string]string
+	keys []string
+}
+
+func newMultipartBody(ro *RequestOptions) (*multipartBody, error) {
+	body := &multipartBody{
+		boundary: multipart.NewWriter(io.Discard).Boundary(),
+		files:    make([]FileUpload, 0, len(ro.Files)),
+		data:     ro.Data,
+	}
+
+	for i, f := range ro.Files {
+		if f.FileContents == nil {
+			return nil, errors.New("grequests: Pointer FileContents cannot be nil")
+		}
+
+		if f.FieldName == "" {
+			if len(ro.Files) > 1 {
+				f.FieldName = strings.Join([]string{"file", strconv.Itoa(i + 1)}, "")
+			} else {
+				f.FieldName = "file"
+			}
+		}
+
+		if f.FileMime != "" && f.FileName == "" {
+			f.FileName = "filename"
+		}
+
+		body.files = append(body.files, f)
+	}
+
+	// The fields are sorted so that every copy of the body is identical
+	for key := range ro.Data {
+		body.keys = append(body.keys, key)
+	}
+	sort.Strings(body.keys)
+
+	return body, nil
+}
+
+func (b *multipartBody) contentType() string {
+	return "multipart/form-data; boundary=" + b.boundary
+}
+
+// write writes the body to w, copyFile is called to write the contents of the
+// i-th file
+func (b *multipartBody) write(w io.Writer, copyFile func(dst io.Writer, i int) error) error {
+	multipartWriter := multipart.NewWriter(w)
+	if err := multipartWriter.SetBoundary(b.boundary); err != nil {
+		return err
+	}
+
+	for i, f := range b.files {
+		var writer io.Writer
+		var err error
+
+		if f.FileMime != "" {
+			h := make(textproto.MIMEHeader)
+			h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(f.FieldName), escapeQuotes(f.FileName)))
+			h.Set("Content-Type", f.FileMime)
+			writer, err = multipartWriter.CreatePart(h)
+		} else {
+			writer, err = multipartWriter.CreateFormFile(f.FieldName, f.FileName)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := copyFile(writer, i); err != nil {
+			return err
+		}
+	}
+
+	// Populate the other parts of the form (if there are any)
+	for _, key := range b.keys {
+		if err := multipartWriter.WriteField(key, b.data[key]); err != nil {
+			return err
+		}
+	}
+
+	return multipartWriter.Close()
+}
+
+// contentLength returns the exact size of the body or -1 when the size of
+// one of the files is unknown
+func (b *multipartBody) contentLength() int64 {
+	sizes := make([]int64, len(b.files))
+	for i, f := range b.files {
+		size, ok := readerSize(f.FileContents)
+		if !ok {
+			return -1
+		}
+		sizes[i] = size
+	}
+
+	// Dry run the body without the file contents
+	counter := &countingWriter{}
+	err := b.write(counter, func(_ io.Writer, i int) error {
+		counter.n += sizes[i]
+		return nil
+	})
+	if err != nil {
+		return -1
+	}
+	return counter.n
+}
+
+// reader returns a reader over the body, the sources are closed once they
+// have been streamed (or once the reader is closed)
+func (b *multipartBody) reader(sources []io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	return &multipartReader{body: b, sources: sources, pr: pr, pw: pw}
+}
+
+// getBody returns a function that re-opens every file and returns a new copy
+// of the body or nil when one of the files cannot be re-opened
+func (b *multipartBody) getBody() func() (io.ReadCloser, error) {
+	for _, f := range b.files {
+		if f.GetFileContents == nil {
+			return nil
+		}
+	}
+
+	return func() (io.ReadCloser, error) {
+		sources := make([]io.ReadCloser, 0, len(b.files))
+		for _, f := range b.files {
+			rc, err := f.GetFileContents()
+			if err != nil {
+				_ = closeAll(sources)
+				return nil, err
+			}
+			sources = append(sources, rc)
+		}
+		return b.reader(sources), nil
+	}
+}
+
+// multipartReader streams the body through a pipe. The goroutine writing to
+// the pipe is only started on the first Read so that nothing leaks if the
+// request is never sent
+type multipartReader struct {
+	body    *multipartBody
+	sources []io.ReadCloser
+
+	start sync.Once
+	pr    *io.PipeReader
+	pw    *io.PipeWriter
+}
+
+func (r *multipartReader) Read(p []byte) (int, error) {
+	r.start.Do(func() {
+		go func() {
+			err := r.body.write(r.pw, func(dst io.Writer, i int) error {
+				_, err := io.Copy(dst, r.sources[i])
+				return err
+			})
+			if closeErr := closeAll(r.sources); err == nil {
+				err = closeErr
+			}
+			_ = r.pw.CloseWithError(err)
+		}()
+	})
+	return r.pr.Read(p)
+}
+
+func (r *multipartReader) Close() error {
+	started := true
+	r.start.Do(func() {
+		started = false
+	})
+
+	// Closing the pipe makes the writing goroutine stop (and close the sources)
+	err := r.pr.Close()
+	if !started {
+		err = closeAll(r.sources)
+	}
+	return err
+}
+
+func closeAll(closers []io.ReadCloser) error {
+	var err error
+	for _, c := range closers {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// readerSize returns the number of bytes left in the reader if it can be
+// found without reading it
+func readerSize(r io.Reader) (int64, bool) {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len()), true
+	case interface {
+		Stat() (fs.FileInfo, error)
+		io.Seeker
+	}:
+		info, err := v.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return 0, false
+		}
+
+		offset, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		return info.Size() - offset, true
+	}
+	return 0, false
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,293 @@
+package grequests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MultipartSuite struct {
+	suite.Suite
+}
+
+type uploadEcho struct {
+	ContentLength    int64
+	TransferEncoding []string
+	Files            map[string]string
+	Fields           map[string]string
+}
+
+// newUploadServer parses the multipart form and echoes what it received. A
+// request to /redirect is redirected (keeping the body) to /
+func newUploadServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		echo := uploadEcho{
+			ContentLength:    r.ContentLength,
+			TransferEncoding: r.TransferEncoding,
+			Files:            map[string]string{},
+			Fields:           map[string]string{},
+		}
+		for name, headers := range r.MultipartForm.File {
+			fd, _ := headers[0].Open()
+			b, _ := io.ReadAll(fd)
+			echo.Files[name] = headers[0].Filename + ":" + string(b)
+		}
+		for name, values := range r.MultipartForm.Value {
+			echo.Fields[name] = values[0]
+		}
+		_ = json.NewEncoder(w).Encode(echo)
+	}))
+}
+
+type trackingCloser struct {
+	io.Reader
+	closed int32
+}
+
+func (t *trackingCloser) Close() error {
+	atomic.AddInt32(&t.closed, 1)
+	return nil
+}
+
+func (s *MultipartSuite) post(url string, options ...Option) uploadEcho {
+	resp, err := Post(context.Background(), url, options...)
+	s.Require().NoError(err)
+	s.Require().True(resp.Ok, resp.String())
+
+	var echo uploadEcho
+	s.Require().NoError(resp.JSON(&echo))
+	return echo
+}
+
+func (s *MultipartSuite) TestContentLengthWhenSizesAreKnown() {
+	srv := newUploadServer()
+	defer srv.Close()
+
+	files, err := FileUploadFromDisk("testdata/mypassword")
+	s.Require().NoError(err)
+
+	echo := s.post(srv.URL, FromRequestOptions(&RequestOptions{
+		Files: files,
+		Data:  map[string]string{"foo": "bar"},
+	}))
+	s.Greater(echo.ContentLength, int64(0))
+	s.Empty(echo.TransferEncoding)
+	s.Equal("mypassword:saucy sauce", strings.TrimSpace(echo.Files["file"]))
+	s.Equal("bar", echo.Fields["foo"])
+}
+
+func (s *MultipartSuite) TestChunkedWhenSizeIsUnknown() {
+	srv := newUploadServer()
+	defer srv.Close()
+
+	echo := s.post(srv.URL, Files([]FileUpload{
+		{FileName: "a.txt", FileContents: io.NopCloser(strings.NewReader("one"))},
+		{FileName: "b.txt", FileContents: io.NopCloser(strings.NewReader("two")), FileMime: "text/plain"},
+	}))
+	s.Equal(int64(-1), echo.ContentLength)
+	s.Equal([]string{"chunked"}, echo.TransferEncoding)
+	s.Equal("a.txt:one", echo.Files["file1"])
+	s.Equal("b.txt:two", echo.Files["file2"])
+}
+
+func (s *MultipartSuite) TestContentLengthIsExact() {
+	ro := &RequestOptions{
+		Files: []FileUpload{
+			{FileName: "a.txt", FileContents: readCloser{strings.NewReader("one")}},
+			{FileContents: readCloser{strings.NewReader("two")}, FileMime: "text/plain"},
+		},
+		Data: map[string]string{"a": "1", "b": "2"},
+	}
+	req, err := createMultiPartPostRequest("POST", "http://x", ro)
+	s.Require().NoError(err)
+
+	b, err := io.ReadAll(req.Body)
+	s.Require().NoError(err)
+	s.Equal(int64(len(b)), req.ContentLength)
+	s.Contains(req.Header.Get("Content-Type"), "multipart/form-data; boundary=")
+
+	// Hiding the size of one of the readers makes the length unknown
+	ro.Files[0].FileContents = &trackingCloser{Reader: strings.NewReader("one")}
+	body, err := newMultipartBody(ro)
+	s.Require().NoError(err)
+	s.Equal(int64(-1), body.contentLength())
+}
+
+// readCloser keeps the Len method of the reader visible
+type readCloser struct {
+	*strings.Reader
+}
+
+func (readCloser) Close() error { return nil }
+
+func (s *MultipartSuite) TestRedirectResendsBody() {
+	srv := newUploadServer()
+	defer srv.Close()
+
+	files, err := FileUploadFromDisk("testdata/mypassword")
+	s.Require().NoError(err)
+
+	echo := s.post(srv.URL+"/redirect", Files(files))
+	s.Equal("mypassword:saucy sauce", strings.TrimSpace(echo.Files["file"]))
+}
+
+func (s *MultipartSuite) TestRetryResendsBody() {
+	var hits int32
+	bodies := make(chan string, 3)
+	srv := newFlakyServer(2, http.StatusServiceUnavailable, &hits, bodies)
+	defer srv.Close()
+
+	files, err := FileUploadFromGlob("testdata/*")
+	s.Require().NoError(err)
+
+	resp, err := Post(context.Background(), srv.URL, Files(files),
+		Retry(RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond, RetryNonIdempotent: true}))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	s.Equal(int32(3), atomic.LoadInt32(&hits))
+
+	first := <-bodies
+	s.Contains(first, "saucy sauce")
+	s.Equal(first, <-bodies)
+	s.Equal(first, <-bodies)
+}
+
+func (s *MultipartSuite) TestNoGetBodyWithoutReopen() {
+	req, err := createMultiPartPostRequest("POST", "http://x", &RequestOptions{
+		Files: []FileUpload{{FileName: "a", FileContents: io.NopCloser(strings.NewReader("a"))}},
+	})
+	s.Require().NoError(err)
+	s.Nil(req.GetBody)
+
+	req, err = createMultiPartPostRequest("POST", "http://x", &RequestOptions{
+		Files: []FileUpload{{
+			FileName:        "a",
+			FileContents:    io.NopCloser(strings.NewReader("a")),
+			GetFileContents: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("a")), nil },
+		}},
+	})
+	s.Require().NoError(err)
+	s.Require().NotNil(req.GetBody)
+
+	first, err := io.ReadAll(req.Body)
+	s.Require().NoError(err)
+	body, err := req.GetBody()
+	s.Require().NoError(err)
+	second, err := io.ReadAll(body)
+	s.Require().NoError(err)
+	s.Equal(first, second)
+}
+
+func (s *MultipartSuite) TestUnreadBodyClosesSources() {
+	source := &trackingCloser{Reader: strings.NewReader("data")}
+	req, err := createMultiPartPostRequest("POST", "http://x", &RequestOptions{
+		Files: []FileUpload{{FileName: "a", FileContents: source}},
+	})
+	s.Require().NoError(err)
+	s.NoError(req.Body.Close())
+	s.Equal(int32(1), atomic.LoadInt32(&source.closed))
+}
+
+func (s *MultipartSuite) TestFailedRequestClosesSources() {
+	hookErr := errors.New("hook failed")
+	source := &trackingCloser{Reader: strings.NewReader("data")}
+	_, err := Post(context.Background(), "http://x",
+		FromRequestOptions(&RequestOptions{Files: []FileUpload{{FileName: "a", FileContents: source}}}),
+		BeforeRequest(func(*http.Request) error { return hookErr }))
+	s.ErrorIs(err, hookErr)
+	s.Equal(int32(1), atomic.LoadInt32(&source.closed))
+
+	authErr := errors.New("auth failed")
+	source = &trackingCloser{Reader: strings.NewReader("data")}
+	_, err = Put(context.Background(), "http://x",
+		FromRequestOptions(&RequestOptions{Files: []FileUpload{{FileName: "a", FileContents: source}}}),
+		UseAuthenticator(failingAuth{authErr}))
+	s.ErrorIs(err, authErr)
+	s.Equal(int32(1), atomic.LoadInt32(&source.closed))
+}
+
+func (s *MultipartSuite) TestClosingStopsStreaming() {
+	// An endless file is only read as far as the body is consumed
+	source := &trackingCloser{Reader: zeroReader{}}
+	req, err := createMultiPartPostRequest("POST", "http://x", &RequestOptions{
+		Files: []FileUpload{{FileName: "a", FileContents: source}},
+	})
+	s.Require().NoError(err)
+	s.Equal(int64(-1), req.ContentLength)
+
+	_, err = io.ReadFull(req.Body, make([]byte, 1<<16))
+	s.Require().NoError(err)
+	s.NoError(req.Body.Close())
+	s.Eventually(func() bool { return atomic.LoadInt32(&source.closed) == 1 }, time.Second, time.Millisecond)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func (s *MultipartSuite) TestReaderSize() {
+	size, ok := readerSize(strings.NewReader("four"))
+	s.True(ok)
+	s.Equal(int64(4), size)
+
+	fd, err := os.Open("testdata/mypassword")
+	s.Require().NoError(err)
+	defer fd.Close()
+	info, err := fd.Stat()
+	s.Require().NoError(err)
+
+	size, ok = readerSize(fd)
+	s.True(ok)
+	s.Equal(info.Size(), size)
+
+	_, err = fd.Seek(2, io.SeekStart)
+	s.Require().NoError(err)
+	size, _ = readerSize(fd)
+	s.Equal(info.Size()-2, size)
+
+	_, ok = readerSize(io.NopCloser(strings.NewReader("x")))
+	s.False(ok)
+}
+
+func (s *MultipartSuite) TestPutSendsContentLength() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Join(r.TransferEncoding, ",")))
+	}))
+	defer srv.Close()
+
+	files, err := FileUploadFromDisk("testdata/mypassword")
+	s.Require().NoError(err)
+
+	resp, err := Put(context.Background(), srv.URL, Files(files))
+	s.Require().NoError(err)
+	s.Empty(resp.String())
+}
+
+func TestMultipartSuite(t *testing.T) {
+	suite.Run(t, new(MultipartSuite))
+}
@@ -0,0 +1,213 @@
+package grequests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OAuth2ExpiryDelta is how long before its expiry an OAuth2 access token is
+// renewed, so that it doesn't expire while the request is in flight. You can
+// change this globally by modifying this variable.
+var OAuth2ExpiryDelta = 30 * time.Second
+
+// OAuth2TokenTimeout bounds the time a token request may take when the
+// request that needs the token has no `RequestTimeout`. Every request waiting
+// for the token fails when it is exceeded. You can change this globally by
+// modifying this variable.
+var OAuth2TokenTimeout = 30 * time.Second
+
+// oauth2Token is an access token issued by the token endpoint
+type oauth2Token struct {
+	accessToken string
+	tokenType   string
+	expiry      time.Time
+}
+
+func (t *oauth2Token) valid() bool {
+	return t != nil && (t.expiry.IsZero() || time.Now().Before(t.expiry.Add(-OAuth2ExpiryDelta)))
+}
+
+func (t *oauth2Token) authorization() string {
+	if t.tokenType == "" || strings.EqualFold(t.tokenType, "bearer") {
+		return "Bearer " + t.accessToken
+	}
+	return t.tokenType + " " + t.accessToken
+}
+
+// oauth2Refresh is a token request in flight, every caller that needs a new
+// token waits for it
+type oauth2Refresh struct {
+	done  chan struct{}
+	token *oauth2Token
+	err   error
+}
+
+// oauth2Auth fetches an access token from the token endpoint (RFC 6749) and
+// caches it until it expires
+type oauth2Auth struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	grantType    string
+	scopes       []string
+
+	mu           sync.Mutex
+	refreshToken string
+	token        *oauth2Token
+	refresh      *oauth2Refresh
+}
+
+// oauth2Request is the authenticator of a single request, token requests are
+// made with the transport options of that request
+type oauth2Request struct {
+	*oauth2Auth
+	ro *RequestOptions
+}
+
+// oauth2TokenResponse is the successful response of the token endpoint
+type oauth2TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (a *oauth2Auth) Apply(ro *RequestOptions) {
+	ro.Authenticator = a
+}
+
+func (a *oauth2Auth) bind(ro *RequestOptions) Authenticator {
+	return &oauth2Request{oauth2Auth: a, ro: ro}
+}
+
+func (a *oauth2Auth) Authenticate(req *http.Request) error {
+	return a.bind(&RequestOptions{}).Authenticate(req)
+}
+
+func (a *oauth2Auth) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	return a.bind(&RequestOptions{}).Challenge(req, resp)
+}
+
+func (r *oauth2Request) Authenticate(req *http.Request) error {
+	token, err := r.getToken(req.Context(), "")
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", token.authorization())
+	return nil
+}
+
+// Challenge renews the token the request was rejected with. The token is
+// renewed once even when several requests are rejected at the same time.
+func (r *oauth2Request) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	rejected := req.Header.Get("Authorization")
+	if rejected == "" {
+		return false, nil
+	}
+
+	if _, err := r.getToken(req.Context(), rejected); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// getToken returns a valid token, fetching a new one when the cached token
+// expired or is the one that was rejected
+func (r *oauth2Request) getToken(ctx context.Context, rejected string) (*oauth2Token, error) {
+	r.mu.Lock()
+	if r.token.valid() && (rejected == "" || r.token.authorization() != rejected) {
+		token := r.token
+		r.mu.Unlock()
+		return token, nil
+	}
+
+	refresh := r.refresh
+	if refresh == nil {
+		refresh = &oauth2Refresh{done: make(chan struct{})}
+		r.refresh = refresh
+
+		// The token is shared, a caller giving up must not fail the others
+		go r.fetchToken(context.WithoutCancel(ctx), refresh)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-refresh.done:
+		return refresh.token, refresh.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *oauth2Request) fetchToken(ctx context.Context, refresh *oauth2Refresh) {
+	// The request isn't canceled with its callers, it must not hang forever
+	timeout := r.ro.RequestTimeout
+	if timeout == 0 {
+		timeout = OAuth2TokenTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	r.mu.Lock()
+	data := map[string]string{"grant_type": r.grantType}
+	if r.grantType == "refresh_token" {
+		data["refresh_token"] = r.refreshToken
+	}
+	r.mu.Unlock()
+
+	if len(r.scopes) != 0 {
+		data["scope"] = strings.Join(r.scopes, " ")
+	}
+
+	ro := transportOptions(r.ro)
+	ro.Data = data
+	ro.Headers = map[string]string{"Accept": "application/json"}
+
+	// RFC 6749 section 2.3.1, public clients only identify themselves
+	if r.clientSecret != "" {
+		ro.Auth = []string{url.QueryEscape(r.clientID), url.QueryEscape(r.clientSecret)}
+	} else if r.clientID != "" {
+		data["client_id"] = r.clientID
+	}
+
+	var token *oauth2Token
+	resp, err := Post(ctx, r.tokenURL, FromRequestOptions(ro), ErrorOnStatus())
+	if err == nil {
+		var body oauth2TokenResponse
+		if err = resp.JSON(&body); err == nil && body.AccessToken == "" {
+			err = fmt.Errorf("grequests: the token endpoint did not return an access token")
+		}
+
+		if err == nil {
+			token = &oauth2Token{accessToken: body.AccessToken, tokenType: body.TokenType}
+			if body.ExpiresIn > 0 {
+				token.expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+			}
+			if body.RefreshToken != "" && r.grantType == "refresh_token" {
+				r.mu.Lock()
+				r.refreshToken = body.RefreshToken
+				r.mu.Unlock()
+			}
+		}
+	}
+
+	if err != nil {
+		err = fmt.Errorf("grequests: unable to fetch the OAuth2 token: %w", err)
+	}
+
+	r.mu.Lock()
+	if token != nil {
+		r.token = token
+	}
+	r.refresh = nil
+	r.mu.Unlock()
+
+	refresh.token, refresh.err = token, err
+	close(refresh.done)
+}
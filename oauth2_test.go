@@ -0,0 +1,273 @@
+package grequests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type OAuth2Suite struct {
+	suite.Suite
+}
+
+// oauth2Server is both a token endpoint (/token) and a resource server that
+// only accepts the last token it issued
+type oauth2Server struct {
+	*httptest.Server
+
+	expiresIn int
+	delay     time.Duration
+
+	mu            sync.Mutex
+	issued        int
+	current       string
+	refreshToken  string
+	tokenRequests []tokenRequest
+	forms         []map[string]string
+}
+
+func newOAuth2Server() *oauth2Server {
+	o := &oauth2Server{expiresIn: 3600, refreshToken: "refresh-0"}
+	o.Server = httptest.NewServer(http.HandlerFunc(o.handle))
+	return o
+}
+
+func (o *oauth2Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/token" {
+		o.mu.Lock()
+		current := o.current
+		o.mu.Unlock()
+
+		if current == "" || r.Header.Get("Authorization") != "Bearer "+current {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+		return
+	}
+
+	time.Sleep(o.delay)
+	_ = r.ParseForm()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	form := map[string]string{}
+	for key := range r.PostForm {
+		form[key] = r.PostForm.Get(key)
+	}
+	user, password, basicAuth := r.BasicAuth()
+	o.tokenRequests = append(o.tokenRequests, tokenRequest{user, password, basicAuth, r.UserAgent()})
+	o.forms = append(o.forms, form)
+
+	if form["grant_type"] == "refresh_token" && form["refresh_token"] != o.refreshToken {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	o.issued++
+	o.current = fmt.Sprintf("token-%d", o.issued)
+	o.refreshToken = fmt.Sprintf("refresh-%d", o.issued)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  o.current,
+		"token_type":    "bearer",
+		"expires_in":    o.expiresIn,
+		"refresh_token": o.refreshToken,
+	})
+}
+
+// tokenRequest is what the token endpoint received besides the form
+type tokenRequest struct {
+	user, password string
+	basicAuth      bool
+	userAgent      string
+}
+
+// revoke makes the resource server reject every token issued so far
+func (o *oauth2Server) revoke() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.current = "revoked"
+}
+
+func (o *oauth2Server) tokenCount() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.forms)
+}
+
+func (s *OAuth2Suite) get(session *Session, url string) *Response {
+	resp, err := session.Get(context.Background(), url)
+	s.Require().NoError(err)
+	return resp
+}
+
+func (s *OAuth2Suite) TestClientCredentials() {
+	srv := newOAuth2Server()
+	defer srv.Close()
+
+	session := NewSession(OAuth2ClientCredentials(srv.URL+"/token", "client id", "secret", []string{"read", "write"}), UserAgent("agent"))
+	for i := 0; i < 3; i++ {
+		s.Equal("ok", s.get(session, srv.URL).String())
+	}
+
+	s.Require().Equal(1, srv.tokenCount())
+	s.Equal(map[string]string{"grant_type": "client_credentials", "scope": "read write"}, srv.forms[0])
+
+	s.Equal(tokenRequest{"client+id", "secret", true, "agent"}, srv.tokenRequests[0])
+}
+
+func (s *OAuth2Suite) TestRenewedBeforeExpiry() {
+	srv := newOAuth2Server()
+	srv.expiresIn = int(OAuth2ExpiryDelta/time.Second) - 1
+	defer srv.Close()
+
+	session := NewSession(OAuth2ClientCredentials(srv.URL+"/token", "id", "secret", nil))
+	s.Equal("ok", s.get(session, srv.URL).String())
+	s.Equal("ok", s.get(session, srv.URL).String())
+	s.Equal(2, srv.tokenCount())
+}
+
+func (s *OAuth2Suite) TestRenewedWhenRejected() {
+	srv := newOAuth2Server()
+	defer srv.Close()
+
+	session := NewSession(OAuth2ClientCredentials(srv.URL+"/token", "id", "secret", nil))
+	s.Equal("ok", s.get(session, srv.URL).String())
+
+	srv.revoke()
+	s.Equal("ok", s.get(session, srv.URL).String())
+	s.Equal(2, srv.tokenCount())
+}
+
+func (s *OAuth2Suite) TestRejectedOnce() {
+	srv := newOAuth2Server()
+	defer srv.Close()
+
+	// The resource server rejects every token, the request is only retried once
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			srv.handle(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	session := NewSession(OAuth2ClientCredentials(srv.URL+"/token", "id", "secret", nil))
+	s.Equal(http.StatusUnauthorized, s.get(session, srv.URL).StatusCode)
+	s.Equal(2, srv.tokenCount())
+}
+
+func (s *OAuth2Suite) TestConcurrentRefresh() {
+	srv := newOAuth2Server()
+	srv.delay = 50 * time.Millisecond
+	defer srv.Close()
+
+	session := NewSession(OAuth2ClientCredentials(srv.URL+"/token", "id", "secret", nil))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := session.Get(context.Background(), srv.URL)
+			s.NoError(err)
+			s.Equal("ok", resp.String())
+		}()
+	}
+	wg.Wait()
+	s.Equal(1, srv.tokenCount())
+
+	srv.revoke()
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := session.Get(context.Background(), srv.URL)
+			s.NoError(err)
+			s.Equal("ok", resp.String())
+		}()
+	}
+	wg.Wait()
+	s.Equal(2, srv.tokenCount())
+}
+
+func (s *OAuth2Suite) TestRefreshToken() {
+	srv := newOAuth2Server()
+	defer srv.Close()
+
+	session := NewSession(OAuth2RefreshToken(srv.URL+"/token", "public", "", "refresh-0", []string{"read"}))
+	s.Equal("ok", s.get(session, srv.URL).String())
+
+	// The rotated refresh token is used for the next renewal
+	srv.revoke()
+	s.Equal("ok", s.get(session, srv.URL).String())
+
+	s.Require().Equal(2, srv.tokenCount())
+	s.Equal(map[string]string{"grant_type": "refresh_token", "refresh_token": "refresh-0", "client_id": "public", "scope": "read"}, srv.forms[0])
+	s.Equal("refresh-1", srv.forms[1]["refresh_token"])
+
+	s.False(srv.tokenRequests[0].basicAuth)
+}
+
+func (s *OAuth2Suite) TestTokenError() {
+	srv := newOAuth2Server()
+	defer srv.Close()
+
+	_, err := Get(context.Background(), srv.URL, OAuth2RefreshToken(srv.URL+"/token", "id", "secret", "invalid", nil))
+	s.Require().Error(err)
+
+	var httpErr *HTTPError
+	s.Require().True(errors.As(err, &httpErr))
+	s.Equal(http.StatusBadRequest, httpErr.StatusCode)
+	s.Contains(string(httpErr.Body), "invalid_grant")
+}
+
+func (s *OAuth2Suite) TestCanceledWaiter() {
+	srv := newOAuth2Server()
+	srv.delay = 100 * time.Millisecond
+	defer srv.Close()
+
+	auth := OAuth2ClientCredentials(srv.URL+"/token", "id", "secret", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := Get(ctx, srv.URL, auth)
+	s.ErrorIs(err, context.DeadlineExceeded)
+
+	// The refresh wasn't abandoned with the first caller
+	resp, err := Get(context.Background(), srv.URL, auth)
+	s.Require().NoError(err)
+	s.Equal("ok", resp.String())
+	s.Equal(1, srv.tokenCount())
+}
+
+func (s *OAuth2Suite) TestTokenTimeout() {
+	srv := newOAuth2Server()
+	srv.delay = time.Second
+	defer srv.Close()
+
+	defer func(timeout time.Duration) { OAuth2TokenTimeout = timeout }(OAuth2TokenTimeout)
+	OAuth2TokenTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	_, err := Get(context.Background(), srv.URL, OAuth2ClientCredentials(srv.URL+"/token", "id", "secret", nil))
+	s.ErrorIs(err, context.DeadlineExceeded)
+	s.ErrorContains(err, "unable to fetch the OAuth2 token")
+	s.Less(time.Since(start), srv.delay)
+}
+
+func TestOAuth2Suite(t *testing.T) {
+	suite.Run(t, new(OAuth2Suite))
+}
@@ -2,6 +2,9 @@ package grequests
 
 import (
 	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
 	"io"
 	"net"
 	"net/http"
@@ -14,6 +17,14 @@ type Option interface {
 	Apply(*RequestOptions)
 }
 
+func applyOptions(ro *RequestOptions, options []Option) {
+	for _, opt := range options {
+		if opt != nil {
+			opt.Apply(ro)
+		}
+	}
+}
+
 type optionFunc func(*RequestOptions)
 
 func (o optionFunc) Apply(r *RequestOptions) {
@@ -83,9 +94,91 @@ func Host(host string) Option {
 // BasicAuth allows you to specify a user name and password that you wish to
 // use when requesting the URL. It will use basic HTTP authentication
 // formatting the username and password in base64.
-func BasicAuth(username, password string) Option {
+func BasicAuth(username, password string) AuthOption {
+	return &basicAuth{username: username, password: password}
+}
+
+// BearerToken authenticates the request with an `Authorization: Bearer` header
+func BearerToken(token string) AuthOption {
+	return &bearerToken{token: token}
+}
+
+// APIKey authenticates the request with a key sent either as a header or as a
+// query parameter (depending on the location) named name
+func APIKey(location APIKeyLocation, name, value string) AuthOption {
+	return &apiKey{location: location, name: name, value: value}
+}
+
+// DigestAuth authenticates the request with HTTP Digest authentication (RFC
+// 7616). The challenge is cached, a `Session` created with this option only
+// pays the extra round trip on its first request to a host.
+func DigestAuth(username, password string) AuthOption {
+	return &digestAuth{username: username, password: password}
+}
+
+// OAuth2ClientCredentials authenticates the request with an access token
+// obtained from tokenURL with the OAuth2 client credentials grant. The token is
+// cached (per `Session` when used as a session option) and renewed
+// `OAuth2ExpiryDelta` before it expires or when the server rejects it. The
+// token request is made with the transport options of the request.
+func OAuth2ClientCredentials(tokenURL, clientID, clientSecret string, scopes []string) AuthOption {
+	return &oauth2Auth{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		grantType:    "client_credentials",
+		scopes:       scopes,
+	}
+}
+
+// OAuth2RefreshToken is like `OAuth2ClientCredentials` but obtains the access
+// token with the refresh token grant. The clientSecret may be empty for public
+// clients and a refresh token rotated by the server replaces refreshToken.
+func OAuth2RefreshToken(tokenURL, clientID, clientSecret, refreshToken string, scopes []string) AuthOption {
+	return &oauth2Auth{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		grantType:    "refresh_token",
+		refreshToken: refreshToken,
+		scopes:       scopes,
+	}
+}
+
+// SigV4 signs the requests with AWS Signature Version 4 for the service in
+// the region. The body produced by the request options is hashed, see
+// `SigV4Signer` to change how the payload is signed.
+func SigV4(credentials AWSCredentials, region, service string) *SigV4Signer {
+	return &SigV4Signer{Credentials: credentials, Region: region, Service: service}
+}
+
+// HTTPSignature signs the requests with HTTP Message Signatures (RFC 9421).
+// The signature covers the components listed (the method, authority, path,
+// query and body digest when none are), see `HTTPSigner` for the supported
+// keys.
+func HTTPSignature(keyID string, key crypto.PrivateKey, components ...string) *HTTPSigner {
+	return &HTTPSigner{KeyID: keyID, Key: key, Components: components}
+}
+
+// UseNetrc looks up the credentials of the host in a .netrc file (see
+// `NetrcCredentials`, an empty path is ~/.netrc) when the request has no other
+// authentication
+func UseNetrc(path string) Option {
+	return UseCredentialProvider(NetrcCredentials(path))
+}
+
+// UseCredentialProvider adds providers that are consulted for the
+// credentials of the host when the request has no other authentication
+func UseCredentialProvider(providers ...CredentialProvider) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.CredentialProviders = append(ro.CredentialProviders, providers...)
+	})
+}
+
+// UseAuthenticator sets the Authenticator used to add credentials to the request
+func UseAuthenticator(auth Authenticator) Option {
 	return optionFunc(func(ro *RequestOptions) {
-		ro.Auth = []string{username, password}
+		ro.Authenticator = auth
 	})
 }
 
@@ -115,12 +208,161 @@ func UseCookieJar() Option {
 
 // Proxies is a map in the following format
 // *protocol* => proxy address e.g http => http://127.0.0.1:8080
+// SOCKS5 proxies are supported with the socks5:// (the host is looked up by
+// us) and socks5h:// (the host is looked up by the proxy) schemes, the user
+// info of the proxy URL is used to authenticate
 func Proxies(proxies map[string]*url.URL) Option {
 	return optionFunc(func(ro *RequestOptions) {
 		ro.Proxies = proxies
 	})
 }
 
+// ClientCertificate presents the PEM encoded certificate and private key to
+// servers that ask for a client certificate (mutual TLS). The pair is parsed
+// when the request is sent, an invalid pair makes the request fail.
+func ClientCertificate(certPEM, keyPEM []byte) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.ClientCertificates = append(ro.ClientCertificates, TLSCertificate{CertPEM: certPEM, KeyPEM: keyPEM})
+	})
+}
+
+// ClientCertificateFiles is like `ClientCertificate` but reads the PEM
+// encoded certificate and private key from files
+func ClientCertificateFiles(certFile, keyFile string) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.ClientCertificates = append(ro.ClientCertificates, TLSCertificate{CertFile: certFile, KeyFile: keyFile})
+	})
+}
+
+// RootCAs sets the certificate authorities used to verify the server
+// certificate instead of the system ones
+func RootCAs(pool *x509.CertPool) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.RootCAs = pool
+	})
+}
+
+// RootCAFiles adds the certificate authorities of the PEM files to the pool
+// used to verify the server certificate (see `RootCAs`)
+func RootCAFiles(files ...string) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.RootCAFiles = append(ro.RootCAFiles, files...)
+	})
+}
+
+// TLSMinVersion sets the minimum TLS version we accept e.g. `tls.VersionTLS13`
+func TLSMinVersion(version uint16) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.TLSMinVersion = version
+	})
+}
+
+// TLSServerName sets the name used to verify the server certificate (and sent
+// with SNI) instead of the host of the URL
+func TLSServerName(name string) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.TLSServerName = name
+	})
+}
+
+// TLSConfig registers a function that can modify the TLS configuration once
+// every other setting has been applied
+func TLSConfig(fn func(*tls.Config)) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.TLSConfig = append(ro.TLSConfig, fn)
+	})
+}
+
+// PinnedPublicKeys rejects connections to the hosts unless their leaf or an
+// intermediate certificate has one of the public keys pinned. Pins are the
+// base64 encoded SHA-256 digests of the SubjectPublicKeyInfo (see
+// `PublicKeyPin`), optionally prefixed with "sha256/". Hosts are given
+// without a port. The check happens on top of the regular certificate
+// verification.
+func PinnedPublicKeys(pins map[string][]string) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.PinnedPublicKeys = mergeMaps(ro.PinnedPublicKeys, pins)
+	})
+}
+
+// PinReportOnly reports pin mismatches with the function instead of rejecting
+// the connection. When report is nil mismatches are written to the standard
+// logger
+func PinReportOnly(report func(err error)) Option {
+	if report == nil {
+		report = logPinMismatch
+	}
+	return optionFunc(func(ro *RequestOptions) {
+		ro.PinMismatchReporter = report
+	})
+}
+
+// ProxyConnectHeaders sends the headers to HTTP proxies with the CONNECT
+// requests that tunnel HTTPS requests e.g. a Proxy-Authorization header
+func ProxyConnectHeaders(headers map[string]string) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.ProxyConnectHeaders = mergeMaps(ro.ProxyConnectHeaders, headers)
+	})
+}
+
+// NoProxy connects directly to the hosts matching the patterns instead of
+// going through a proxy. A pattern is either "*", an IP address, a CIDR range
+// ("10.0.0.0/8") or a domain which also matches its sub domains
+// ("example.com" or ".example.com"). Patterns can be restricted to a port
+// ("example.com:8443").
+func NoProxy(patterns []string) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.NoProxy = append(ro.NoProxy, patterns...)
+	})
+}
+
+// ProxyAutoConfig chooses the proxies with a PAC file, pac is either its URL
+// (http://, https:// or file://) or the script itself. FindProxyForURL is
+// evaluated once per host (every 5 minutes) and the first proxy of its result
+// that can be reached is used e.g. "PROXY a:8080; SOCKS b:1080; DIRECT". The
+// file is downloaded without a proxy when it is first needed. Requests are
+// sent DIRECT (and the error is logged) when it can't be loaded or evaluated.
+func ProxyAutoConfig(pac string) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.ProxyAutoConfig = pac
+	})
+}
+
+// ForceHTTP2 uses HTTP/2 with the servers that support it. Go only does so
+// by itself with its default transport, which we don't use as soon as a
+// transport setting (a timeout, a proxy, TLS options...) is changed
+func ForceHTTP2() Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.ForceHTTP2 = true
+	})
+}
+
+// DisableHTTP2 only uses HTTP/1.1, even with the servers that support HTTP/2
+func DisableHTTP2() Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.DisableHTTP2 = true
+	})
+}
+
+// H2C sends the requests of http URLs with clear text HTTP/2 without trying
+// HTTP/1.1 first (prior knowledge), for the internal services that only
+// speak HTTP/2 e.g. gRPC gateways. These requests don't go through proxies
+func H2C() Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.H2C = true
+	})
+}
+
+// HTTP2HealthCheck pings HTTP/2 connections that haven't received a frame for
+// readIdleTimeout and closes them when the ping isn't answered within
+// pingTimeout (15 seconds when zero). HTTP/2 is used when it is set
+func HTTP2HealthCheck(readIdleTimeout, pingTimeout time.Duration) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.HTTP2ReadIdleTimeout = readIdleTimeout
+		ro.HTTP2PingTimeout = pingTimeout
+	})
+}
+
 // TLSHandshakeTimeout specifies the maximum amount of time waiting to
 // wait for a TLS handshake. Zero means no timeout.
 func TLSHandshakeTimeout(timeout time.Duration) Option {
@@ -214,9 +456,141 @@ func BeforeRequest(f func(req *http.Request) error) Option {
 	})
 }
 
+// ErrorOnStatus will make the request return an `*HTTPError` (which is also
+// set as the `Response.Error`) when the server doesn't respond with a 2xx status code
+func ErrorOnStatus() Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.ErrorOnStatus = true
+	})
+}
+
+// AfterResponse adds hooks that are run once the response has been received.
+// If a hook returns an error it is set as the `Response.Error` and returned
+// from the request. See `ExpectStatusClass`, `ExpectContentType` and
+// `ExpectJSONSchema` for ready made validators
+func AfterResponse(hooks ...func(resp *Response) error) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.AfterResponse = append(ro.AfterResponse, hooks...)
+	})
+}
+
+// MaxBodySize limits the number of bytes of the response body that will be
+// read. If the server announces (or sends) a larger body the request fails
+// with `ErrBodyTooLarge`
+func MaxBodySize(limit int64) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.MaxBodySize = limit
+	})
+}
+
+// UploadProgress reports how many bytes of the request body have been sent.
+// The total is -1 when the size of the body is unknown. Reports are throttled,
+// see `ProgressThrottle`
+func UploadProgress(fn func(sent, total int64)) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.UploadProgress = fn
+	})
+}
+
+// DownloadProgress reports how many bytes of the response body have been
+// read (e.g. by `DownloadToFile` or `Bytes`). The total is -1 when the server
+// didn't send a Content-Length. Reports are throttled, see `ProgressThrottle`
+func DownloadProgress(fn func(received, total int64)) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.DownloadProgress = fn
+	})
+}
+
+// ProgressThrottle sets how often the progress is reported: once the interval
+// has elapsed or once the number of bytes has been transferred since the last
+// report (a zero value disables that throttle). The final report is always made
+func ProgressThrottle(interval time.Duration, bytes int64) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.ProgressInterval = interval
+		ro.ProgressBytes = bytes
+	})
+}
+
+// ExpectSHA256 makes `Download` verify that the file matches the hex encoded
+// SHA-256 checksum provided
+func ExpectSHA256(checksum string) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.ExpectedSHA256 = checksum
+	})
+}
+
+// DownloadSegments makes `Download` fetch the file with n concurrent range
+// requests (when the server supports them)
+func DownloadSegments(n int) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.DownloadSegments = n
+	})
+}
+
+// UnixSocket connects to the Unix domain socket at path instead of the host of
+// the URL. A single request can also name its socket with an http+unix URL:
+// the path escaped socket is the host e.g.
+// http+unix://%2Fvar%2Frun%2Fdocker.sock/info
+func UnixSocket(path string) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.UnixSocket = path
+	})
+}
+
+// DialContext creates the connections with the function provided instead of
+// a `net.Dialer`, to connect through an SSH tunnel for instance
+func DialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.DialContext = dial
+	})
+}
+
+// ResolveOverride connects to other addresses than the ones the hosts resolve
+// to, like curl's --resolve and --connect-to. The keys are "host:port" (or a
+// host for every port) and the values an IP address (or a host) that keeps
+// the port or an "ip:port". The URL is left untouched so the Host header, SNI
+// and certificate verification still use the original host.
+func ResolveOverride(overrides map[string]string) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.ResolveOverrides = mergeMaps(ro.ResolveOverrides, overrides)
+	})
+}
+
+// Resolver looks up the hosts we connect to with the resolver provided, which
+// can query other DNS servers than the system ones
+func Resolver(resolver *net.Resolver) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.Resolver = resolver
+	})
+}
+
+// DNSCache keeps the addresses of the hosts that have been looked up for ttl.
+// The cache belongs to the client, use a `Session` to keep it between
+// requests with options that can't be pooled
+func DNSCache(ttl time.Duration) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.DNSCacheTTL = ttl
+	})
+}
+
 // LocalAddr allows you to send the request on any local interface
 func LocalAddr(addr *net.TCPAddr) Option {
 	return optionFunc(func(ro *RequestOptions) {
 		ro.LocalAddr = addr
 	})
 }
+
+// Retry enables retrying failed requests according to the provided policy
+func Retry(policy RetryPolicy) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.RetryPolicy = &policy
+	})
+}
+
+// Use installs middleware around the execution of the request. Middleware is
+// run in the order it is provided, the first one being the outermost
+func Use(middleware ...Middleware) Option {
+	return optionFunc(func(ro *RequestOptions) {
+		ro.Middleware = append(ro.Middleware, middleware...)
+	})
+}
@@ -0,0 +1,595 @@
+package grequests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+const (
+	// pacEvaluationTimeout bounds the time FindProxyForURL may run for
+	pacEvaluationTimeout = 5 * time.Second
+
+	// pacProxyRetry is how long we remember whether a proxy of a fallback
+	// list could be reached
+	pacProxyRetry = time.Minute
+
+	// pacResultTTL is how long the proxies FindProxyForURL chose for a host
+	// are used for, the answer may change (it can depend on the time or on
+	// DNS lookups)
+	pacResultTTL = 5 * time.Minute
+
+	// pacCacheSize is the largest number of hosts (and proxies) we keep
+	// results for
+	pacCacheSize = 1024
+
+	// pacMaxSize is the largest PAC file we download
+	pacMaxSize = 1 << 20
+)
+
+// pacUtils are the helper functions PAC files can use, the ones that need
+// the network (dnsResolve and myIpAddress) are implemented in Go
+const pacUtils = `
+function isPlainHostName(host) {
+	return host.indexOf(".") < 0;
+}
+
+function dnsDomainIs(host, domain) {
+	host = host.toLowerCase();
+	domain = domain.toLowerCase();
+	return host.length >= domain.length && host.substring(host.length - domain.length) === domain;
+}
+
+function localHostOrDomainIs(host, hostdom) {
+	host = host.toLowerCase();
+	hostdom = hostdom.toLowerCase();
+	return host === hostdom || (isPlainHostName(host) && hostdom.lastIndexOf(host + ".", 0) === 0);
+}
+
+function isResolvable(host) {
+	return dnsResolve(host) !== null;
+}
+
+function convert_addr(ipchars) {
+	var bytes = ipchars.split(".");
+	return ((bytes[0] & 0xff) << 24 | (bytes[1] & 0xff) << 16 | (bytes[2] & 0xff) << 8 | (bytes[3] & 0xff)) >>> 0;
+}
+
+function isInNet(ipaddr, pattern, maskstr) {
+	var ip = /^\d+\.\d+\.\d+\.\d+$/.test(ipaddr) ? ipaddr : dnsResolve(ipaddr);
+	if (ip === null) {
+		return false;
+	}
+	var mask = convert_addr(maskstr);
+	return ((convert_addr(ip) & mask) >>> 0) === ((convert_addr(pattern) & mask) >>> 0);
+}
+
+function dnsDomainLevels(host) {
+	return host.split(".").length - 1;
+}
+
+function shExpMatch(str, shexp) {
+	var pattern = shexp.replace(/[.+^${}()|[\]\\]/g, "\\$&").replace(/\*/g, ".*").replace(/\?/g, ".");
+	return new RegExp("^" + pattern + "$").test(str);
+}
+
+var pacDays = ["SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"];
+var pacMonths = ["JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"];
+
+// pacArguments returns the arguments of a range function without its
+// optional trailing "GMT" and the date fields of now in that time zone
+function pacArguments(args) {
+	args = Array.prototype.slice.call(args);
+	var gmt = args.length > 0 && args[args.length - 1] === "GMT";
+	if (gmt) {
+		args.pop();
+	}
+	var now = new Date();
+	return {
+		args: args,
+		now: {
+			year: gmt ? now.getUTCFullYear() : now.getFullYear(),
+			month: gmt ? now.getUTCMonth() : now.getMonth(),
+			day: gmt ? now.getUTCDate() : now.getDate(),
+			weekday: gmt ? now.getUTCDay() : now.getDay(),
+			hour: gmt ? now.getUTCHours() : now.getHours(),
+			minute: gmt ? now.getUTCMinutes() : now.getMinutes(),
+			second: gmt ? now.getUTCSeconds() : now.getSeconds()
+		}
+	};
+}
+
+// pacInRange reports if start <= value <= end, ranges wrap around when start
+// is after end (e.g. from FRI to MON)
+function pacInRange(value, start, end) {
+	return start <= end ? start <= value && value <= end : value >= start || value <= end;
+}
+
+function weekdayRange() {
+	var a = pacArguments(arguments);
+	var start = pacDays.indexOf(a.args[0]);
+	var end = a.args.length > 1 ? pacDays.indexOf(a.args[1]) : start;
+	return start >= 0 && end >= 0 && pacInRange(a.now.weekday, start, end);
+}
+
+function dateRange() {
+	var a = pacArguments(arguments);
+	var args = a.args;
+	if (args.length === 0 || (args.length > 1 && args.length % 2 !== 0)) {
+		return false;
+	}
+
+	// A number is a year when it's greater than 31 and a day otherwise
+	function date(values) {
+		var d = {};
+		values.forEach(function (v) {
+			var month = pacMonths.indexOf(v);
+			if (month >= 0) {
+				d.month = month;
+			} else if (Number(v) > 31) {
+				d.year = Number(v);
+			} else {
+				d.day = Number(v);
+			}
+		});
+		return d;
+	}
+
+	// Dates are compared on the fields of the range only
+	function encode(d, fields) {
+		var value = 0;
+		if ("year" in fields) value += d.year * 10000;
+		if ("month" in fields) value += d.month * 100;
+		if ("day" in fields) value += d.day;
+		return value;
+	}
+
+	var half = args.length === 1 ? 1 : args.length / 2;
+	var start = date(args.slice(0, half));
+	var end = args.length === 1 ? start : date(args.slice(half));
+	return pacInRange(encode(a.now, start), encode(start, start), encode(end, start));
+}
+
+function timeRange() {
+	var a = pacArguments(arguments);
+	var args = a.args.map(Number);
+	var now = a.now;
+	switch (args.length) {
+	case 1:
+		return now.hour === args[0];
+	case 2:
+		return pacInRange(now.hour, args[0], args[1]);
+	case 4:
+		return pacInRange(now.hour * 60 + now.minute, args[0] * 60 + args[1], args[2] * 60 + args[3]);
+	case 6:
+		return pacInRange(now.hour * 3600 + now.minute * 60 + now.second,
+			args[0] * 3600 + args[1] * 60 + args[2], args[3] * 3600 + args[4] * 60 + args[5]);
+	}
+	return false;
+}
+`
+
+// isPACURL reports if the proxy auto-config is the URL of a PAC file rather
+// than the script itself
+func isPACURL(pac string) bool {
+	lower := strings.ToLower(strings.TrimSpace(pac))
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "file://")
+}
+
+// proxyAutoConfig chooses the proxies of a transport with a PAC file. The
+// script is loaded on first use (and again after a failure) and evaluated
+// once per host every pacResultTTL. Requests are sent DIRECT when the script
+// can't be loaded or evaluated.
+type proxyAutoConfig struct {
+	ro     RequestOptions
+	client *http.Client
+	dial   func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// loadMu guards the script and its loading, which is done by a single
+	// request at a time (see load)
+	loadMu  sync.Mutex
+	script  *pacScript
+	loading *pacLoad
+
+	// mu guards the interpreter of the script, which isn't safe for
+	// concurrent use
+	mu sync.Mutex
+
+	cacheMu sync.Mutex
+	now     func() time.Time
+	results map[string]pacResult
+	proxies map[string]proxyStatus
+	err     error
+}
+
+// pacScript is a loaded PAC file, ctx is the context of the request being
+// evaluated
+type pacScript struct {
+	vm        *goja.Runtime
+	findProxy goja.Callable
+	ctx       context.Context
+}
+
+// pacLoad is the loading of the script in progress, done is closed once it
+// is over
+type pacLoad struct {
+	done   chan struct{}
+	script *pacScript
+	err    error
+}
+
+type pacResult struct {
+	candidates []*url.URL
+	evaluated  time.Time
+}
+
+type proxyStatus struct {
+	reachable bool
+	checked   time.Time
+}
+
+// newProxyAutoConfig returns the PAC file handler of the transport, PAC files
+// are downloaded with a copy of the transport that doesn't use any proxy
+func newProxyAutoConfig(ro RequestOptions, transport *http.Transport) *proxyAutoConfig {
+	direct := transport.Clone()
+	direct.Proxy = nil
+
+	return &proxyAutoConfig{
+		ro:      ro,
+		client:  &http.Client{Transport: direct},
+		dial:    ro.dialContext(),
+		now:     time.Now,
+		results: make(map[string]pacResult),
+		proxies: make(map[string]proxyStatus),
+	}
+}
+
+// proxy is the `Proxy` function of the transport. The `Proxies` of the
+// protocol and `NoProxy` take precedence over the PAC file, which itself
+// takes precedence over the environment
+func (p *proxyAutoConfig) proxy(req *http.Request) (*url.URL, error) {
+	if _, ok := p.ro.Proxies[req.URL.Scheme]; ok || p.ro.UnixSocket != "" || matchNoProxy(p.ro.NoProxy, req.URL) {
+		return p.ro.proxySettings(req)
+	}
+
+	candidates, err := p.find(req)
+	if err != nil {
+		p.fail(err)
+		return routeSOCKS(req, nil), nil
+	}
+
+	// The first proxy that can be reached is used, the last one is used
+	// regardless and DIRECT (nil) always works
+	for i, candidate := range candidates {
+		if candidate == nil || i == len(candidates)-1 || p.reachable(req.Context(), candidate) {
+			return routeSOCKS(req, candidate), nil
+		}
+	}
+	return nil, nil
+}
+
+// find returns the proxies FindProxyForURL lists for the host of the request
+func (p *proxyAutoConfig) find(req *http.Request) ([]*url.URL, error) {
+	key := req.URL.Scheme + "://" + strings.ToLower(req.URL.Host)
+
+	p.cacheMu.Lock()
+	cached, ok := p.results[key]
+	p.cacheMu.Unlock()
+	if ok && p.now().Sub(cached.evaluated) < pacResultTTL {
+		return cached.candidates, nil
+	}
+
+	script, err := p.load(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	script.ctx = req.Context()
+	defer func() { script.ctx = nil }()
+
+	target := *req.URL
+	target.User = nil
+	target.Fragment = ""
+
+	timer := time.AfterFunc(pacEvaluationTimeout, func() {
+		script.vm.Interrupt("FindProxyForURL timed out")
+	})
+	result, err := script.findProxy(goja.Undefined(), script.vm.ToValue(target.String()), script.vm.ToValue(req.URL.Hostname()))
+	timer.Stop()
+	script.vm.ClearInterrupt()
+
+	if err != nil {
+		return nil, fmt.Errorf("grequests: unable to evaluate the proxy auto-config: %w", err)
+	}
+
+	candidates := parsePACResult(result.String())
+
+	p.cacheMu.Lock()
+	now := p.now()
+	pruneCache(p.results, func(r pacResult) bool { return now.Sub(r.evaluated) >= pacResultTTL })
+	p.results[key] = pacResult{candidates: candidates, evaluated: now}
+	p.cacheMu.Unlock()
+
+	return candidates, nil
+}
+
+// fail records the error that made us send a request DIRECT
+func (p *proxyAutoConfig) fail(err error) {
+	p.cacheMu.Lock()
+	p.err = err
+	p.cacheMu.Unlock()
+
+	log.Printf("%v (sending the request without a proxy)", err)
+}
+
+// lastError returns the last error that made us send a request DIRECT
+func (p *proxyAutoConfig) lastError() error {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	return p.err
+}
+
+// load returns the script, fetching (when needed) and running the PAC file
+// the first time. The file is loaded by a single request without holding mu,
+// the requests that need it in the meantime wait for the result
+func (p *proxyAutoConfig) load(ctx context.Context) (*pacScript, error) {
+	p.loadMu.Lock()
+	if p.script != nil {
+		p.loadMu.Unlock()
+		return p.script, nil
+	}
+
+	call := p.loading
+	if call != nil {
+		p.loadMu.Unlock()
+
+		select {
+		case <-call.done:
+			return call.script, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	call = &pacLoad{done: make(chan struct{})}
+	p.loading = call
+	p.loadMu.Unlock()
+
+	call.script, call.err = p.compile(ctx)
+
+	p.loadMu.Lock()
+	p.script, p.loading = call.script, nil
+	p.loadMu.Unlock()
+	close(call.done)
+
+	return call.script, call.err
+}
+
+// compile fetches (when needed) and runs the PAC file
+func (p *proxyAutoConfig) compile(ctx context.Context) (*pacScript, error) {
+	source := p.ro.ProxyAutoConfig
+	if isPACURL(source) {
+		var err error
+		if source, err = p.fetch(ctx, strings.TrimSpace(source)); err != nil {
+			return nil, fmt.Errorf("grequests: unable to load the proxy auto-config %s: %w", p.ro.ProxyAutoConfig, err)
+		}
+	}
+
+	vm := goja.New()
+	script := &pacScript{vm: vm, ctx: ctx}
+	dnsResolve := func(host string) interface{} {
+		return p.dnsResolve(script.ctx, host)
+	}
+	if err := vm.Set("dnsResolve", dnsResolve); err != nil {
+		return nil, err
+	}
+	if err := vm.Set("myIpAddress", myIPAddress); err != nil {
+		return nil, err
+	}
+	if _, err := vm.RunString(pacUtils); err != nil {
+		return nil, err
+	}
+
+	timer := time.AfterFunc(pacEvaluationTimeout, func() {
+		vm.Interrupt("the proxy auto-config timed out")
+	})
+	_, err := vm.RunString(source)
+	timer.Stop()
+	if err != nil {
+		return nil, fmt.Errorf("grequests: invalid proxy auto-config: %w", err)
+	}
+
+	findProxy, ok := goja.AssertFunction(vm.Get("FindProxyForURL"))
+	if !ok {
+		return nil, fmt.Errorf("grequests: the proxy auto-config doesn't define FindProxyForURL")
+	}
+
+	script.findProxy, script.ctx = findProxy, nil
+	return script, nil
+}
+
+// fetch returns the PAC file at pacURL
+func (p *proxyAutoConfig) fetch(ctx context.Context, pacURL string) (string, error) {
+	u, err := url.Parse(pacURL)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.EqualFold(u.Scheme, "file") {
+		script, err := os.ReadFile(u.Path)
+		return string(script), err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pacURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	script, err := io.ReadAll(io.LimitReader(resp.Body, pacMaxSize))
+	return string(script), err
+}
+
+// dnsResolve returns the IP address of the host (an IPv4 one when there is
+// one) or nil (null), `ResolveOverrides` and the `Resolver` are used
+func (p *proxyAutoConfig) dnsResolve(ctx context.Context, host string) interface{} {
+	if target, _, err := net.SplitHostPort(resolveOverride(normalizeOverrides(p.ro.ResolveOverrides), net.JoinHostPort(host, "0"))); err == nil {
+		host = target
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+
+	resolver := p.ro.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pacEvaluationTimeout)
+	defer cancel()
+
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return nil
+	}
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return addr.IP.String()
+		}
+	}
+	return addrs[0].IP.String()
+}
+
+// myIPAddress returns the first IPv4 address of the machine that isn't a
+// loopback address
+func myIPAddress() string {
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, addr := range addrs {
+			if network, ok := addr.(*net.IPNet); ok && !network.IP.IsLoopback() && network.IP.To4() != nil {
+				return network.IP.String()
+			}
+		}
+	}
+	return "127.0.0.1"
+}
+
+// reachable reports if a connection can be made to the proxy, the answer is
+// remembered for pacProxyRetry
+func (p *proxyAutoConfig) reachable(ctx context.Context, proxyURL *url.URL) bool {
+	addr := proxyURL.Host
+
+	p.cacheMu.Lock()
+	status, ok := p.proxies[addr]
+	p.cacheMu.Unlock()
+	if ok && p.now().Sub(status.checked) < pacProxyRetry {
+		return status.reachable
+	}
+
+	timeout := p.ro.DialTimeout
+	if timeout == 0 {
+		timeout = pacEvaluationTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	conn, err := p.dial(ctx, "tcp", addr)
+	if err == nil {
+		_ = conn.Close()
+	}
+
+	p.cacheMu.Lock()
+	now := p.now()
+	pruneCache(p.proxies, func(s proxyStatus) bool { return now.Sub(s.checked) >= pacProxyRetry })
+	p.proxies[addr] = proxyStatus{reachable: err == nil, checked: now}
+	p.cacheMu.Unlock()
+
+	return err == nil
+}
+
+// pruneCache makes room for a new entry in a cache of the PAC file: expired
+// entries are dropped and, when it is still full, arbitrary ones
+func pruneCache[V any](cache map[string]V, expired func(V) bool) {
+	if len(cache) < pacCacheSize {
+		return
+	}
+
+	for key, value := range cache {
+		if expired(value) {
+			delete(cache, key)
+		}
+	}
+	for key := range cache {
+		if len(cache) < pacCacheSize {
+			break
+		}
+		delete(cache, key)
+	}
+}
+
+// parsePACResult returns the proxies of a FindProxyForURL result e.g.
+// "PROXY a:8080; SOCKS b:1080; DIRECT", DIRECT is returned as nil. SOCKS
+// proxies look the hosts up themselves (like browsers do), entries we don't
+// support are ignored and an empty result means DIRECT
+func parsePACResult(result string) []*url.URL {
+	var candidates []*url.URL
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if strings.EqualFold(fields[0], "DIRECT") {
+			candidates = append(candidates, nil)
+			continue
+		}
+		if len(fields) != 2 {
+			continue
+		}
+
+		var scheme, port string
+		switch strings.ToUpper(fields[0]) {
+		case "PROXY", "HTTP":
+			scheme, port = "http", "80"
+		case "HTTPS":
+			scheme, port = "https", "443"
+		case "SOCKS", "SOCKS5":
+			scheme, port = "socks5h", "1080"
+		default:
+			continue
+		}
+
+		host := fields[1]
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), port)
+		}
+		candidates = append(candidates, &url.URL{Scheme: scheme, Host: host})
+	}
+
+	if len(candidates) == 0 {
+		return []*url.URL{nil}
+	}
+	return candidates
+}
@@ -0,0 +1,336 @@
+package grequests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type PACSuite struct {
+	suite.Suite
+
+	backend *httptest.Server
+	proxy   *httptest.Server
+}
+
+func (s *PACSuite) SetupSuite() {
+	s.backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("direct " + r.Host))
+	}))
+
+	// An HTTP proxy that answers every request itself
+	s.proxy = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("proxied " + r.URL.Host))
+	}))
+}
+
+func (s *PACSuite) TearDownSuite() {
+	s.backend.Close()
+	s.proxy.Close()
+}
+
+func (s *PACSuite) SetupTest() {
+	ResetClientPool()
+}
+
+// evaluate returns the proxies the PAC script chooses for rawURL
+func (s *PACSuite) evaluate(ro RequestOptions, script, rawURL string) ([]*url.URL, error) {
+	ro.ProxyAutoConfig = script
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	s.Require().NoError(err)
+	return newProxyAutoConfig(ro, &http.Transport{}).find(req)
+}
+
+func (s *PACSuite) TestHelpers() {
+	ro := RequestOptions{ResolveOverrides: map[string]string{"app.grequests.test": "10.2.3.4"}}
+
+	tests := map[string]bool{
+		`isPlainHostName("www")`:                                       true,
+		`isPlainHostName("www.example.com")`:                           false,
+		`dnsDomainIs("www.Example.com", ".example.com")`:               true,
+		`dnsDomainIs("www.other.com", ".example.com")`:                 false,
+		`localHostOrDomainIs("www", "www.example.com")`:                true,
+		`localHostOrDomainIs("www.example.com", "www.example.com")`:    true,
+		`localHostOrDomainIs("www.other.com", "www.example.com")`:      false,
+		`isInNet("10.1.2.3", "10.0.0.0", "255.0.0.0")`:                 true,
+		`isInNet("11.1.2.3", "10.0.0.0", "255.0.0.0")`:                 false,
+		`isInNet("192.168.1.20", "192.168.1.0", "255.255.255.0")`:      true,
+		`isInNet("app.grequests.test", "10.0.0.0", "255.0.0.0")`:       true,
+		`dnsResolve("app.grequests.test") === "10.2.3.4"`:              true,
+		`isResolvable("127.0.0.1")`:                                    true,
+		`dnsDomainLevels("www.example.com") === 2`:                     true,
+		`shExpMatch("http://a.example.com/x", "*.example.com/*")`:      true,
+		`shExpMatch("a.b", "a?b")`:                                     true,
+		`shExpMatch("axxb", "a?b")`:                                    false,
+		`shExpMatch("a+b.com", "a+b.com")`:                             true,
+		`weekdayRange("SUN", "SAT")`:                                   true,
+		`weekdayRange("SUN", "SAT", "GMT")`:                            true,
+		`dateRange("JAN", "DEC")`:                                      true,
+		`dateRange(1, 31, "GMT")`:                                      true,
+		`dateRange(1995, 2500)`:                                        true,
+		`dateRange(1990)`:                                              false,
+		`dateRange(1, "JAN", 1990, 31, "DEC", 1999)`:                   false,
+		`timeRange(0, 23)`:                                             true,
+		`timeRange(0, 0, 0, 23, 59, 59, "GMT")`:                        true,
+		`/^\d+\.\d+\.\d+\.\d+$/.test(myIpAddress())`:                   true,
+		`convert_addr("1.2.3.4") === 16909060`:                         true,
+		`isInNet("255.255.255.255", "255.255.255.0", "255.255.255.0")`: true,
+	}
+	for expression, expected := range tests {
+		script := fmt.Sprintf(`function FindProxyForURL(url, host) { return (%s) ? "PROXY yes:3128" : "DIRECT"; }`, expression)
+		candidates, err := s.evaluate(ro, script, "http://example.com/")
+		s.Require().NoError(err, expression)
+		s.Equal(expected, candidates[0] != nil, expression)
+	}
+}
+
+func (s *PACSuite) TestResults() {
+	tests := map[string][]string{
+		"DIRECT":                             {""},
+		"":                                   {""},
+		"PROXY a:8080":                       {"http://a:8080"},
+		"PROXY a; SOCKS b; HTTPS c; DIRECT":  {"http://a:80", "socks5h://b:1080", "https://c:443", ""},
+		"proxy [::1]:3128;;SOCKS4 d:1080;":   {"http://[::1]:3128"},
+		"HTTP a:1 ; SOCKS5 b:2 ; unknown c;": {"http://a:1", "socks5h://b:2"},
+		"PROXY":                              {""},
+	}
+	for result, expected := range tests {
+		var proxies []string
+		for _, candidate := range parsePACResult(result) {
+			if candidate == nil {
+				proxies = append(proxies, "")
+			} else {
+				proxies = append(proxies, candidate.String())
+			}
+		}
+		s.Equal(expected, proxies, result)
+	}
+}
+
+func (s *PACSuite) TestCache() {
+	script := `
+var calls = 0;
+function FindProxyForURL(url, host) {
+	calls++;
+	return "PROXY " + host + ":" + calls;
+}`
+	pac := newProxyAutoConfig(RequestOptions{ProxyAutoConfig: script}, &http.Transport{})
+
+	for _, rawURL := range []string{"http://a.example.com/1", "http://A.example.com/2?q", "http://b.example.com/", "http://a.example.com/3"} {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		s.Require().NoError(err)
+		_, err = pac.find(req)
+		s.Require().NoError(err)
+	}
+	s.Equal(int64(2), pac.script.vm.Get("calls").ToInteger())
+
+	req, _ := http.NewRequest(http.MethodGet, "http://a.example.com/", nil)
+	candidates, err := pac.find(req)
+	s.Require().NoError(err)
+	s.Equal("a.example.com:1", candidates[0].Host)
+
+	// Results expire
+	now := time.Now()
+	pac.now = func() time.Time { return now.Add(pacResultTTL) }
+	candidates, err = pac.find(req)
+	s.Require().NoError(err)
+	s.Equal("a.example.com:3", candidates[0].Host)
+
+	// And the cache doesn't grow past its size
+	for i := 0; i < pacCacheSize+10; i++ {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%d.example.com/", i), nil)
+		_, err := pac.find(req)
+		s.Require().NoError(err)
+	}
+	s.LessOrEqual(len(pac.results), pacCacheSize)
+}
+
+func (s *PACSuite) TestProxyAutoConfig() {
+	script := fmt.Sprintf(`
+function FindProxyForURL(url, host) {
+	if (dnsDomainIs(host, ".corp.test") && shExpMatch(url, "http:*")) {
+		return "PROXY %s";
+	}
+	return "DIRECT";
+}`, s.proxy.Listener.Addr().String())
+
+	resp, err := Get(context.Background(), "http://app.corp.test/", ProxyAutoConfig(script))
+	s.Require().NoError(err)
+	s.Equal("proxied app.corp.test", resp.String())
+
+	resp, err = Get(context.Background(), s.backend.URL, ProxyAutoConfig(script))
+	s.Require().NoError(err)
+	s.Equal("direct "+s.backend.Listener.Addr().String(), resp.String())
+
+	// The proxies of the protocol and NoProxy take precedence
+	backendURL, _ := url.Parse(s.backend.URL)
+	resp, err = Get(context.Background(), "http://app.corp.test/", ProxyAutoConfig(script),
+		Proxies(map[string]*url.URL{"http": backendURL}))
+	s.Require().NoError(err)
+	s.Equal("direct app.corp.test", resp.String())
+
+	_, err = Get(context.Background(), "http://app.corp.test/", ProxyAutoConfig(script), NoProxy([]string{"corp.test"}),
+		ResolveOverride(map[string]string{"app.corp.test": "127.0.0.1:1"}))
+	s.ErrorIs(err, ErrConnRefused)
+
+	// Sessions keep their PAC file
+	session := NewSession(ProxyAutoConfig(script))
+	defer session.CloseIdleConnections()
+
+	resp, err = session.Get(context.Background(), "http://api.corp.test/")
+	s.Require().NoError(err)
+	s.Equal("proxied api.corp.test", resp.String())
+
+	// A PAC file given with a request of the session applies to it
+	session = NewSession()
+	defer session.CloseIdleConnections()
+
+	resp, err = session.Get(context.Background(), "http://www.corp.test/", ProxyAutoConfig(script))
+	s.Require().NoError(err)
+	s.Equal("proxied www.corp.test", resp.String())
+}
+
+func (s *PACSuite) TestFallback() {
+	socks := newSOCKSServer(s.T(), s.backend.Listener.Addr().String(), "", "")
+
+	script := fmt.Sprintf(`function FindProxyForURL(url, host) { return "PROXY 127.0.0.1:1; SOCKS %s; DIRECT"; }`, socks.listener.Addr())
+	resp, err := Get(context.Background(), "http://app.grequests.test/", ProxyAutoConfig(script))
+	s.Require().NoError(err)
+	s.Equal("direct app.grequests.test", resp.String())
+	s.Len(socks.recorded(), 1)
+
+	resp, err = Get(context.Background(), s.backend.URL,
+		ProxyAutoConfig(`function FindProxyForURL(url, host) { return "PROXY 127.0.0.1:1; DIRECT"; }`))
+	s.Require().NoError(err)
+	s.Equal("direct "+s.backend.Listener.Addr().String(), resp.String())
+
+	// The last proxy is used when none can be reached
+	_, err = Get(context.Background(), s.backend.URL,
+		ProxyAutoConfig(`function FindProxyForURL(url, host) { return "PROXY 127.0.0.1:1; PROXY 127.0.0.1:1"; }`))
+	s.ErrorIs(err, ErrConnRefused)
+}
+
+func (s *PACSuite) TestPACURL() {
+	script := fmt.Sprintf(`function FindProxyForURL(url, host) { return "PROXY %s"; }`, s.proxy.Listener.Addr().String())
+
+	var fetches int32
+	pacServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/proxy.pac" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&fetches, 1)
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		_, _ = w.Write([]byte(script))
+	}))
+	defer pacServer.Close()
+
+	// The PAC file itself is downloaded directly
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		resp, err := Get(context.Background(), "http://"+host+"/", ProxyAutoConfig(pacServer.URL+"/proxy.pac"))
+		s.Require().NoError(err)
+		s.Equal("proxied "+host, resp.String())
+	}
+	s.Equal(int32(1), atomic.LoadInt32(&fetches))
+
+	// Requests are sent DIRECT when the PAC file can't be loaded
+	resp, err := Get(context.Background(), s.backend.URL, ProxyAutoConfig(pacServer.URL+"/missing.pac"))
+	s.Require().NoError(err)
+	s.Equal("direct "+s.backend.Listener.Addr().String(), resp.String())
+
+	pac := newProxyAutoConfig(RequestOptions{ProxyAutoConfig: pacServer.URL + "/missing.pac"}, &http.Transport{})
+	req, err := http.NewRequest(http.MethodGet, "http://a.example.com/", nil)
+	s.Require().NoError(err)
+	proxyURL, err := pac.proxy(req)
+	s.Require().NoError(err)
+	s.Nil(proxyURL)
+	s.ErrorContains(pac.lastError(), "unable to load the proxy auto-config")
+	s.ErrorContains(pac.lastError(), "404")
+
+	path := filepath.Join(s.T().TempDir(), "proxy.pac")
+	s.Require().NoError(os.WriteFile(path, []byte(script), 0o600))
+
+	resp, err = Get(context.Background(), "http://c.example.com/", ProxyAutoConfig("file://"+path))
+	s.Require().NoError(err)
+	s.Equal("proxied c.example.com", resp.String())
+}
+
+func (s *PACSuite) TestInvalidScripts() {
+	tests := map[string]string{
+		`function FindProxyForURL(url, host) {`: "invalid proxy auto-config",
+		`var x = 1;`:                            "doesn't define FindProxyForURL",
+		`function FindProxyForURL(url, host) { throw "unexpected"; }`: "unable to evaluate the proxy auto-config",
+	}
+	for script, message := range tests {
+		pac := newProxyAutoConfig(RequestOptions{ProxyAutoConfig: script}, &http.Transport{})
+		req, err := http.NewRequest(http.MethodGet, s.backend.URL, nil)
+		s.Require().NoError(err)
+		proxyURL, err := pac.proxy(req)
+		s.Require().NoError(err, script)
+		s.Nil(proxyURL, script)
+		s.ErrorContains(pac.lastError(), message, script)
+
+		// The request is sent DIRECT
+		resp, err := Get(context.Background(), s.backend.URL, ProxyAutoConfig(script))
+		s.Require().NoError(err, script)
+		s.Equal("direct "+s.backend.Listener.Addr().String(), resp.String())
+	}
+}
+
+func (s *PACSuite) TestConcurrentLoad() {
+	script := `function FindProxyForURL(url, host) { return "PROXY proxy.example.com:8080"; }`
+
+	var fetches int32
+	release := make(chan struct{})
+	pacServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		_, _ = w.Write([]byte(script))
+	}))
+	defer pacServer.Close()
+
+	pac := newProxyAutoConfig(RequestOptions{ProxyAutoConfig: pacServer.URL}, &http.Transport{})
+	find := func(ctx context.Context) ([]*url.URL, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://a.example.com/", nil)
+		s.Require().NoError(err)
+		return pac.find(req)
+	}
+
+	results := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			candidates, err := find(context.Background())
+			if err == nil && candidates[0].Host != "proxy.example.com:8080" {
+				err = fmt.Errorf("unexpected proxy %s", candidates[0])
+			}
+			results <- err
+		}()
+	}
+	s.Eventually(func() bool { return atomic.LoadInt32(&fetches) == 1 }, time.Second, time.Millisecond)
+
+	// The file is fetched once and waiting for it doesn't hold up requests
+	// that give up
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := find(ctx)
+	s.ErrorIs(err, context.DeadlineExceeded)
+
+	close(release)
+	for i := 0; i < 5; i++ {
+		s.NoError(<-results)
+	}
+	s.Equal(int32(1), atomic.LoadInt32(&fetches))
+}
+
+func TestPACSuite(t *testing.T) {
+	suite.Run(t, new(PACSuite))
+}
@@ -0,0 +1,176 @@
+package grequests
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ErrPinMismatch is matched (using `errors.Is`) by errors caused by a server
+// that presented none of the public keys pinned for its host. These errors
+// are also classified as `KindTLSVerify`
+var ErrPinMismatch = errors.New("grequests: no certificate matches the pinned public keys")
+
+// PublicKeyPin returns the pin of the certificate: the base64 encoded SHA-256
+// digest of its SubjectPublicKeyInfo, the format used by HPKP and `curl
+// --pinnedpubkey`
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// parsePins returns the pins by host name. Pins may be prefixed with
+// "sha256/", host names are case insensitive and IPv6 addresses may be
+// enclosed in brackets. Hosts with a port are rejected as pins apply to every
+// port of the host
+func parsePins(pins map[string][]string) (map[string]map[string]struct{}, error) {
+	parsed := make(map[string]map[string]struct{}, len(pins))
+	for host, hostPins := range pins {
+		if _, _, err := net.SplitHostPort(host); err == nil {
+			return nil, fmt.Errorf("grequests: public key pins are set by host name, %q has a port", host)
+		}
+
+		set := make(map[string]struct{}, len(hostPins))
+		for _, pin := range hostPins {
+			pin = strings.TrimPrefix(pin, "sha256/")
+			if digest, err := base64.StdEncoding.DecodeString(pin); err != nil || len(digest) != sha256.Size {
+				return nil, fmt.Errorf("grequests: invalid public key pin %q for %s", pin, host)
+			}
+			set[pin] = struct{}{}
+		}
+		parsed[strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))] = set
+	}
+	return parsed, nil
+}
+
+// pinConnection makes the TLS configuration check the public keys of the
+// servers that have pins. The check runs once the chain has been verified
+// (unless `InsecureSkipVerify` is set) and after any `VerifyConnection`
+// installed by a `TLSConfig` function.
+func pinConnection(config *tls.Config, ro RequestOptions) error {
+	if len(ro.PinnedPublicKeys) == 0 {
+		return nil
+	}
+
+	pins, err := parsePins(ro.PinnedPublicKeys)
+	if err != nil {
+		return err
+	}
+
+	verify := config.VerifyConnection
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(state); err != nil {
+				return err
+			}
+		}
+
+		err := checkPins(state, pins)
+		if err == nil || ro.PinMismatchReporter == nil {
+			return err
+		}
+
+		ro.PinMismatchReporter(err)
+		return nil
+	}
+	return nil
+}
+
+// checkPins verifies that the leaf or an intermediate certificate of the
+// connection matches one of the pins of the host
+func checkPins(state tls.ConnectionState, pins map[string]map[string]struct{}) error {
+	hosts := pinnedHosts(state, pins)
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	// The root of a verified chain is a trust anchor we picked, not something
+	// the server presented
+	certs := state.PeerCertificates
+	if len(state.VerifiedChains) != 0 {
+		certs = nil
+		for _, chain := range state.VerifiedChains {
+			if len(chain) > 1 {
+				chain = chain[:len(chain)-1]
+			}
+			certs = append(certs, chain...)
+		}
+	}
+
+	presented := make([]string, 0, len(certs))
+	for _, cert := range certs {
+		presented = append(presented, PublicKeyPin(cert))
+	}
+
+	for _, host := range hosts {
+		if !matchesPin(presented, pins[host]) {
+			return fmt.Errorf("%w: %s presented %s", ErrPinMismatch, host, strings.Join(presented, ", "))
+		}
+	}
+	return nil
+}
+
+// pinnedHosts returns the hosts with pins the connection may be made to. The
+// server name isn't known when connecting to an IP address: the verified
+// certificate tells which of the pinned addresses it is valid for, without
+// verification every pinned address applies.
+func pinnedHosts(state tls.ConnectionState, pins map[string]map[string]struct{}) []string {
+	if state.ServerName != "" {
+		if _, ok := pins[strings.ToLower(state.ServerName)]; ok {
+			return []string{strings.ToLower(state.ServerName)}
+		}
+		return nil
+	}
+
+	var hosts []string
+	for host := range pins {
+		ip := net.ParseIP(host)
+		if ip == nil {
+			continue
+		}
+		if len(state.VerifiedChains) == 0 || state.VerifiedChains[0][0].VerifyHostname(host) == nil {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+func matchesPin(presented []string, pins map[string]struct{}) bool {
+	for _, pin := range presented {
+		if _, ok := pins[pin]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pinsClientKey returns the pins of a `clientKey`
+func pinsClientKey(ro RequestOptions) string {
+	pins := make([]string, 0, len(ro.PinnedPublicKeys))
+	for host, hostPins := range ro.PinnedPublicKeys {
+		pins = append(pins, host+"="+strings.Join(hostPins, ","))
+	}
+	sort.Strings(pins)
+	return strings.Join(pins, ";")
+}
+
+// logPinMismatch is the default reporter of `PinReportOnly`
+func logPinMismatch(err error) {
+	log.Printf("%v (report only)", err)
+}
+
+func firstPinMismatchReporter(values ...func(err error)) func(err error) {
+	for _, v := range values {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package grequests
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type PinSuite struct {
+	suite.Suite
+
+	pki *testPKI
+	srv *httptest.Server
+}
+
+func (s *PinSuite) SetupSuite() {
+	s.pki = newTestPKI(s.T())
+	s.srv = s.pki.tlsServer(func(config *tls.Config) { config.ClientAuth = tls.NoClientCert })
+}
+
+func (s *PinSuite) TearDownSuite() {
+	s.srv.Close()
+}
+
+func (s *PinSuite) SetupTest() {
+	ResetClientPool()
+}
+
+// get requests the test server as server.grequests.test
+func (s *PinSuite) get(options ...Option) (*Response, error) {
+	options = append([]Option{RootCAs(s.pki.caPool()), TLSServerName("server.grequests.test")}, options...)
+	return Get(context.Background(), s.srv.URL, options...)
+}
+
+func (s *PinSuite) TestPins() {
+	tests := map[string]string{
+		"leaf":         PublicKeyPin(s.pki.server.cert),
+		"intermediate": "sha256/" + PublicKeyPin(s.pki.intermediate.cert),
+	}
+	for name, pin := range tests {
+		resp, err := s.get(PinnedPublicKeys(map[string][]string{"Server.grequests.test": {PublicKeyPin(s.pki.client.cert), pin}}))
+		s.Require().NoError(err, name)
+		s.True(resp.Ok, name)
+	}
+
+	// The root isn't presented by the server
+	_, err := s.get(PinnedPublicKeys(map[string][]string{"server.grequests.test": {PublicKeyPin(s.pki.ca.cert)}}))
+	s.ErrorIs(err, ErrPinMismatch)
+	s.ErrorIs(err, ErrTLSVerify)
+	s.Contains(err.Error(), PublicKeyPin(s.pki.server.cert))
+
+	// Other hosts aren't pinned
+	resp, err := s.get(PinnedPublicKeys(map[string][]string{"other.grequests.test": {PublicKeyPin(s.pki.ca.cert)}}))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+}
+
+func (s *PinSuite) TestChainValidation() {
+	pins := PinnedPublicKeys(map[string][]string{"server.grequests.test": {PublicKeyPin(s.pki.server.cert)}})
+
+	// A matching pin doesn't replace the verification of the chain
+	_, err := Get(context.Background(), s.srv.URL, pins, TLSServerName("server.grequests.test"))
+	s.ErrorIs(err, ErrTLSVerify)
+	s.NotErrorIs(err, ErrPinMismatch)
+
+	// Without the verification the pins are still checked
+	resp, err := Get(context.Background(), s.srv.URL, pins, TLSServerName("server.grequests.test"), DisableTLSCertValidation())
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+
+	_, err = Get(context.Background(), s.srv.URL, TLSServerName("server.grequests.test"), DisableTLSCertValidation(),
+		PinnedPublicKeys(map[string][]string{"server.grequests.test": {PublicKeyPin(s.pki.ca.cert)}}))
+	s.ErrorIs(err, ErrPinMismatch)
+
+	// IP addresses have no server name, unverified connections are checked
+	// against the pins of every address
+	ipPins := map[string][]string{"127.0.0.1": {PublicKeyPin(s.pki.server.cert)}, "[::1]": {PublicKeyPin(s.pki.server.cert)}}
+	resp, err = Get(context.Background(), s.srv.URL, DisableTLSCertValidation(), PinnedPublicKeys(ipPins))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+
+	ipPins["[::1]"] = []string{PublicKeyPin(s.pki.ca.cert)}
+	_, err = Get(context.Background(), s.srv.URL, DisableTLSCertValidation(), PinnedPublicKeys(ipPins))
+	s.ErrorIs(err, ErrPinMismatch)
+}
+
+func (s *PinSuite) TestReportOnly() {
+	var (
+		mu       sync.Mutex
+		reported []error
+	)
+	report := PinReportOnly(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, err)
+	})
+
+	pins := PinnedPublicKeys(map[string][]string{"server.grequests.test": {PublicKeyPin(s.pki.ca.cert)}})
+	resp, err := s.get(pins, report)
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	s.Require().Len(reported, 1)
+	s.ErrorIs(reported[0], ErrPinMismatch)
+
+	// Failures of the regular verification are never report only
+	_, err = Get(context.Background(), s.srv.URL, pins, report)
+	s.ErrorIs(err, ErrTLSVerify)
+	s.Len(reported, 1)
+}
+
+func (s *PinSuite) TestTLSConfigAndErrors() {
+	var verified bool
+	resp, err := s.get(
+		PinnedPublicKeys(map[string][]string{"server.grequests.test": {PublicKeyPin(s.pki.server.cert)}}),
+		TLSConfig(func(config *tls.Config) {
+			config.VerifyConnection = func(tls.ConnectionState) error {
+				verified = true
+				return nil
+			}
+		}))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	s.True(verified)
+
+	for _, pin := range []string{"not base64!", "c2hvcnQ="} {
+		_, err = s.get(PinnedPublicKeys(map[string][]string{"server.grequests.test": {pin}}))
+		s.ErrorContains(err, "invalid public key pin", pin)
+	}
+
+	for _, host := range []string{"server.grequests.test:443", "[::1]:443"} {
+		_, err = s.get(PinnedPublicKeys(map[string][]string{host: {PublicKeyPin(s.pki.server.cert)}}))
+		s.ErrorContains(err, "has a port", host)
+	}
+}
+
+func (s *PinSuite) TestSession() {
+	session := NewSession(RootCAs(s.pki.caPool()), TLSServerName("server.grequests.test"),
+		PinnedPublicKeys(map[string][]string{"server.grequests.test": {PublicKeyPin(s.pki.ca.cert)}}))
+	defer session.CloseIdleConnections()
+
+	_, err := session.Get(context.Background(), s.srv.URL)
+	s.ErrorIs(err, ErrPinMismatch)
+
+	// Pins given with a request of the session apply to it
+	session = NewSession(RootCAs(s.pki.caPool()), TLSServerName("server.grequests.test"))
+	defer session.CloseIdleConnections()
+
+	resp, err := session.Get(context.Background(), s.srv.URL)
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+
+	_, err = session.Get(context.Background(), s.srv.URL,
+		PinnedPublicKeys(map[string][]string{"server.grequests.test": {PublicKeyPin(s.pki.ca.cert)}}))
+	s.ErrorIs(err, ErrPinMismatch)
+
+	resp, err = session.Get(context.Background(), s.srv.URL,
+		PinnedPublicKeys(map[string][]string{"server.grequests.test": {PublicKeyPin(s.pki.server.cert)}}))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+}
+
+func TestPinSuite(t *testing.T) {
+	suite.Run(t, new(PinSuite))
+}
@@ -0,0 +1,138 @@
+package grequests
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultProgressInterval is the minimum amount of time between two progress
+// reports when no other throttle has been set with `ProgressThrottle`. You can
+// change this globally by modifying this variable.
+var DefaultProgressInterval = 100 * time.Millisecond
+
+// ProgressFunc receives the number of bytes transferred so far and the total
+// number of bytes to transfer (-1 when unknown)
+type ProgressFunc func(transferred, total int64)
+
+// progressTracker counts the bytes transferred and reports them. A report is
+// made once the interval has elapsed or once byteInterval bytes have been
+// transferred since the last one, the final report is always made.
+type progressTracker struct {
+	report       ProgressFunc
+	total        int64
+	interval     time.Duration
+	byteInterval int64
+
+	mu           sync.Mutex
+	transferred  int64
+	lastReported int64
+	lastReport   time.Time
+	reported     bool
+	done         bool
+}
+
+func newProgressTracker(total int64, report ProgressFunc, ro *RequestOptions) *progressTracker {
+	interval := ro.ProgressInterval
+	if interval == 0 && ro.ProgressBytes == 0 {
+		interval = DefaultProgressInterval
+	}
+
+	return &progressTracker{
+		report:       report,
+		total:        total,
+		interval:     interval,
+		byteInterval: ro.ProgressBytes,
+		lastReport:   time.Now(),
+	}
+}
+
+// add records n more bytes, final marks the end of the transfer
+func (p *progressTracker) add(n int64, final bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done {
+		return
+	}
+
+	p.transferred += n
+
+	switch {
+	case final:
+		p.done = true
+		if p.reported && p.lastReported == p.transferred {
+			return
+		}
+	case n == 0:
+		return
+	case p.interval > 0 && time.Since(p.lastReport) >= p.interval:
+	case p.byteInterval > 0 && p.transferred-p.lastReported >= p.byteInterval:
+	default:
+		return
+	}
+
+	p.lastReport = time.Now()
+	p.lastReported = p.transferred
+	p.reported = true
+	p.report(p.transferred, p.total)
+}
+
+// progressReader reports the number of bytes read from the wrapped body
+type progressReader struct {
+	io.ReadCloser
+	tracker *progressTracker
+}
+
+func newProgressReader(body io.ReadCloser, total int64, report ProgressFunc, ro *RequestOptions) *progressReader {
+	return &progressReader{ReadCloser: body, tracker: newProgressTracker(total, report, ro)}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.tracker.add(int64(n), err == io.EOF)
+	return n, err
+}
+
+// addUploadProgress reports the progress of every copy of the request body
+func addUploadProgress(req *http.Request, ro *RequestOptions) {
+	if ro.UploadProgress == nil || req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+
+	total := req.ContentLength
+	if total == 0 {
+		total = -1
+	}
+
+	req.Body = newProgressReader(req.Body, total, ro.UploadProgress, ro)
+
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return newProgressReader(body, total, ro.UploadProgress, ro), nil
+		}
+	}
+}
+
+// addDownloadProgress reports the progress of reading the response body
+func addDownloadProgress(resp *http.Response, ro *RequestOptions) {
+	if ro.DownloadProgress == nil || resp.Body == nil || resp.Body == http.NoBody {
+		return
+	}
+
+	resp.Body = newProgressReader(resp.Body, resp.ContentLength, ro.DownloadProgress, ro)
+}
+
+func firstProgressFunc(values ...ProgressFunc) ProgressFunc {
+	for _, v := range values {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
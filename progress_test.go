@@ -0,0 +1,179 @@
+package grequests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ProgressSuite struct {
+	suite.Suite
+}
+
+type progressRecorder struct {
+	mu      sync.Mutex
+	reports [][2]int64
+}
+
+func (p *progressRecorder) record(transferred, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reports = append(p.reports, [2]int64{transferred, total})
+}
+
+func (p *progressRecorder) last() [2]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.reports) == 0 {
+		return [2]int64{}
+	}
+	return p.reports[len(p.reports)-1]
+}
+
+func (p *progressRecorder) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.reports)
+}
+
+// newSinkServer reads the whole request body and replies with `size` bytes
+func newSinkServer(size int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		_, _ = w.Write([]byte(strings.Repeat("x", size)))
+	}))
+}
+
+func (s *ProgressSuite) TestUploadRequestBody() {
+	srv := newSinkServer(0)
+	defer srv.Close()
+
+	progress := &progressRecorder{}
+	_, err := Post(context.Background(), srv.URL,
+		RequestBody(strings.NewReader(strings.Repeat("x", 1000))),
+		UploadProgress(progress.record),
+		ProgressThrottle(0, 100))
+	s.Require().NoError(err)
+
+	s.Equal([2]int64{1000, 1000}, progress.last())
+}
+
+func (s *ProgressSuite) TestUploadMultipart() {
+	srv := newSinkServer(0)
+	defer srv.Close()
+
+	files, err := FileUploadFromDisk("testdata/mypassword")
+	s.Require().NoError(err)
+
+	progress := &progressRecorder{}
+	_, err = Post(context.Background(), srv.URL, Files(files), UploadProgress(progress.record))
+	s.Require().NoError(err)
+
+	last := progress.last()
+	s.Greater(last[1], int64(0))
+	s.Equal(last[1], last[0])
+}
+
+func (s *ProgressSuite) TestUploadRawFileWithUnknownSize() {
+	srv := newSinkServer(0)
+	defer srv.Close()
+
+	progress := &progressRecorder{}
+	_, err := Put(context.Background(), srv.URL,
+		Files([]FileUpload{{FileName: "a.txt", FileContents: io.NopCloser(strings.NewReader("data"))}}),
+		UploadProgress(progress.record))
+	s.Require().NoError(err)
+
+	s.Equal([2]int64{4, -1}, progress.last())
+}
+
+func (s *ProgressSuite) TestUploadProgressRestartsOnRetry() {
+	var hits int32
+	srv := newFlakyServer(1, http.StatusServiceUnavailable, &hits, nil)
+	defer srv.Close()
+
+	progress := &progressRecorder{}
+	_, err := Put(context.Background(), srv.URL,
+		RequestBody(strings.NewReader("data")),
+		UploadProgress(progress.record),
+		Retry(fastRetry))
+	s.Require().NoError(err)
+
+	s.Equal([][2]int64{{4, 4}, {4, 4}}, progress.reports)
+}
+
+func (s *ProgressSuite) TestDownloadToFile() {
+	srv := newSinkServer(1 << 20)
+	defer srv.Close()
+
+	progress := &progressRecorder{}
+	resp, err := Get(context.Background(), srv.URL, DownloadProgress(progress.record), ProgressThrottle(0, 64<<10))
+	s.Require().NoError(err)
+
+	file := filepath.Join(s.T().TempDir(), "download")
+	s.Require().NoError(resp.DownloadToFile(file))
+
+	info, err := os.Stat(file)
+	s.Require().NoError(err)
+	s.Equal(int64(1<<20), info.Size())
+
+	s.Equal([2]int64{1 << 20, 1 << 20}, progress.last())
+	s.Greater(progress.count(), 1)
+	for i := 1; i < len(progress.reports)-1; i++ {
+		s.GreaterOrEqual(progress.reports[i][0]-progress.reports[i-1][0], int64(64<<10))
+	}
+}
+
+func (s *ProgressSuite) TestDownloadBytes() {
+	srv := newSinkServer(100)
+	defer srv.Close()
+
+	progress := &progressRecorder{}
+	session := NewSession(DownloadProgress(progress.record))
+	resp, err := session.Get(context.Background(), srv.URL)
+	s.Require().NoError(err)
+	s.Len(resp.Bytes(), 100)
+
+	// Every report is throttled by time except the final one
+	s.Equal([][2]int64{{100, 100}}, progress.reports)
+}
+
+func (s *ProgressSuite) TestThrottleByTime() {
+	progress := &progressRecorder{}
+	body := io.NopCloser(strings.NewReader(strings.Repeat("x", 10)))
+	reader := newProgressReader(body, 10, progress.record, &RequestOptions{ProgressInterval: time.Hour})
+
+	buf := make([]byte, 1)
+	for i := 0; i < 5; i++ {
+		_, err := reader.Read(buf)
+		s.Require().NoError(err)
+	}
+	s.Zero(progress.count())
+
+	reader.tracker.lastReport = time.Now().Add(-2 * time.Hour)
+	_, _ = reader.Read(buf)
+	s.Equal([][2]int64{{6, 10}}, progress.reports)
+
+	_, err := io.ReadAll(reader)
+	s.NoError(err)
+	s.Equal([][2]int64{{6, 10}, {10, 10}}, progress.reports)
+
+	// Nothing is reported after the end of the body
+	_, _ = reader.Read(buf)
+	s.Equal(2, progress.count())
+}
+
+func TestProgressSuite(t *testing.T) {
+	suite.Run(t, new(ProgressSuite))
+}
@@ -0,0 +1,266 @@
+package grequests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/proxy"
+)
+
+// isSOCKSProxy reports if the proxy is a SOCKS5 proxy. socks5 proxies are
+// given the address of the host (which we look up), socks5h proxies its name
+func isSOCKSProxy(proxyURL *url.URL) bool {
+	return proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h"
+}
+
+// socksRoutes passes the SOCKS proxies chosen for the requests by the
+// `Proxy` function of the transport to its dialer, which is only asked to
+// connect to the proxy. They are kept by proxy address in the request context.
+type socksRoutes struct {
+	mu     sync.Mutex
+	routes map[string]socksRoute
+}
+
+// socksRoute is a connection to target through a SOCKS proxy
+type socksRoute struct {
+	proxy  *url.URL
+	target string
+}
+
+type socksRoutesKey struct{}
+
+func withSOCKSRoutes(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), socksRoutesKey{}, &socksRoutes{routes: make(map[string]socksRoute)}))
+}
+
+func contextSOCKSRoutes(ctx context.Context) *socksRoutes {
+	routes, _ := ctx.Value(socksRoutesKey{}).(*socksRoutes)
+	return routes
+}
+
+func (r *socksRoutes) set(proxyAddr string, route socksRoute) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes[proxyAddr] = route
+}
+
+func (r *socksRoutes) remove(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.routes, addr)
+}
+
+func (r *socksRoutes) get(addr string) (socksRoute, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	route, ok := r.routes[strings.ToLower(addr)]
+	return route, ok
+}
+
+// routeSOCKS records the proxy chosen for the request and returns the proxy
+// the transport has to use. The transport is given SOCKS proxies as well (its
+// idle connections are kept by proxy) but when the request has been built by
+// us our dialer connects through the proxy (see socksHandshakeConn)
+func routeSOCKS(req *http.Request, proxyURL *url.URL) *url.URL {
+	routes := contextSOCKSRoutes(req.Context())
+	if routes == nil {
+		return proxyURL
+	}
+
+	if proxyURL == nil || !isSOCKSProxy(proxyURL) {
+		routes.remove(canonicalAddr(req.URL))
+		return proxyURL
+	}
+
+	routes.set(socksProxyAddr(proxyURL), socksRoute{proxy: proxyURL, target: canonicalAddr(req.URL)})
+
+	// Every version of net/http we support knows socks5 (which it doesn't
+	// tell from socks5h), the handshake it runs is answered by our connection
+	transportURL := *proxyURL
+	transportURL.Scheme = "socks5"
+	return &transportURL
+}
+
+// socksProxyAddr returns the host:port of the SOCKS proxy
+func socksProxyAddr(proxyURL *url.URL) string {
+	port := proxyURL.Port()
+	if port == "" {
+		port = "1080"
+	}
+	return net.JoinHostPort(strings.ToLower(proxyURL.Hostname()), port)
+}
+
+// socksHandshakeConn is a connection we made through a SOCKS proxy. The
+// transport, which has been given the proxy, runs its own SOCKS handshake
+// over it: it is answered here as the proxy would have (the greeting and the
+// CONNECT request are the first two writes)
+type socksHandshakeConn struct {
+	net.Conn
+
+	writes  int
+	replies bytes.Buffer
+}
+
+func (c *socksHandshakeConn) Write(p []byte) (int, error) {
+	if c.writes >= 2 {
+		return c.Conn.Write(p)
+	}
+
+	if len(p) == 0 || p[0] != 5 {
+		return 0, errors.New("grequests: unexpected SOCKS handshake")
+	}
+
+	if c.writes == 0 {
+		// No authentication required
+		c.replies.Write([]byte{5, 0})
+	} else {
+		// Connected (to 0.0.0.0:0)
+		c.replies.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	}
+	c.writes++
+	return len(p), nil
+}
+
+func (c *socksHandshakeConn) Read(p []byte) (int, error) {
+	if c.replies.Len() != 0 {
+		return c.replies.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+// canonicalAddr returns the host:port the transport connects to for the URL
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if strings.EqualFold(u.Scheme, "https") {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// contextDialer makes a dial function usable as the forward dialer of
+// golang.org/x/net/proxy
+type contextDialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (d contextDialer) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+func (d contextDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
+
+// dialSOCKS connects to addr through the SOCKS5 proxy, which is reached with
+// forward. The host is looked up with lookup for socks5 proxies
+func dialSOCKS(ctx context.Context, proxyURL *url.URL, forward contextDialer,
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error), network, addr string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+
+	proxyAddr := socksProxyAddr(proxyURL)
+
+	dialer, err := proxy.SOCKS5("tcp", proxyAddr, auth, forward)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxyURL.Scheme == "socks5" {
+		host, port, err := net.SplitHostPort(addr)
+		if err == nil && net.ParseIP(host) == nil {
+			addrs, err := lookup(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			addr = net.JoinHostPort(addrs[0].IP.String(), port)
+		}
+	}
+
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("grequests: unable to connect through the SOCKS proxy %s: %w", proxyAddr, err)
+	}
+	return conn, nil
+}
+
+// matchNoProxy reports if the host of the URL matches one of the patterns:
+// "*" matches every host, an IP address or a CIDR range the addresses it
+// contains and a domain name the domain and its sub domains (a leading dot is
+// optional). Patterns may have a port, they then only match that port
+func matchNoProxy(patterns []string, u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	_, port, _ := net.SplitHostPort(canonicalAddr(u))
+	ip := net.ParseIP(host)
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "*" {
+			return true
+		}
+
+		// A CIDR range
+		if _, network, err := net.ParseCIDR(pattern); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if patternHost, patternPort, err := net.SplitHostPort(pattern); err == nil {
+			if patternPort != port {
+				continue
+			}
+			pattern = patternHost
+		}
+		pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "["), "]")
+
+		if patternIP := net.ParseIP(pattern); patternIP != nil {
+			if ip != nil && patternIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		pattern = strings.TrimPrefix(pattern, ".")
+		if pattern != "" && (host == pattern || strings.HasSuffix(host, "."+pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyClientKey returns the proxy settings of a `clientKey` other than the
+// proxies themselves
+func proxyClientKey(ro RequestOptions) (noProxy, connectHeaders string) {
+	headers := make([]string, 0, len(ro.ProxyConnectHeaders))
+	for key, value := range ro.ProxyConnectHeaders {
+		headers = append(headers, key+": "+value)
+	}
+	sort.Strings(headers)
+	return strings.Join(ro.NoProxy, ","), strings.Join(headers, "\n")
+}
+
+// proxyConnectHeader returns the headers sent to the proxies with CONNECT
+func proxyConnectHeader(ro RequestOptions) http.Header {
+	if len(ro.ProxyConnectHeaders) == 0 {
+		return nil
+	}
+
+	header := make(http.Header, len(ro.ProxyConnectHeaders))
+	for key, value := range ro.ProxyConnectHeaders {
+		header.Set(key, value)
+	}
+	return header
+}
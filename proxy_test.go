@@ -0,0 +1,367 @@
+package grequests
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProxySuite struct {
+	suite.Suite
+
+	backend *httptest.Server
+}
+
+func (s *ProxySuite) SetupSuite() {
+	s.backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+}
+
+func (s *ProxySuite) TearDownSuite() {
+	s.backend.Close()
+}
+
+func (s *ProxySuite) SetupTest() {
+	ResetClientPool()
+}
+
+// socksServer is a SOCKS5 proxy that connects every client to backend and
+// records the addresses it was asked to connect to
+type socksServer struct {
+	listener net.Listener
+	backend  string
+
+	// username and password are required when username is set
+	username, password string
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func newSOCKSServer(t *testing.T, backend, username, password string) *socksServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	server := &socksServer{listener: listener, backend: backend, username: username, password: password}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (p *socksServer) url(scheme string, user *url.Userinfo) *url.URL {
+	return &url.URL{Scheme: scheme, Host: p.listener.Addr().String(), User: user}
+}
+
+func (p *socksServer) recorded() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+func (p *socksServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	target, err := p.handshake(bufio.NewReader(conn), conn)
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	p.targets = append(p.targets, target)
+	p.mu.Unlock()
+
+	backend, err := net.Dial("tcp", p.backend)
+	if err != nil {
+		return
+	}
+	defer func() { _ = backend.Close() }()
+
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go func() { _, _ = io.Copy(backend, conn) }()
+	_, _ = io.Copy(conn, backend)
+}
+
+// handshake negotiates the authentication and reads the CONNECT request of
+// the client (RFC 1928 and RFC 1929)
+func (p *socksServer) handshake(r *bufio.Reader, w io.Writer) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(r, make([]byte, header[1])); err != nil {
+		return "", err
+	}
+
+	if p.username == "" {
+		_, _ = w.Write([]byte{5, 0})
+	} else {
+		_, _ = w.Write([]byte{5, 2})
+
+		readString := func() string {
+			length, _ := r.ReadByte()
+			value := make([]byte, length)
+			_, _ = io.ReadFull(r, value)
+			return string(value)
+		}
+		_, _ = r.ReadByte()
+		username, password := readString(), readString()
+		if username != p.username || password != p.password {
+			_, _ = w.Write([]byte{1, 1})
+			return "", errors.New("invalid credentials")
+		}
+		_, _ = w.Write([]byte{1, 0})
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(r, request); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch request[3] {
+	case 1, 4:
+		ip := make([]byte, map[byte]int{1: 4, 4: 16}[request[3]])
+		_, _ = io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case 3:
+		length, _ := r.ReadByte()
+		name := make([]byte, length)
+		_, _ = io.ReadFull(r, name)
+		host = string(name)
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func (s *ProxySuite) TestSOCKS5() {
+	proxy := newSOCKSServer(s.T(), s.backend.Listener.Addr().String(), "", "")
+
+	// The proxy looks up socks5h hosts
+	resp, err := Get(context.Background(), "http://app.grequests.test/a",
+		Proxies(map[string]*url.URL{"http": proxy.url("socks5h", nil)}))
+	s.Require().NoError(err)
+	s.Equal("app.grequests.test", resp.String())
+
+	// We look up socks5 hosts (with the overrides and resolver)
+	resp, err = Get(context.Background(), "http://app.grequests.test:8080/b",
+		Proxies(map[string]*url.URL{"http": proxy.url("socks5", nil)}),
+		ResolveOverride(map[string]string{"app.grequests.test": "192.0.2.1"}))
+	s.Require().NoError(err)
+	s.Equal("app.grequests.test:8080", resp.String())
+
+	s.Equal([]string{"app.grequests.test:80", "192.0.2.1:8080"}, proxy.recorded())
+
+	// Proxies of other protocols aren't used
+	resp, err = Get(context.Background(), s.backend.URL,
+		Proxies(map[string]*url.URL{"https": proxy.url("socks5h", nil)}))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	s.Len(proxy.recorded(), 2)
+}
+
+func (s *ProxySuite) TestSOCKS5Authentication() {
+	proxy := newSOCKSServer(s.T(), s.backend.Listener.Addr().String(), "user", "secret")
+
+	resp, err := Get(context.Background(), "http://app.grequests.test/",
+		Proxies(map[string]*url.URL{"http": proxy.url("socks5h", url.UserPassword("user", "secret"))}))
+	s.Require().NoError(err)
+	s.Equal("app.grequests.test", resp.String())
+
+	for _, user := range []*url.Userinfo{nil, url.UserPassword("user", "wrong")} {
+		_, err = Get(context.Background(), "http://app.grequests.test/",
+			Proxies(map[string]*url.URL{"http": proxy.url("socks5h", user)}))
+		s.ErrorContains(err, "SOCKS proxy")
+	}
+}
+
+func (s *ProxySuite) TestSOCKS5Session() {
+	proxy := newSOCKSServer(s.T(), s.backend.Listener.Addr().String(), "", "")
+
+	session := NewSession(Proxies(map[string]*url.URL{"http": proxy.url("socks5h", nil)}), NoProxy([]string{"direct.grequests.test"}))
+	defer session.CloseIdleConnections()
+
+	for i := 0; i < 2; i++ {
+		resp, err := session.Get(context.Background(), "http://session.grequests.test/")
+		s.Require().NoError(err)
+		s.Equal("session.grequests.test", resp.String())
+	}
+
+	// The connection is kept alive
+	s.Equal([]string{"session.grequests.test:80"}, proxy.recorded())
+
+	// Hosts bypassing the proxy are reached directly
+	_, err := session.Get(context.Background(), "http://direct.grequests.test/")
+	s.ErrorIs(err, ErrDNS)
+
+	// And so are the ones given with a request
+	resp, err := session.Get(context.Background(), s.backend.URL, NoProxy([]string{"127.0.0.0/8"}))
+	s.Require().NoError(err)
+	s.Equal(s.backend.Listener.Addr().String(), resp.String())
+	s.Len(proxy.recorded(), 1)
+}
+
+func (s *ProxySuite) TestSOCKSRoutesArePooledSeparately() {
+	var conns int32
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	backend.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	proxy := newSOCKSServer(s.T(), backend.Listener.Addr().String(), "", "")
+
+	// The route of the host changes between requests (as it may with a PAC file)
+	transport, err := createHTTPTransport(RequestOptions{})
+	s.Require().NoError(err)
+	defer transport.CloseIdleConnections()
+
+	var viaSOCKS bool
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if viaSOCKS {
+			return routeSOCKS(req, proxy.url("socks5h", nil)), nil
+		}
+		return routeSOCKS(req, nil), nil
+	}
+	client := &http.Client{Transport: transport}
+
+	for _, route := range []bool{true, false, true, false} {
+		viaSOCKS = route
+		resp, err := Get(context.Background(), backend.URL, HTTPClient(client))
+		s.Require().NoError(err)
+		s.Equal(backend.Listener.Addr().String(), resp.String())
+	}
+
+	// Every route has its own connection, which is reused
+	s.Equal(int32(2), atomic.LoadInt32(&conns))
+	s.Len(proxy.recorded(), 1)
+}
+
+func (s *ProxySuite) TestNoProxy() {
+	unreachable, _ := url.Parse("http://127.0.0.1:1")
+	proxies := Proxies(map[string]*url.URL{"http": unreachable})
+
+	_, err := Get(context.Background(), s.backend.URL, proxies)
+	s.ErrorIs(err, ErrConnRefused)
+
+	resp, err := Get(context.Background(), s.backend.URL, proxies, NoProxy([]string{"127.0.0.0/8"}))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+
+	tests := []struct {
+		url      string
+		patterns []string
+		match    bool
+	}{
+		{"http://example.com", []string{"*"}, true},
+		{"http://example.com", []string{"example.com"}, true},
+		{"http://api.Example.com", []string{"example.com"}, true},
+		{"http://api.example.com", []string{".example.com"}, true},
+		{"http://example.com", []string{".example.com"}, true},
+		{"http://badexample.com", []string{"example.com"}, false},
+		{"http://example.com:8443", []string{"example.com:8443"}, true},
+		{"http://example.com", []string{"example.com:8443"}, false},
+		{"https://example.com", []string{"example.com:443"}, true},
+		{"http://10.1.2.3", []string{"10.0.0.0/8"}, true},
+		{"http://11.1.2.3", []string{"10.0.0.0/8"}, false},
+		{"http://[fd00::1]:8080", []string{"fd00::/8"}, true},
+		{"http://[::1]", []string{"[::1]"}, true},
+		{"http://192.168.1.1", []string{"192.168.1.1"}, true},
+		{"http://192.168.1.1", []string{"example.com", "192.168.1.2"}, false},
+		{"http://example.com", nil, false},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		s.Require().NoError(err)
+		s.Equal(test.match, matchNoProxy(test.patterns, u), "%s %v", test.url, test.patterns)
+	}
+}
+
+func (s *ProxySuite) TestProxyConnectHeaders() {
+	pki := newTestPKI(s.T())
+	target := pki.tlsServer(func(config *tls.Config) { config.ClientAuth = tls.NoClientCert })
+	defer target.Close()
+
+	var received http.Header
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		received = r.Header.Clone()
+
+		upstream, err := net.Dial("tcp", target.Listener.Addr().String())
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer func() { _ = upstream.Close() }()
+
+		w.WriteHeader(http.StatusOK)
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		go func() { _, _ = io.Copy(upstream, buf) }()
+		_, _ = io.Copy(conn, upstream)
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	resp, err := Get(context.Background(), "https://server.grequests.test/", RootCAs(pki.caPool()),
+		Proxies(map[string]*url.URL{"https": proxyURL}),
+		ProxyConnectHeaders(map[string]string{"Proxy-Authorization": "Bearer token", "X-Tenant": "a"}))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	s.Equal("Bearer token", received.Get("Proxy-Authorization"))
+	s.Equal("a", received.Get("X-Tenant"))
+
+	// Sessions merge their headers with the ones of the request
+	session := NewSession(RootCAs(pki.caPool()), Proxies(map[string]*url.URL{"https": proxyURL}),
+		ProxyConnectHeaders(map[string]string{"X-Tenant": "session"}))
+	defer session.CloseIdleConnections()
+
+	resp, err = session.Get(context.Background(), "https://server.grequests.test/",
+		ProxyConnectHeaders(map[string]string{"Proxy-Authorization": "Bearer request"}))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	s.Equal("Bearer request", received.Get("Proxy-Authorization"))
+	s.Equal("session", received.Get("X-Tenant"))
+}
+
+func TestProxySuite(t *testing.T) {
+	suite.Run(t, new(ProxySuite))
+}
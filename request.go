@@ -3,19 +3,15 @@ package grequests
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"encoding/xml"
-	"errors"
-	"fmt"
 	"io"
 	"mime"
-	"mime/multipart"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
-	"net/textproto"
 	"net/url"
-	"strconv"
 	"strings"
 	"time"
 
@@ -72,7 +68,7 @@ type RequestOptions struct {
 	// Auth allows you to specify a user name and password that you wish to
 	// use when requesting the URL. It will use basic HTTP authentication
 	// formatting the username and password in base64 the format is:
-	// []string{username, password}
+	// []string{username, password}. It is ignored when an `Authenticator` is set
 	Auth []string
 
 	// IsAjax is a flag that can be set to make the request appear
@@ -89,6 +85,9 @@ type RequestOptions struct {
 
 	// Proxies is a map in the following format
 	// *protocol* => proxy address e.g http => http://127.0.0.1:8080
+	// HTTP(S) and SOCKS5 proxies are supported: socks5:// proxies are given
+	// the address of the host while socks5h:// proxies look it up themselves.
+	// Credentials are taken from the user info of the proxy URL
 	Proxies map[string]*url.URL
 
 	// TLSHandshakeTimeout specifies the maximum amount of time waiting to
@@ -138,15 +137,232 @@ type RequestOptions struct {
 
 	// LocalAddr allows you to send the request on any local interface
 	LocalAddr *net.TCPAddr
+
+	// ErrorOnStatus will make the request return an `*HTTPError` when the server
+	// doesn't respond with a 2xx status code
+	ErrorOnStatus bool
+
+	// AfterResponse is a list of hooks that are run (in order) once the response
+	// has been received. The first hook that returns an error stops the chain,
+	// the error is set as the `Response.Error` and returned from the request.
+	// This is useful to validate responses
+	AfterResponse []func(resp *Response) error
+
+	// Middleware is the chain of middleware that wraps the execution of the
+	// request. The first middleware is the outermost one
+	Middleware []Middleware
+
+	// RetryPolicy specifies if and how a failed request should be retried.
+	// When nil the request is only attempted once
+	RetryPolicy *RetryPolicy
+
+	// Authenticator adds credentials to the request. It takes precedence over
+	// `Auth`
+	Authenticator Authenticator
+
+	// MaxBodySize is the maximum number of bytes of the response body we are
+	// willing to read. Reading more than that fails with `ErrBodyTooLarge`.
+	// Zero means no limit
+	MaxBodySize int64
+
+	// UploadProgress is called while the request body is being sent
+	UploadProgress ProgressFunc
+
+	// DownloadProgress is called while the response body is being read
+	DownloadProgress ProgressFunc
+
+	// ProgressInterval is the minimum amount of time between two progress
+	// reports. When neither ProgressInterval nor ProgressBytes are set
+	// `DefaultProgressInterval` is used
+	ProgressInterval time.Duration
+
+	// ProgressBytes is the number of bytes that have to be transferred before
+	// the progress is reported again
+	ProgressBytes int64
+
+	// ExpectedSHA256 is the hex encoded SHA-256 checksum a file fetched with
+	// `Download` must match
+	ExpectedSHA256 string
+
+	// DownloadSegments is the number of concurrent range requests `Download`
+	// splits the file into
+	DownloadSegments int
+
+	// CredentialProviders are consulted (in order) for the credentials of the
+	// host when neither `Authenticator` nor `Auth` is set
+	CredentialProviders []CredentialProvider
+
+	// ClientCertificates are presented to servers that ask for a client
+	// certificate (mutual TLS)
+	ClientCertificates []TLSCertificate
+
+	// RootCAs is the set of certificate authorities used to verify the server
+	// certificate. When nil the system pool is used
+	RootCAs *x509.CertPool
+
+	// RootCAFiles are PEM files of certificate authorities added to `RootCAs`
+	// (or to the system pool when it is nil)
+	RootCAFiles []string
+
+	// TLSMinVersion is the minimum TLS version we accept e.g. `tls.VersionTLS13`
+	TLSMinVersion uint16
+
+	// TLSServerName is the name used to verify the server certificate (and sent
+	// with SNI) instead of the host of the URL
+	TLSServerName string
+
+	// TLSConfig are functions that modify the TLS configuration once every
+	// other setting has been applied. Requests using them don't share pooled
+	// clients, use a `Session` to reuse connections
+	TLSConfig []func(*tls.Config)
+
+	// PinnedPublicKeys maps host names to the pins (see `PublicKeyPin`) of the
+	// public keys they may present. Connections to a host with pins are
+	// rejected with `ErrPinMismatch` unless its leaf or an intermediate
+	// certificate matches one of them. Hosts are matched against the TLS server
+	// name, which is `TLSServerName` when it is set
+	PinnedPublicKeys map[string][]string
+
+	// PinMismatchReporter is called with the pin mismatches instead of
+	// rejecting the connection (report only mode)
+	PinMismatchReporter func(err error)
+
+	// UnixSocket is the path of the Unix domain socket every connection is
+	// made to, whatever the host of the URL is. Proxies are never used with it
+	UnixSocket string
+
+	// DialContext is used to create the connections instead of a `net.Dialer`.
+	// `DialTimeout` still applies while `DialKeepAlive` and `LocalAddr` are up
+	// to the function. Requests using it don't share pooled clients, use a
+	// `Session` to reuse connections
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// ResolveOverrides maps a "host:port" (or a host, for every port) to the
+	// address connections are made to instead: an IP address (or host) which
+	// keeps the port or an "ip:port". The URL, and so SNI, certificate
+	// verification and the Host header, is left untouched
+	ResolveOverrides map[string]string
+
+	// Resolver is used to look up the hosts we connect to (to use other DNS
+	// servers for instance). When nil the default resolver is used
+	Resolver *net.Resolver
+
+	// DNSCacheTTL is how long the addresses of the hosts are kept once they
+	// have been looked up. Zero disables the cache
+	DNSCacheTTL time.Duration
+
+	// ProxyConnectHeaders are sent to HTTP proxies with the CONNECT requests
+	// that tunnel HTTPS requests
+	ProxyConnectHeaders map[string]string
+
+	// NoProxy lists the hosts that are never reached through a proxy (see
+	// `NoProxy`), whether it comes from `Proxies` or from the environment
+	NoProxy []string
+
+	// ProxyAutoConfig is the URL (http, https or file) or the content of a PAC
+	// file that chooses the proxies of the hosts without `Proxies` for their
+	// protocol. It takes precedence over the environment
+	ProxyAutoConfig string
+
+	// ForceHTTP2 uses HTTP/2 with the servers that support it (it is
+	// negotiated during the TLS handshake), others are reached with HTTP/1.1
+	ForceHTTP2 bool
+
+	// DisableHTTP2 only uses HTTP/1.1
+	DisableHTTP2 bool
+
+	// H2C sends the requests of http URLs with HTTP/2 in clear text (with
+	// prior knowledge: the server must support it). HTTP/2 is used with TLS
+	// as with `ForceHTTP2`
+	H2C bool
+
+	// HTTP2ReadIdleTimeout is how long an HTTP/2 connection can go without
+	// receiving a frame before a ping is sent to check it (zero disables the
+	// health check)
+	HTTP2ReadIdleTimeout time.Duration
+
+	// HTTP2PingTimeout is how long we wait for the answer to a health check
+	// ping before closing the connection (15 seconds when zero)
+	HTTP2PingTimeout time.Duration
 }
 
 // DoRegularRequest adds generic test functionality
 func DoRegularRequest(requestVerb, url string, ro *RequestOptions) (*Response, error) {
-	return buildResponse(buildRequest(requestVerb, url, ro, nil))
+	return doRequest(requestVerb, url, ro, nil)
 }
 
 func doSessionRequest(requestVerb, url string, ro *RequestOptions, httpClient *http.Client) (*Response, error) {
-	return buildResponse(buildRequest(requestVerb, url, ro, httpClient))
+	return doRequest(requestVerb, url, ro, httpClient)
+}
+
+// doRequest builds the request and sends it through the middleware chain
+func doRequest(requestVerb, url string, ro *RequestOptions, httpClient *http.Client) (*Response, error) {
+	if ro == nil {
+		ro = &RequestOptions{}
+	}
+
+	req, httpClient, err := buildRequest(requestVerb, url, ro, httpClient)
+	if err != nil {
+		return buildResponse(nil, err)
+	}
+
+	send := DoerFunc(func(req *http.Request) (*Response, error) {
+		resp, err := buildResponse(sendAuthenticatedRequest(httpClient, req, ro))
+		if err != nil {
+			return resp, err
+		}
+
+		if ro.MaxBodySize > 0 {
+			if err := limitResponseBody(resp.RawResponse, ro.MaxBodySize); err != nil {
+				resp.Error = err
+				resp.discardBody()
+				return resp, err
+			}
+		}
+
+		addDownloadProgress(resp.RawResponse, ro)
+
+		if err := validateResponse(resp, ro); err != nil {
+			// The body can no longer be read once the error is set
+			resp.Error = err
+			resp.discardBody()
+			return resp, err
+		}
+
+		return resp, nil
+	})
+
+	resp, err := chainMiddleware(ro.Middleware, send).Do(req)
+	finishRequestBody(req)
+	if resp == nil {
+		resp = &Response{Error: err}
+	}
+	return resp, err
+}
+
+// validateResponse checks the status code (if asked to) and runs the
+// AfterResponse hooks, the first error is returned
+func validateResponse(resp *Response, ro *RequestOptions) error {
+	if ro.ErrorOnStatus {
+		if err := resp.RaiseForStatus(); err != nil {
+			return err
+		}
+	}
+
+	for _, hook := range ro.AfterResponse {
+		if err := hook(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendRequest fires the request (retrying it if the user asked us to)
+func sendRequest(httpClient *http.Client, req *http.Request, ro *RequestOptions) (*http.Response, error) {
+	if ro.RetryPolicy == nil {
+		return httpClient.Do(req)
+	}
+	return ro.RetryPolicy.do(httpClient, req, requestAuthenticator(ro))
 }
 
 var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
@@ -155,31 +371,67 @@ func escapeQuotes(s string) string {
 	return quoteEscaper.Replace(s)
 }
 
-// buildRequest is where most of the magic happens for request processing
-func buildRequest(httpMethod, url string, ro *RequestOptions, httpClient *http.Client) (*http.Response, error) {
+// buildRequest is where most of the magic happens for request processing. It
+// returns the request ready to be sent along with the client to send it with
+func buildRequest(httpMethod, url string, ro *RequestOptions, httpClient *http.Client) (*http.Request, *http.Client, error) {
 	if ro == nil {
 		ro = &RequestOptions{}
 	}
 
+	// We never modify the options that were handed to us – they may be shared
+	// between goroutines
+	requestOptions := *ro
+	ro = &requestOptions
+
 	if ro.CookieJar != nil {
 		ro.UseCookieJar = true
 	}
 
-	// Create our own HTTP client
+	// http+unix URLs are sent to localhost over the socket they name
+	socket, httpURL, unixSocketURL, err := parseUnixSocketURL(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	if unixSocketURL {
+		if ro.HTTPClient != nil {
+			return nil, nil, errUnixSocketHTTPClient
+		}
+		url, ro.UnixSocket = httpURL, socket
+	}
+
+	// Create our own HTTP client (or reuse a pooled one)
 
-	if httpClient == nil {
-		httpClient = BuildHTTPClient(*ro)
+	switch {
+	case httpClient == nil:
+		httpClient = pooledHTTPClient(*ro)
+	case ro.HTTPClient != nil:
+		// A client provided with the request takes precedence over the session client
+		httpClient = ro.HTTPClient
+	case unixSocketURL:
+		// The session client doesn't connect to the socket but its cookies
+		// are still used
+		jar := httpClient.Jar
+		httpClient = pooledHTTPClient(*ro)
+		httpClient.Jar = jar
+	}
+
+	// The redirect policy and timeout are specific to this request so we
+	// install them on a copy of the (possibly shared) client
+	client := *httpClient
+	httpClient = &client
+
+	if ro.HTTPClient == nil && ro.RequestTimeout != 0 {
+		httpClient.Timeout = ro.RequestTimeout
 	}
 
-	var err error // we don't want to shadow url so we won't use :=
 	switch {
 	case len(ro.Params) != 0:
 		if url, err = buildURLParams(url, ro.Params); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	case ro.QueryStruct != nil:
 		if url, err = buildURLStruct(url, ro.QueryStruct); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
@@ -187,10 +439,20 @@ func buildRequest(httpMethod, url string, ro *RequestOptions, httpClient *http.C
 	req, err := buildHTTPRequest(httpMethod, url, ro)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Do we need to add any HTTP headers or Basic Auth?
+	// The body (and the files being uploaded) is closed when the request
+	// isn't sent, as the transport would have done
+	body := req.Body
+	fail := func(err error) (*http.Request, *http.Client, error) {
+		if body != nil {
+			_ = body.Close()
+		}
+		return nil, nil, err
+	}
+
+	// Do we need to add any HTTP headers?
 	addHTTPHeaders(ro, req)
 	addCookies(ro, req)
 
@@ -200,13 +462,44 @@ func buildRequest(httpMethod, url string, ro *RequestOptions, httpClient *http.C
 		req = req.WithContext(ro.Context)
 	}
 
+	req = withSOCKSRoutes(req)
+
 	if ro.BeforeRequest != nil {
 		if err := ro.BeforeRequest(req); err != nil {
-			return nil, err
+			return fail(err)
 		}
 	}
 
-	return httpClient.Do(req)
+	auth := requestAuthenticator(ro)
+
+	// Authenticators may need to send the request again to answer a challenge
+	if ro.RetryPolicy != nil || auth != nil {
+		req = prepareRequestBodyForRetry(req, rewindableBody(httpMethod, ro))
+	}
+
+	if auth != nil {
+		if err := auth.Authenticate(req); err != nil {
+			return fail(err)
+		}
+	}
+
+	addUploadProgress(req, ro)
+
+	return req, httpClient, nil
+}
+
+// rewindableBody returns the reader that was used as the request body when
+// it was provided by the user (and may therefore be seekable)
+func rewindableBody(httpMethod string, ro *RequestOptions) io.Reader {
+	switch {
+	case ro.RequestBody != nil:
+		return ro.RequestBody
+	case ro.JSON != nil, ro.XML != nil:
+		return nil
+	case ro.Files != nil && httpMethod != "POST":
+		return ro.Files[0].FileContents
+	}
+	return nil
 }
 
 func buildHTTPRequest(httpMethod, userURL string, ro *RequestOptions) (*http.Request, error) {
@@ -245,13 +538,22 @@ func createFileUploadRequest(httpMethod, userURL string, ro *RequestOptions) (*h
 	// At the moment, we will only support 1 file upload as a time
 	// when uploading using PUT or PATCH
 
-	req, err := http.NewRequest(httpMethod, userURL, ro.Files[0].FileContents)
+	f := ro.Files[0]
+	req, err := http.NewRequest(httpMethod, userURL, f.FileContents)
 
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", mime.TypeByExtension(ro.Files[0].FileName))
+	if size, ok := readerSize(f.FileContents); ok {
+		req.ContentLength = size
+	}
+
+	if f.GetFileContents != nil {
+		req.GetBody = f.GetFileContents
+	}
+
+	req.Header.Set("Content-Type", mime.TypeByExtension(f.FileName))
 
 	return req, nil
 
@@ -284,75 +586,32 @@ func createBasicXMLRequest(httpMethod, userURL string, ro *RequestOptions) (*htt
 
 }
 func createMultiPartPostRequest(httpMethod, userURL string, ro *RequestOptions) (*http.Request, error) {
-	requestBody := &bytes.Buffer{}
-
-	multipartWriter := multipart.NewWriter(requestBody)
-
-	for i, f := range ro.Files {
-
-		if f.FileContents == nil {
-			return nil, errors.New("grequests: Pointer FileContents cannot be nil")
-		}
-
-		fieldName := f.FieldName
-
-		if fieldName == "" {
-			if len(ro.Files) > 1 {
-				fieldName = strings.Join([]string{"file", strconv.Itoa(i + 1)}, "")
-			} else {
-				fieldName = "file"
-			}
-		}
-
-		var writer io.Writer
-		var err error
-
-		if f.FileMime != "" {
-			if f.FileName == "" {
-				f.FileName = "filename"
-			}
-			h := make(textproto.MIMEHeader)
-			h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(fieldName), escapeQuotes(f.FileName)))
-			h.Set("Content-Type", f.FileMime)
-			writer, err = multipartWriter.CreatePart(h)
-		} else {
-			writer, err = multipartWriter.CreateFormFile(fieldName, f.FileName)
-		}
-
-		if err != nil {
-			return nil, err
-		}
-
-		if _, err = io.Copy(writer, f.FileContents); err != nil && err != io.EOF {
-			return nil, err
-		}
-
-		if err := f.FileContents.Close(); err != nil {
-			return nil, err
-		}
-
-	}
-
-	// Populate the other parts of the form (if there are any)
-	for key, value := range ro.Data {
-		if err := multipartWriter.WriteField(key, value); err != nil {
-			return nil, err
-		}
+	body, err := newMultipartBody(ro)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := multipartWriter.Close(); err != nil {
-		return nil, err
+	sources := make([]io.ReadCloser, len(body.files))
+	for i, f := range body.files {
+		sources[i] = f.FileContents
 	}
 
-	req, err := http.NewRequest(httpMethod, userURL, requestBody)
+	req, err := http.NewRequest(httpMethod, userURL, nil)
 
 	if err != nil {
+		_ = closeAll(sources)
 		return nil, err
 	}
 
-	req.Header.Add("Content-Type", multipartWriter.FormDataContentType())
+	// The body is streamed, the Content-Length is only sent if we know the
+	// size of every file – otherwise the chunked encoding is used
+	req.Body = body.reader(sources)
+	req.ContentLength = body.contentLength()
+	req.GetBody = body.getBody()
 
-	return req, err
+	req.Header.Add("Content-Type", body.contentType())
+
+	return req, nil
 }
 
 func createBasicJSONRequest(httpMethod, userURL string, ro *RequestOptions) (*http.Request, error) {
@@ -408,19 +667,26 @@ func encodePostValues(postValues map[string]string) string {
 // proxySettings will default to the default proxy settings if none are provided
 // if settings are provided – they will override the environment variables
 func (ro RequestOptions) proxySettings(req *http.Request) (*url.URL, error) {
-	// No proxies – lets use the default
-	if len(ro.Proxies) == 0 {
-		return http.ProxyFromEnvironment(req)
+	// Every connection is made to the socket
+	if ro.UnixSocket != "" {
+		return nil, nil
 	}
 
-	// There was a proxy specified – do we support the protocol?
-	if _, ok := ro.Proxies[req.URL.Scheme]; ok {
-		return ro.Proxies[req.URL.Scheme], nil
+	if matchNoProxy(ro.NoProxy, req.URL) {
+		return nil, nil
 	}
 
-	// Proxies were specified but not for any protocol that we use
-	return http.ProxyFromEnvironment(req)
+	// There was a proxy specified – do we support the protocol?
+	if proxyURL, ok := ro.Proxies[req.URL.Scheme]; ok {
+		return routeSOCKS(req, proxyURL), nil
+	}
 
+	// No proxies (or none for the protocol that we use) – lets use the default
+	proxyURL, err := http.ProxyFromEnvironment(req)
+	if err != nil {
+		return nil, err
+	}
+	return routeSOCKS(req, proxyURL), nil
 }
 
 // dontUseDefaultClient will tell the "client creator" if a custom client is needed
@@ -435,7 +701,27 @@ func (ro RequestOptions) proxySettings(req *http.Request) (*url.URL, error) {
 // 7. Do you want to use the http.Client's cookieJar?
 // 8. Do you want to change the request timeout?
 // 9. Do you want to set a custom LocalAddr to send the request from
+// 10. Do you want to change the TLS configuration?
+// 11. Do you want to connect to a Unix socket or with your own dialer?
+// 12. Do you want to change how host names are resolved?
+// 13. Do you want to bypass proxies or send headers to them?
+// 14. Do you want to choose the proxies with a PAC file?
+// 15. Do you want to change how HTTP/2 is used?
 func (ro RequestOptions) dontUseDefaultClient() bool {
+	switch {
+	case ro.customTransport():
+	case len(ro.Cookies) != 0:
+	case ro.UseCookieJar:
+	case ro.RequestTimeout != 0:
+	default:
+		return false
+	}
+	return true
+}
+
+// customTransport reports if the options change the transport of the client
+// (every item of `dontUseDefaultClient` but the cookies and request timeout)
+func (ro RequestOptions) customTransport() bool {
 	switch {
 	case ro.InsecureSkipVerify:
 	case ro.DisableCompression:
@@ -443,10 +729,18 @@ func (ro RequestOptions) dontUseDefaultClient() bool {
 	case ro.TLSHandshakeTimeout != 0:
 	case ro.DialTimeout != 0:
 	case ro.DialKeepAlive != 0:
-	case len(ro.Cookies) != 0:
-	case ro.UseCookieJar:
-	case ro.RequestTimeout != 0:
 	case ro.LocalAddr != nil:
+	case ro.customTLS():
+	case ro.PinMismatchReporter != nil:
+	case ro.UnixSocket != "":
+	case ro.DialContext != nil:
+	case len(ro.ResolveOverrides) != 0:
+	case ro.Resolver != nil:
+	case ro.DNSCacheTTL != 0:
+	case len(ro.ProxyConnectHeaders) != 0:
+	case len(ro.NoProxy) != 0:
+	case ro.ProxyAutoConfig != "":
+	case ro.DisableHTTP2 || ro.useHTTP2():
 	default:
 		return false
 	}
@@ -485,41 +779,70 @@ func BuildHTTPClient(ro RequestOptions) *http.Client {
 		ro.RequestTimeout = requestTimeout
 	}
 
-	var cookieJar http.CookieJar
-
-	if ro.UseCookieJar {
-		if ro.CookieJar != nil {
-			cookieJar = ro.CookieJar
-		} else {
-			// The function does not return an error ever... so we are just ignoring it
-			cookieJar, _ = cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
-		}
+	transport, err := createHTTPTransport(ro)
+	if err != nil {
+		return &http.Client{Transport: failingTransport{err: err}}
 	}
 
 	return &http.Client{
-		Jar:       cookieJar,
-		Transport: createHTTPTransport(ro),
+		Jar:       buildCookieJar(ro),
+		Transport: transport,
 		Timeout:   ro.RequestTimeout,
 	}
 }
 
-func createHTTPTransport(ro RequestOptions) *http.Transport {
+// buildCookieJar returns the cookie jar that the client should use (if any)
+func buildCookieJar(ro RequestOptions) http.CookieJar {
+	if !ro.UseCookieJar {
+		return nil
+	}
+
+	if ro.CookieJar != nil {
+		return ro.CookieJar
+	}
+
+	// The function does not return an error ever... so we are just ignoring it
+	cookieJar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return cookieJar
+}
+
+// idleConnTimeout is how long our transports keep idle connections, as
+// http.DefaultTransport does. Pooled transports would keep them forever otherwise
+const idleConnTimeout = 90 * time.Second
+
+func createHTTPTransport(ro RequestOptions) (*http.Transport, error) {
+	tlsConfig, err := buildTLSConfig(ro)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pinConnection(tlsConfig, ro); err != nil {
+		return nil, err
+	}
+
 	ourHTTPTransport := &http.Transport{
 		// These are borrowed from the default transporter
-		Proxy: ro.proxySettings,
-		Dial: (&net.Dialer{
-			Timeout:   ro.DialTimeout,
-			KeepAlive: ro.DialKeepAlive,
-			LocalAddr: ro.LocalAddr,
-		}).Dial,
+		Proxy:               ro.proxySettings,
+		ProxyConnectHeader:  proxyConnectHeader(ro),
+		DialContext:         ro.dialContext(),
 		TLSHandshakeTimeout: ro.TLSHandshakeTimeout,
+		IdleConnTimeout:     idleConnTimeout,
 
 		// Here comes the user settings
-		TLSClientConfig:    &tls.Config{InsecureSkipVerify: ro.InsecureSkipVerify},
+		TLSClientConfig:    tlsConfig,
 		DisableCompression: ro.DisableCompression,
 	}
+
+	if ro.ProxyAutoConfig != "" {
+		ourHTTPTransport.Proxy = newProxyAutoConfig(ro, ourHTTPTransport).proxy
+	}
+
+	if err := configureHTTP2(ourHTTPTransport, ro); err != nil {
+		return nil, err
+	}
+
 	EnsureTransporterFinalized(ourHTTPTransport)
-	return ourHTTPTransport
+	return ourHTTPTransport, nil
 }
 
 // buildURLParams returns a URL with all of the params
@@ -564,10 +887,6 @@ func addHTTPHeaders(ro *RequestOptions, req *http.Request) {
 		req.Host = ro.Host
 	}
 
-	if ro.Auth != nil {
-		req.SetBasicAuth(ro.Auth[0], ro.Auth[1])
-	}
-
 	if ro.IsAjax {
 		req.Header.Set("X-Requested-With", "XMLHttpRequest")
 	}
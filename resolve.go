@@ -0,0 +1,204 @@
+package grequests
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resolveOverride returns the address to connect to instead of addr (a
+// host:port) according to the overrides. Overrides are looked up by host and
+// port first and by host only next. Their value is either a host, which keeps
+// the port, or a host and port.
+func resolveOverride(overrides map[string]string, addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	host = strings.ToLower(host)
+
+	target, ok := overrides[net.JoinHostPort(host, port)]
+	if !ok {
+		if target, ok = overrides[host]; !ok {
+			return addr
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(target); err == nil {
+		return target
+	}
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(target, "["), "]"), port)
+}
+
+// normalizeOverrides lower cases the hosts of the overrides (and removes the
+// brackets of IPv6 addresses without a port)
+func normalizeOverrides(overrides map[string]string) map[string]string {
+	normalized := make(map[string]string, len(overrides))
+	for key, target := range overrides {
+		key = strings.ToLower(key)
+		if host, port, err := net.SplitHostPort(key); err == nil {
+			key = net.JoinHostPort(host, port)
+		} else {
+			key = strings.TrimSuffix(strings.TrimPrefix(key, "["), "]")
+		}
+		normalized[key] = target
+	}
+	return normalized
+}
+
+// overridesClientKey returns the overrides of a `clientKey`
+func overridesClientKey(ro RequestOptions) string {
+	overrides := make([]string, 0, len(ro.ResolveOverrides))
+	for key, target := range ro.ResolveOverrides {
+		overrides = append(overrides, key+"="+target)
+	}
+	sort.Strings(overrides)
+	return strings.Join(overrides, ",")
+}
+
+// dnsCache keeps the addresses of the hosts that have been resolved for ttl
+type dnsCache struct {
+	resolver *net.Resolver
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+func newDNSCache(resolver *net.Resolver, ttl time.Duration) *dnsCache {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &dnsCache{resolver: resolver, ttl: ttl, now: time.Now, entries: make(map[string]dnsCacheEntry)}
+}
+
+// lookup returns the addresses of the host. Failed lookups aren't cached
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+
+	if ok && c.now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := c.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Expired entries are dropped whenever a new one is stored so that the
+	// cache doesn't grow forever
+	now := c.now()
+	for name, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, name)
+		}
+	}
+	c.entries[host] = dnsCacheEntry{addrs: addrs, expires: now.Add(c.ttl)}
+
+	return addrs, nil
+}
+
+// dial connects to the addresses of the host (of the family of the network)
+// one after the other until a connection succeeds. As with net.Dialer the
+// time left to connect is shared between the addresses that remain so that
+// an unreachable address doesn't use it all
+func (c *dnsCache) dial(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs = filterAddrFamily(network, addrs)
+	if len(addrs) == 0 {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: &net.AddrError{Err: "no suitable address found", Addr: host}}
+	}
+
+	deadline, _ := ctx.Deadline()
+	if dialer.Timeout != 0 {
+		if d := time.Now().Add(dialer.Timeout); deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+
+	var firstErr error
+	for i, ip := range addrs {
+		conn, err := dialAddr(ctx, dialer, network, net.JoinHostPort(ip.String(), port), deadline, len(addrs)-i)
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+func dialAddr(ctx context.Context, dialer *net.Dialer, network, addr string, deadline time.Time, remaining int) (net.Conn, error) {
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, partialDeadline(time.Now(), deadline, remaining))
+		defer cancel()
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// minDialTimeout is the least time given to an address (unless there isn't
+// that much time left), as net.Dialer does
+const minDialTimeout = 2 * time.Second
+
+// partialDeadline returns the deadline to connect to one of the remaining
+// addresses when they all have to be tried before the deadline
+func partialDeadline(now, deadline time.Time, remaining int) time.Time {
+	left := deadline.Sub(now)
+	timeout := left / time.Duration(remaining)
+	if timeout < minDialTimeout {
+		timeout = minDialTimeout
+		if left < timeout {
+			timeout = left
+		}
+	}
+	return now.Add(timeout)
+}
+
+// filterAddrFamily returns the addresses that can be used with the network:
+// IPv4 addresses for tcp4, IPv6 addresses for tcp6 and any of them otherwise
+func filterAddrFamily(network string, addrs []net.IPAddr) []net.IPAddr {
+	var ipv4 bool
+	switch network {
+	case "tcp4", "udp4":
+		ipv4 = true
+	case "tcp6", "udp6":
+	default:
+		return addrs
+	}
+
+	filtered := make([]net.IPAddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if (addr.IP.To4() != nil) == ipv4 {
+			filtered = append(filtered, addr)
+		}
+	}
+	return filtered
+}
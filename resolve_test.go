@@ -0,0 +1,257 @@
+package grequests
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type ResolveSuite struct {
+	suite.Suite
+
+	srv  *httptest.Server
+	port string
+}
+
+func (s *ResolveSuite) SetupSuite() {
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every request needs a new connection (and so a lookup)
+		w.Header().Set("Connection", "close")
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	_, s.port, _ = net.SplitHostPort(s.srv.Listener.Addr().String())
+}
+
+func (s *ResolveSuite) TearDownSuite() {
+	s.srv.Close()
+}
+
+func (s *ResolveSuite) SetupTest() {
+	ResetClientPool()
+}
+
+// dnsServer answers the A queries of the names under grequests.test with
+// 127.0.0.1, any other query fails. It returns a resolver that uses it and
+// the number of queries it received
+func (s *ResolveSuite) dnsServer() (*net.Resolver, *int32) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { _ = conn.Close() })
+
+	var queries int32
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&queries, 1)
+
+			var request dnsmessage.Message
+			if err := request.Unpack(buf[:n]); err != nil || len(request.Questions) != 1 {
+				continue
+			}
+			question := request.Questions[0]
+
+			response := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: request.ID, Response: true, Authoritative: true},
+				Questions: request.Questions,
+			}
+			switch {
+			case !strings.HasSuffix(question.Name.String(), ".grequests.test."):
+				response.RCode = dnsmessage.RCodeNameError
+			case question.Type == dnsmessage.TypeA:
+				response.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+				}}
+			}
+
+			packed, err := response.Pack()
+			if err == nil {
+				_, _ = conn.WriteTo(packed, addr)
+			}
+		}
+	}()
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "udp", conn.LocalAddr().String())
+		},
+	}
+	return resolver, &queries
+}
+
+func (s *ResolveSuite) TestResolveOverride() {
+	tests := map[string]map[string]string{
+		"http://staging.example.com:" + s.port: {"staging.example.com:" + s.port: "127.0.0.1"},
+		"http://STAGING.example.com:" + s.port: {"staging.example.com": "[127.0.0.1]"},
+		"http://staging.example.com":           {"Staging.Example.com:80": "127.0.0.1:" + s.port},
+	}
+	for url, overrides := range tests {
+		resp, err := Get(context.Background(), url, ResolveOverride(overrides))
+		s.Require().NoError(err, url)
+		s.Equal(strings.ToLower(strings.TrimPrefix(url, "http://")), strings.ToLower(resp.String()), url)
+	}
+
+	// Overrides of other ports don't apply
+	_, err := Get(context.Background(), "http://staging.example.invalid",
+		ResolveOverride(map[string]string{"staging.example.invalid:8080": "127.0.0.1:" + s.port}), DialTimeout(time.Second))
+	s.Error(err)
+}
+
+func (s *ResolveSuite) TestResolveOverrideTLS() {
+	pki := newTestPKI(s.T())
+	srv := pki.tlsServer(func(config *tls.Config) { config.ClientAuth = tls.NoClientCert })
+	defer srv.Close()
+
+	// SNI and the certificate verification use the name of the URL
+	resp, err := Get(context.Background(), "https://server.grequests.test/", RootCAs(pki.caPool()),
+		ResolveOverride(map[string]string{"server.grequests.test:443": srv.Listener.Addr().String()}))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+
+	_, err = Get(context.Background(), "https://other.grequests.test/", RootCAs(pki.caPool()),
+		ResolveOverride(map[string]string{"other.grequests.test": srv.Listener.Addr().String()}))
+	s.ErrorIs(err, ErrTLSVerify)
+}
+
+func (s *ResolveSuite) TestResolver() {
+	resolver, queries := s.dnsServer()
+
+	resp, err := Get(context.Background(), "http://app.grequests.test:"+s.port, Resolver(resolver))
+	s.Require().NoError(err)
+	s.Equal("app.grequests.test:"+s.port, resp.String())
+	s.NotZero(atomic.LoadInt32(queries))
+
+	_, err = Get(context.Background(), "http://app.example.invalid:"+s.port, Resolver(resolver))
+	s.ErrorIs(err, ErrDNS)
+
+	// Overrides take precedence over the resolver
+	atomic.StoreInt32(queries, 0)
+	resp, err = Get(context.Background(), "http://override.example.invalid:"+s.port, Resolver(resolver),
+		ResolveOverride(map[string]string{"override.example.invalid": "app.grequests.test"}))
+	s.Require().NoError(err)
+	s.Equal("override.example.invalid:"+s.port, resp.String())
+	s.NotZero(atomic.LoadInt32(queries))
+}
+
+func (s *ResolveSuite) TestDNSCache() {
+	resolver, queries := s.dnsServer()
+
+	session := NewSession(Resolver(resolver), DNSCache(time.Minute))
+	defer session.CloseIdleConnections()
+
+	for i := 0; i < 3; i++ {
+		resp, err := session.Get(context.Background(), "http://cached.grequests.test:"+s.port)
+		s.Require().NoError(err)
+		s.Equal("cached.grequests.test:"+s.port, resp.String())
+	}
+	looked := atomic.LoadInt32(queries)
+	s.NotZero(looked)
+
+	_, err := session.Get(context.Background(), "http://cached.example.invalid:"+s.port)
+	s.ErrorIs(err, ErrDNS)
+
+	// A new lookup is made once the entry has expired
+	cache := newDNSCache(resolver, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	atomic.StoreInt32(queries, 0)
+	for i := 0; i < 2; i++ {
+		addrs, err := cache.lookup(context.Background(), "expiring.grequests.test")
+		s.Require().NoError(err)
+		s.Equal("127.0.0.1", addrs[0].IP.String())
+	}
+	s.Equal(looked, atomic.LoadInt32(queries))
+
+	now = now.Add(time.Minute)
+	_, err = cache.lookup(context.Background(), "expiring.grequests.test")
+	s.Require().NoError(err)
+	s.Equal(2*looked, atomic.LoadInt32(queries))
+}
+
+func (s *ResolveSuite) TestDNSCacheAddressFamily() {
+	cache := newDNSCache(nil, time.Minute)
+	cache.entries["dual.grequests.test"] = dnsCacheEntry{
+		addrs:   []net.IPAddr{{IP: net.ParseIP("::1")}, {IP: net.ParseIP("127.0.0.1")}},
+		expires: time.Now().Add(time.Minute),
+	}
+	cache.entries["v4.grequests.test"] = dnsCacheEntry{
+		addrs:   []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}},
+		expires: time.Now().Add(time.Minute),
+	}
+
+	conn, err := cache.dial(context.Background(), &net.Dialer{}, "tcp4", "dual.grequests.test:"+s.port)
+	s.Require().NoError(err)
+	s.Equal("127.0.0.1:"+s.port, conn.RemoteAddr().String())
+	s.NoError(conn.Close())
+
+	_, err = cache.dial(context.Background(), &net.Dialer{}, "tcp6", "v4.grequests.test:"+s.port)
+	s.ErrorContains(err, "no suitable address found")
+
+	s.Len(filterAddrFamily("tcp", cache.entries["dual.grequests.test"].addrs), 2)
+	s.Equal("::1", filterAddrFamily("tcp6", cache.entries["dual.grequests.test"].addrs)[0].IP.String())
+}
+
+func (s *ResolveSuite) TestPartialDeadline() {
+	now := time.Now()
+
+	// The time left is shared between the addresses
+	s.Equal(now.Add(10*time.Second), partialDeadline(now, now.Add(30*time.Second), 3))
+	s.Equal(now.Add(30*time.Second), partialDeadline(now, now.Add(30*time.Second), 1))
+
+	// But every address gets a minimum, unless there isn't that much time left
+	s.Equal(now.Add(minDialTimeout), partialDeadline(now, now.Add(3*time.Second), 3))
+	s.Equal(now.Add(time.Second), partialDeadline(now, now.Add(time.Second), 3))
+}
+
+func (s *ResolveSuite) TestSessionRequestOptions() {
+	resolver, queries := s.dnsServer()
+
+	session := NewSession()
+	defer session.CloseIdleConnections()
+
+	resp, err := session.Get(context.Background(), "http://session.example.invalid:"+s.port,
+		ResolveOverride(map[string]string{"session.example.invalid": "127.0.0.1"}))
+	s.Require().NoError(err)
+	s.Equal("session.example.invalid:"+s.port, resp.String())
+
+	resp, err = session.Get(context.Background(), "http://session.grequests.test:"+s.port, Resolver(resolver))
+	s.Require().NoError(err)
+	s.Equal("session.grequests.test:"+s.port, resp.String())
+	s.NotZero(atomic.LoadInt32(queries))
+
+	// The session options and the request ones are merged
+	session = NewSession(Resolver(resolver))
+	defer session.CloseIdleConnections()
+
+	atomic.StoreInt32(queries, 0)
+	for i := 0; i < 2; i++ {
+		resp, err = session.Get(context.Background(), "http://cached.grequests.test:"+s.port, DNSCache(time.Minute))
+		s.Require().NoError(err)
+		s.Equal("cached.grequests.test:"+s.port, resp.String())
+	}
+	looked := atomic.LoadInt32(queries)
+	s.NotZero(looked)
+
+	resp, err = session.Get(context.Background(), "http://cached.grequests.test:"+s.port)
+	s.Require().NoError(err)
+	s.Equal(2*looked, atomic.LoadInt32(queries))
+}
+
+func TestResolveSuite(t *testing.T) {
+	suite.Run(t, new(ResolveSuite))
+}
@@ -31,9 +31,17 @@ type Response struct {
 	internalByteBuffer *bytes.Buffer
 }
 
+// NewResponse wraps an `http.Response` in a `Response`. This is useful within
+// a `Middleware` that wishes to answer a request without sending it
+func NewResponse(resp *http.Response) *Response {
+	goodResp, _ := buildResponse(resp, nil)
+	return goodResp
+}
+
 func buildResponse(resp *http.Response, err error) (*Response, error) {
 	// If the connection didn't succeed we just return a blank response
 	if err != nil {
+		err = classifyTransportError(err)
 		return &Response{Error: err}, err
 	}
 
@@ -73,7 +81,17 @@ func (r *Response) Close() error {
 	return r.RawResponse.Body.Close()
 }
 
-// DownloadToFile allows you to download the contents of the response to a file
+// discardBody drains and closes the raw body so that the connection can be reused
+func (r *Response) discardBody() {
+	if r.RawResponse == nil || r.RawResponse.Body == nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(r.RawResponse.Body, 1<<16))
+	_ = r.RawResponse.Body.Close()
+}
+
+// DownloadToFile allows you to download the contents of the response to a file.
+// Use `Download` if you need the file to be written atomically, verified or resumed
 func (r *Response) DownloadToFile(fileName string) error {
 
 	if r.Error != nil {
@@ -195,6 +213,14 @@ func (r *Response) String() string {
 	return r.internalByteBuffer.String()
 }
 
+// Proto returns the protocol of the response e.g. "HTTP/1.1" or "HTTP/2.0"
+func (r *Response) Proto() string {
+	if r.RawResponse == nil {
+		return ""
+	}
+	return r.RawResponse.Proto
+}
+
 // ClearInternalBuffer is a function that will clear the internal buffer that we use to hold the .String() and .Bytes()
 // data. Once you have used these functions – you may want to free up the memory.
 func (r *Response) ClearInternalBuffer() {
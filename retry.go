@@ -0,0 +1,414 @@
+package grequests
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Default value for RetryPolicy MaxAttempts
+	retryMaxAttempts = 3
+
+	// Default value for RetryPolicy InitialBackoff
+	retryInitialBackoff = 100 * time.Millisecond
+
+	// Default value for RetryPolicy MaxBackoff
+	retryMaxBackoff = 30 * time.Second
+
+	// Default value for RetryPolicy Multiplier
+	retryMultiplier = 2.0
+)
+
+// RetryErrorClass is a bit set of transport error classes that a
+// `RetryPolicy` considers retryable
+type RetryErrorClass int
+
+const (
+	// RetryOnDialError retries when the connection could not be established
+	RetryOnDialError RetryErrorClass = 1 << iota
+
+	// RetryOnTLSHandshakeError retries when the TLS handshake failed or timed out
+	RetryOnTLSHandshakeError
+
+	// RetryOnConnReset retries when the peer reset the connection
+	RetryOnConnReset
+
+	// RetryOnAllErrors retries on every error class listed above
+	RetryOnAllErrors = RetryOnDialError | RetryOnTLSHandshakeError | RetryOnConnReset
+)
+
+// RetryableStatusCodes is the list of HTTP status codes that are retried when
+// a `RetryPolicy` doesn't specify its own. You can change this globally by
+// modifying this variable.
+var RetryableStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy describes when and how a request should be retried. Any field
+// left at its zero value will use the package default.
+//
+// Only requests with an idempotent method (GET, HEAD, OPTIONS, TRACE, PUT and
+// DELETE) or an `Idempotency-Key` header are retried unless
+// `RetryNonIdempotent` is set: a POST that timed out may still have been
+// processed by the server.
+//
+// Requests built from `JSON`, `XML`, `Data` or a seekable `RequestBody` are
+// rewound before each attempt. A `RequestBody` that cannot be rewound is
+// never retried – the first response (or error) is returned as is. Seekable
+// readers are not closed when retries are enabled, they remain owned by the caller.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made, including the first one.
+	// The default is 3
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry. The default is 100ms
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between two attempts. The default is 30s
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor the backoff grows by after every attempt.
+	// The default is 2
+	Multiplier float64
+
+	// Jitter is the fraction (between 0 and 1) of each delay that is randomized
+	// so that clients don't retry in lockstep
+	Jitter float64
+
+	// RetryableStatusCodes is the list of HTTP status codes that will be retried.
+	// When empty the package level `RetryableStatusCodes` is used
+	RetryableStatusCodes []int
+
+	// RetryableErrors is the set of transport error classes that will be retried.
+	// When zero `RetryOnAllErrors` is used
+	RetryableErrors RetryErrorClass
+
+	// IgnoreRetryAfter disables honoring the `Retry-After` response header.
+	// When it is honored and the server asks us to wait longer than
+	// `MaxBackoff` we stop retrying and return the response
+	IgnoreRetryAfter bool
+
+	// RetryNonIdempotent retries requests with any method, POST and PATCH
+	// requests without an `Idempotency-Key` header included
+	RetryNonIdempotent bool
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts <= 0 {
+		return retryMaxAttempts
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff <= 0 {
+		return retryMaxBackoff
+	}
+	return p.MaxBackoff
+}
+
+// backoff returns the delay to wait after the given (1 based) attempt failed
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = retryInitialBackoff
+	}
+
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = retryMultiplier
+	}
+
+	delay := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if max := float64(p.maxBackoff()); delay > max {
+		delay = max
+	}
+
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		delay = delay*(1-jitter) + delay*jitter*rand.Float64()
+	}
+
+	return time.Duration(delay)
+}
+
+func (p *RetryPolicy) retryableStatus(code int) bool {
+	codes := p.RetryableStatusCodes
+	if len(codes) == 0 {
+		codes = RetryableStatusCodes
+	}
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryableRequest reports if the request may be sent more than once
+func (p *RetryPolicy) retryableRequest(req *http.Request) bool {
+	if p.RetryNonIdempotent || req.Header.Get("Idempotency-Key") != "" {
+		return true
+	}
+
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (p *RetryPolicy) retryableError(err error) bool {
+	classes := p.RetryableErrors
+	if classes == 0 {
+		classes = RetryOnAllErrors
+	}
+
+	switch {
+	case classes&RetryOnDialError != 0 && isDialError(err):
+	case classes&RetryOnTLSHandshakeError != 0 && isTLSHandshakeError(err):
+	case classes&RetryOnConnReset != 0 && ClassifyError(err) == KindConnReset:
+	default:
+		return false
+	}
+	return true
+}
+
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func isTLSHandshakeError(err error) bool {
+	var headerErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	switch {
+	case errors.As(err, &headerErr):
+	case errors.As(err, &alertErr):
+	// net/http doesn't export its handshake timeout error
+	case strings.Contains(err.Error(), "TLS handshake timeout"):
+	default:
+		return false
+	}
+	return true
+}
+
+// retryAfter parses the `Retry-After` header which is either a number of
+// seconds or an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	if delay := time.Until(date); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+// prepareRequestBodyForRetry makes sure that a request body can be replayed.
+// Bodies created by `http.NewRequest` from an in memory reader already
+// have `GetBody` set, seekable readers are rewound to their original offset.
+// The returned request has to be passed to finishRequestBody once it has
+// been sent for the last time.
+func prepareRequestBodyForRetry(req *http.Request, body io.Reader) *http.Request {
+	if req.GetBody != nil || req.Body == nil || req.Body == http.NoBody {
+		return req
+	}
+
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return req
+	}
+
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		// Not really seekable (e.g. a pipe) – treat as a one-shot reader
+		return req
+	}
+
+	shared := &sharedBody{Reader: body}
+	shared.closer, _ = body.(io.Closer)
+
+	req.Body = shared.open()
+	req.GetBody = func() (io.ReadCloser, error) {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return shared.open(), nil
+	}
+
+	return req.WithContext(context.WithValue(req.Context(), sharedBodyKey{}, shared))
+}
+
+// sharedBody is a request body read again by every attempt. The transport
+// closes the body of every attempt, the reader is only closed once the
+// request is finished and the body of the last attempt has been closed.
+type sharedBody struct {
+	io.Reader
+
+	mu       sync.Mutex
+	closer   io.Closer
+	bodies   int
+	finished bool
+}
+
+type sharedBodyKey struct{}
+
+func (b *sharedBody) open() io.ReadCloser {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bodies++
+	return &sharedBodyReader{sharedBody: b}
+}
+
+// release is called when a body is closed (release(false)) and when the
+// request is finished (release(true))
+func (b *sharedBody) release(finished bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if finished {
+		b.finished = true
+	} else {
+		b.bodies--
+	}
+
+	if b.finished && b.bodies == 0 && b.closer != nil {
+		_ = b.closer.Close()
+		b.closer = nil
+	}
+}
+
+type sharedBodyReader struct {
+	*sharedBody
+	once sync.Once
+}
+
+func (r *sharedBodyReader) Close() error {
+	r.once.Do(func() { r.release(false) })
+	return nil
+}
+
+// finishRequestBody closes the body prepared by prepareRequestBodyForRetry
+// (if any) once the transport is done with it, as no attempt is left
+func finishRequestBody(req *http.Request) {
+	if shared, ok := req.Context().Value(sharedBodyKey{}).(*sharedBody); ok {
+		shared.release(true)
+	}
+}
+
+// do sends the request using the client, retrying according to the policy.
+// Retries are authenticated again with auth (when there is one) as digests
+// and signatures can't be replayed
+func (p *RetryPolicy) do(client *http.Client, req *http.Request, auth Authenticator) (*http.Response, error) {
+	ctx := req.Context()
+
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+
+		if attempt >= p.maxAttempts() || ctx.Err() != nil || !p.retryableRequest(req) {
+			return resp, err
+		}
+
+		if err != nil && !p.retryableError(err) {
+			return resp, err
+		}
+
+		if err == nil && !p.retryableStatus(resp.StatusCode) {
+			return resp, err
+		}
+
+		// We cannot replay a body that has already been consumed
+		if !canReplay(req) {
+			return resp, err
+		}
+
+		delay := p.backoff(attempt)
+		if resp != nil && !p.IgnoreRetryAfter {
+			if wait, ok := retryAfter(resp); ok {
+				if wait > p.maxBackoff() {
+					return resp, err
+				}
+				delay = wait
+			}
+		}
+
+		if resp != nil {
+			drainBody(resp)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		if req, err = cloneRequest(ctx, req); err != nil {
+			return nil, err
+		}
+
+		if auth != nil {
+			if err := auth.Authenticate(req); err != nil {
+				closeRequestBody(req)
+				return nil, err
+			}
+		}
+	}
+}
+
+// canReplay reports whether the request can be sent again
+func canReplay(req *http.Request) bool {
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	return !hasBody || req.GetBody != nil
+}
+
+// closeRequestBody closes the body of a request that won't be sent, as the
+// transport would have done
+func closeRequestBody(req *http.Request) {
+	if hasBody(req) {
+		_ = req.Body.Close()
+	}
+}
+
+// cloneRequest returns a copy of the request with a fresh copy of the body
+func cloneRequest(ctx context.Context, req *http.Request) (*http.Request, error) {
+	next := req.Clone(ctx)
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		next.Body = body
+	}
+	return next, nil
+}
@@ -0,0 +1,285 @@
+package grequests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RetrySuite struct {
+	suite.Suite
+}
+
+var fastRetry = RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+// newFlakyServer returns a server that fails with the status code until it has been called `failures` times
+func newFlakyServer(failures int32, status int, hits *int32, bodies chan<- string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if bodies != nil {
+			bodies <- string(b)
+		}
+		if atomic.AddInt32(hits, 1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func (s *RetrySuite) TestRetryOnStatus() {
+	var hits int32
+	srv := newFlakyServer(2, http.StatusServiceUnavailable, &hits, nil)
+	defer srv.Close()
+
+	resp, err := Get(context.Background(), srv.URL, Retry(fastRetry))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	s.Equal(int32(3), atomic.LoadInt32(&hits))
+}
+
+func (s *RetrySuite) TestRetryGivesUp() {
+	var hits int32
+	srv := newFlakyServer(10, http.StatusBadGateway, &hits, nil)
+	defer srv.Close()
+
+	policy := fastRetry
+	policy.MaxAttempts = 4
+	resp, err := Get(context.Background(), srv.URL, Retry(policy))
+	s.Require().NoError(err)
+	s.Equal(http.StatusBadGateway, resp.StatusCode)
+	s.Equal(int32(4), atomic.LoadInt32(&hits))
+}
+
+func (s *RetrySuite) TestNoRetryOnUnlistedStatus() {
+	var hits int32
+	srv := newFlakyServer(10, http.StatusInternalServerError, &hits, nil)
+	defer srv.Close()
+
+	resp, err := Get(context.Background(), srv.URL, Retry(fastRetry))
+	s.Require().NoError(err)
+	s.Equal(http.StatusInternalServerError, resp.StatusCode)
+	s.Equal(int32(1), atomic.LoadInt32(&hits))
+}
+
+func (s *RetrySuite) TestRetryRewindsBodies() {
+	opts := map[string]Option{
+		"json": JSON(map[string]string{"a": "b"}),
+		"xml":  XML("<a>b</a>"),
+		"data": FromRequestOptions(&RequestOptions{Data: map[string]string{"a": "b"}}),
+	}
+	for name, opt := range opts {
+		var hits int32
+		bodies := make(chan string, 3)
+		srv := newFlakyServer(2, http.StatusTooManyRequests, &hits, bodies)
+
+		resp, err := Post(context.Background(), srv.URL, opt, withHeaders(map[string]string{"Idempotency-Key": name}), Retry(fastRetry))
+		srv.Close()
+		s.Require().NoError(err, name)
+		s.True(resp.Ok, name)
+
+		first := <-bodies
+		s.NotEmpty(first, name)
+		s.Equal(first, <-bodies, name)
+		s.Equal(first, <-bodies, name)
+	}
+}
+
+func (s *RetrySuite) TestRetrySeekableRequestBody() {
+	var hits int32
+	bodies := make(chan string, 3)
+	srv := newFlakyServer(2, http.StatusServiceUnavailable, &hits, bodies)
+	defer srv.Close()
+
+	fd, err := os.Open("testdata/mypassword")
+	s.Require().NoError(err)
+	defer func() { _ = fd.Close() }()
+
+	resp, err := Put(context.Background(), srv.URL, RequestBody(fd), Retry(fastRetry))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+
+	first := <-bodies
+	s.NotEmpty(first)
+	s.Equal(first, <-bodies)
+	s.Equal(first, <-bodies)
+}
+
+// closableSeeker counts how many times it is closed
+type closableSeeker struct {
+	*strings.Reader
+	closed int32
+}
+
+func (c *closableSeeker) Close() error {
+	atomic.AddInt32(&c.closed, 1)
+	return nil
+}
+
+func (s *RetrySuite) TestRetryClosesSeekableRequestBody() {
+	var hits int32
+	bodies := make(chan string, 3)
+	srv := newFlakyServer(2, http.StatusServiceUnavailable, &hits, bodies)
+	defer srv.Close()
+
+	body := &closableSeeker{Reader: strings.NewReader("seekable")}
+	resp, err := Put(context.Background(), srv.URL, RequestBody(body), Retry(fastRetry))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	s.Equal(int32(3), atomic.LoadInt32(&hits))
+
+	// The body is closed once, after the last attempt (which the transport
+	// may still be closing)
+	for i := 0; i < 3; i++ {
+		s.Equal("seekable", <-bodies)
+	}
+	s.Eventually(func() bool { return atomic.LoadInt32(&body.closed) == 1 }, time.Second, time.Millisecond)
+	s.Equal(int32(1), atomic.LoadInt32(&body.closed))
+
+	// Files too
+	fd, err := os.Open("testdata/mypassword")
+	s.Require().NoError(err)
+	resp, err = Put(context.Background(), srv.URL, RequestBody(fd), Retry(fastRetry))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	<-bodies
+	s.Eventually(func() bool {
+		_, err := fd.Stat()
+		return errors.Is(err, os.ErrClosed)
+	}, time.Second, time.Millisecond)
+}
+
+func (s *RetrySuite) TestNoRetryOneShotRequestBody() {
+	var hits int32
+	srv := newFlakyServer(2, http.StatusServiceUnavailable, &hits, nil)
+	defer srv.Close()
+
+	body := io.MultiReader(strings.NewReader("one shot"))
+	resp, err := Put(context.Background(), srv.URL, RequestBody(body), Retry(fastRetry))
+	s.Require().NoError(err)
+	s.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	s.Equal(int32(1), atomic.LoadInt32(&hits))
+}
+
+func (s *RetrySuite) TestRetryOnlyIdempotentRequests() {
+	nonIdempotent := fastRetry
+	nonIdempotent.RetryNonIdempotent = true
+
+	tests := []struct {
+		send    func(context.Context, string, ...Option) (*Response, error)
+		options []Option
+		hits    int32
+	}{
+		{Post, []Option{Retry(fastRetry)}, 1},
+		{Patch, []Option{Retry(fastRetry)}, 1},
+		{Post, []Option{Retry(fastRetry), withHeaders(map[string]string{"Idempotency-Key": "k"})}, 3},
+		{Post, []Option{Retry(nonIdempotent)}, 3},
+		{Put, []Option{Retry(fastRetry)}, 3},
+		{Delete, []Option{Retry(fastRetry)}, 3},
+		{Head, []Option{Retry(fastRetry)}, 3},
+	}
+	for i, test := range tests {
+		var hits int32
+		srv := newFlakyServer(2, http.StatusServiceUnavailable, &hits, nil)
+		_, err := test.send(context.Background(), srv.URL, test.options...)
+		srv.Close()
+		s.Require().NoError(err, i)
+		s.Equal(test.hits, atomic.LoadInt32(&hits), i)
+	}
+}
+
+func (s *RetrySuite) TestRetryAfter() {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	// The server wants us to wait longer than MaxBackoff – give up right away
+	resp, err := Get(context.Background(), srv.URL, Retry(fastRetry))
+	s.Require().NoError(err)
+	s.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	s.Equal(int32(1), atomic.LoadInt32(&hits))
+}
+
+func (s *RetrySuite) TestParseRetryAfter() {
+	resp := &http.Response{Header: http.Header{}}
+	_, ok := retryAfter(resp)
+	s.False(ok)
+
+	resp.Header.Set("Retry-After", "5")
+	d, ok := retryAfter(resp)
+	s.True(ok)
+	s.Equal(5*time.Second, d)
+
+	resp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	d, ok = retryAfter(resp)
+	s.True(ok)
+	s.True(d > 59*time.Minute)
+
+	resp.Header.Set("Retry-After", "soon")
+	_, ok = retryAfter(resp)
+	s.False(ok)
+}
+
+func (s *RetrySuite) TestRetryDialError() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	addr := l.Addr().String()
+	s.Require().NoError(l.Close())
+
+	_, err = Get(context.Background(), "http://"+addr, Retry(fastRetry))
+	s.Error(err)
+	s.True(isDialError(err))
+
+	policy := &RetryPolicy{RetryableErrors: RetryOnDialError}
+	s.True(policy.retryableError(err))
+	policy.RetryableErrors = RetryOnConnReset
+	s.False(policy.retryableError(err))
+}
+
+func (s *RetrySuite) TestBackoff() {
+	p := &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	s.Equal(time.Second, p.backoff(1))
+	s.Equal(2*time.Second, p.backoff(2))
+	s.Equal(4*time.Second, p.backoff(3))
+	s.Equal(5*time.Second, p.backoff(4))
+
+	p.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		d := p.backoff(2)
+		s.True(d >= time.Second && d <= 2*time.Second, d)
+	}
+}
+
+func (s *RetrySuite) TestRetryContextCancelled() {
+	var hits int32
+	srv := newFlakyServer(10, http.StatusServiceUnavailable, &hits, nil)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	policy := RetryPolicy{MaxAttempts: 100, InitialBackoff: time.Second}
+	_, err := Get(ctx, srv.URL, Retry(policy))
+	s.ErrorIs(err, context.DeadlineExceeded)
+}
+
+func TestRetrySuite(t *testing.T) {
+	suite.Run(t, new(RetrySuite))
+}
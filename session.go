@@ -2,166 +2,295 @@ package grequests
 
 import (
 	"context"
+	"crypto/tls"
 	"net/http"
 )
 
 // Session allows a user to make use of persistent cookies in between
 // HTTP requests
+//
+// A Session is safe for concurrent use by multiple goroutines. Every request
+// works on its own merged copy of the session and request options – neither
+// of them is ever modified – and the redirect policy is evaluated against the
+// options of the request being redirected. The session options and client
+// should not be modified once the session is in use.
 type Session struct {
 	// RequestOptions is global options
 	RequestOptions *RequestOptions
 
 	// HTTPClient is the client that we will use to request the resources
 	HTTPClient *http.Client
+
+	// clients are the clients built for the requests with transport options
+	// of their own that can't be shared with other sessions
+	clients *clientPool
 }
 
 // NewSession returns a session struct which enables can be used to maintain establish a persistent state with the
 // server
+// The options provided will be used as the defaults for every request made with the session
+// (except for the body: Data, Files, JSON, XML and RequestBody are only taken from the request).
 // This function will set UseCookieJar to true as that is the purpose of using the session
-func NewSession(ro *RequestOptions) *Session {
-	if ro == nil {
-		ro = &RequestOptions{}
-	}
+func NewSession(options ...Option) *Session {
+	ro := &RequestOptions{}
+	applyOptions(ro, options)
 
 	ro.UseCookieJar = true
 
-	return &Session{RequestOptions: ro, HTTPClient: BuildHTTPClient(*ro)}
+	return &Session{RequestOptions: ro, HTTPClient: BuildHTTPClient(*ro), clients: newClientPool()}
 }
 
-// Combine session options and request options
-// 1. UserAgent
-// 2. Host
-// 3. Auth
-// 4. Headers
+// combineRequestOptions merges the session options with the request options and
+// returns the result as a new struct. The request options take precedence
+// over the session options:
+//  1. Maps (Params, Headers, Proxies, SensitiveHTTPHeaders, PinnedPublicKeys, ResolveOverrides,
+//     ProxyConnectHeaders) are merged
+//  2. Cookies, AfterResponse hooks, Middleware, CredentialProviders, ClientCertificates, RootCAFiles
+//     TLSConfig functions and NoProxy are appended to the session ones
+//  3. Flags are set if they are set on either side
+//  4. BeforeRequest hooks are chained – the session hook runs first
+//  5. Any other field is taken from the request if it is set and from the session otherwise
+//
+// The body (Data, Files, JSON, XML and RequestBody) is only taken from the
+// request: a body set on the session is ignored as readers and files can't be
+// sent more than once.
+//
+// Requests that change the transport (TLS, proxies, dialing, HTTP/2...) are
+// sent with a client built for the merged options rather than the session
+// client, the session cookies are still used.
 func (s *Session) combineRequestOptions(ro *RequestOptions) *RequestOptions {
 	if ro == nil {
 		ro = &RequestOptions{}
 	}
 
-	if ro.UserAgent == "" && s.RequestOptions.UserAgent != "" {
-		ro.UserAgent = s.RequestOptions.UserAgent
+	base := s.RequestOptions
+	if base == nil {
+		base = &RequestOptions{}
 	}
 
-	if ro.Host == "" && s.RequestOptions.Host != "" {
-		ro.Host = s.RequestOptions.Host
+	return &RequestOptions{
+		Data:                 ro.Data,
+		Params:               mergeMaps(base.Params, ro.Params),
+		QueryStruct:          firstNonZero(ro.QueryStruct, base.QueryStruct),
+		Files:                ro.Files,
+		JSON:                 ro.JSON,
+		XML:                  ro.XML,
+		Headers:              mergeMaps(base.Headers, ro.Headers),
+		InsecureSkipVerify:   base.InsecureSkipVerify || ro.InsecureSkipVerify,
+		DisableCompression:   base.DisableCompression || ro.DisableCompression,
+		UserAgent:            firstNonZero(ro.UserAgent, base.UserAgent),
+		Host:                 firstNonZero(ro.Host, base.Host),
+		Auth:                 firstNonNilSlice(ro.Auth, base.Auth),
+		IsAjax:               base.IsAjax || ro.IsAjax,
+		Cookies:              append(append([]*http.Cookie(nil), base.Cookies...), ro.Cookies...),
+		UseCookieJar:         base.UseCookieJar || ro.UseCookieJar,
+		Proxies:              mergeMaps(base.Proxies, ro.Proxies),
+		TLSHandshakeTimeout:  firstNonZero(ro.TLSHandshakeTimeout, base.TLSHandshakeTimeout),
+		DialTimeout:          firstNonZero(ro.DialTimeout, base.DialTimeout),
+		DialKeepAlive:        firstNonZero(ro.DialKeepAlive, base.DialKeepAlive),
+		RequestTimeout:       firstNonZero(ro.RequestTimeout, base.RequestTimeout),
+		HTTPClient:           firstNonZero(ro.HTTPClient, base.HTTPClient),
+		SensitiveHTTPHeaders: mergeMaps(base.SensitiveHTTPHeaders, ro.SensitiveHTTPHeaders),
+		RedirectLimit:        firstNonZero(ro.RedirectLimit, base.RedirectLimit),
+		RequestBody:          ro.RequestBody,
+		CookieJar:            firstNonZero(ro.CookieJar, base.CookieJar),
+		Context:              firstNonZero(ro.Context, base.Context),
+		BeforeRequest:        chainBeforeRequest(base.BeforeRequest, ro.BeforeRequest),
+		LocalAddr:            firstNonZero(ro.LocalAddr, base.LocalAddr),
+		RetryPolicy:          firstNonZero(ro.RetryPolicy, base.RetryPolicy),
+		ErrorOnStatus:        base.ErrorOnStatus || ro.ErrorOnStatus,
+		AfterResponse:        append(append([]func(*Response) error(nil), base.AfterResponse...), ro.AfterResponse...),
+		Middleware:           append(append([]Middleware(nil), base.Middleware...), ro.Middleware...),
+		MaxBodySize:          firstNonZero(ro.MaxBodySize, base.MaxBodySize),
+		UploadProgress:       firstProgressFunc(ro.UploadProgress, base.UploadProgress),
+		DownloadProgress:     firstProgressFunc(ro.DownloadProgress, base.DownloadProgress),
+		ProgressInterval:     firstNonZero(ro.ProgressInterval, base.ProgressInterval),
+		ProgressBytes:        firstNonZero(ro.ProgressBytes, base.ProgressBytes),
+		ExpectedSHA256:       firstNonZero(ro.ExpectedSHA256, base.ExpectedSHA256),
+		DownloadSegments:     firstNonZero(ro.DownloadSegments, base.DownloadSegments),
+		Authenticator:        mergeAuthenticator(ro, base),
+		CredentialProviders:  append(append([]CredentialProvider(nil), base.CredentialProviders...), ro.CredentialProviders...),
+		ClientCertificates:   append(append([]TLSCertificate(nil), base.ClientCertificates...), ro.ClientCertificates...),
+		RootCAs:              firstNonZero(ro.RootCAs, base.RootCAs),
+		RootCAFiles:          append(append([]string(nil), base.RootCAFiles...), ro.RootCAFiles...),
+		TLSMinVersion:        firstNonZero(ro.TLSMinVersion, base.TLSMinVersion),
+		TLSServerName:        firstNonZero(ro.TLSServerName, base.TLSServerName),
+		TLSConfig:            append(append(([]func(*tls.Config))(nil), base.TLSConfig...), ro.TLSConfig...),
+		PinnedPublicKeys:     mergeMaps(base.PinnedPublicKeys, ro.PinnedPublicKeys),
+		PinMismatchReporter:  firstPinMismatchReporter(ro.PinMismatchReporter, base.PinMismatchReporter),
+		UnixSocket:           firstNonZero(ro.UnixSocket, base.UnixSocket),
+		DialContext:          firstDialContext(ro.DialContext, base.DialContext),
+		ResolveOverrides:     mergeMaps(base.ResolveOverrides, ro.ResolveOverrides),
+		Resolver:             firstNonZero(ro.Resolver, base.Resolver),
+		DNSCacheTTL:          firstNonZero(ro.DNSCacheTTL, base.DNSCacheTTL),
+		ProxyConnectHeaders:  mergeMaps(base.ProxyConnectHeaders, ro.ProxyConnectHeaders),
+		NoProxy:              append(append([]string(nil), base.NoProxy...), ro.NoProxy...),
+		ProxyAutoConfig:      firstNonZero(ro.ProxyAutoConfig, base.ProxyAutoConfig),
+		ForceHTTP2:           ro.ForceHTTP2 || base.ForceHTTP2,
+		DisableHTTP2:         ro.DisableHTTP2 || base.DisableHTTP2,
+		H2C:                  ro.H2C || base.H2C,
+		HTTP2ReadIdleTimeout: firstNonZero(ro.HTTP2ReadIdleTimeout, base.HTTP2ReadIdleTimeout),
+		HTTP2PingTimeout:     firstNonZero(ro.HTTP2PingTimeout, base.HTTP2PingTimeout),
 	}
+}
 
-	if ro.Auth == nil && s.RequestOptions.Auth != nil {
-		ro.Auth = s.RequestOptions.Auth
+// Request takes 3 parameters and returns a Response Struct. These three options are:
+//  1. A verb
+//  2. A URL
+//  3. A set of options for the request
+//
+// The options are merged with the options the session was created with
+func (s *Session) Request(ctx context.Context, verb, url string, options ...Option) (*Response, error) {
+	requestOptions := &RequestOptions{}
+	applyOptions(requestOptions, options)
+
+	ro := s.combineRequestOptions(requestOptions)
+	if ctx != nil {
+		ro.Context = ctx
 	}
+	return doSessionRequest(verb, url, ro, s.requestClient(requestOptions, ro))
+}
 
-	if len(s.RequestOptions.Headers) > 0 || len(ro.Headers) > 0 {
-		headers := make(map[string]string)
-		for k, v := range s.RequestOptions.Headers {
-			headers[k] = v
-		}
-		for k, v := range ro.Headers {
-			headers[k] = v
-		}
-		ro.Headers = headers
+// requestClient returns the client to send a request with. The session
+// client is built for the session options only, requests with transport
+// options of their own need a client built for the merged options ro.
+//
+// The session `TLSConfig`, `PinMismatchReporter` and `DialContext` functions
+// don't change from one request to the other: the clients built with them
+// are kept by the session as long as the request doesn't set its own.
+func (s *Session) requestClient(requestOptions, ro *RequestOptions) *http.Client {
+	if !requestOptions.customTransport() || ro.HTTPClient != nil {
+		return s.HTTPClient
 	}
-	return ro
+
+	var httpClient *http.Client
+	if !ro.poolableTransport() && requestOptions.poolableTransport() && s.clients != nil {
+		httpClient = s.clients.client(*ro)
+	} else {
+		httpClient = pooledHTTPClient(*ro)
+	}
+
+	httpClient.Jar = s.HTTPClient.Jar
+	return httpClient
 }
 
 // Get takes 2 parameters and returns a Response Struct. These two options are:
 //  1. A URL
-//  2. A RequestOptions struct
+//  2. A set of options for the request
 //
-// If you do not intend to use the `RequestOptions` you can just pass nil
-// A new session is created by calling NewSession with a request options struct
-func (s *Session) Get(ctx context.Context, url string, ro *RequestOptions) (*Response, error) {
-	ro = s.combineRequestOptions(ro)
-	if ctx != nil {
-		ro.Context = ctx
-	}
-	return doSessionRequest("GET", url, ro, s.HTTPClient)
+// The options are merged with the options the session was created with
+func (s *Session) Get(ctx context.Context, url string, options ...Option) (*Response, error) {
+	return s.Request(ctx, "GET", url, options...)
 }
 
 // Put takes 2 parameters and returns a Response struct. These two options are:
 //  1. A URL
-//  2. A RequestOptions struct
+//  2. A set of options for the request
 //
-// If you do not intend to use the `RequestOptions` you can just pass nil
-// A new session is created by calling NewSession with a request options struct
-func (s *Session) Put(ctx context.Context, url string, ro *RequestOptions) (*Response, error) {
-	ro = s.combineRequestOptions(ro)
-	if ctx != nil {
-		ro.Context = ctx
-	}
-	return doSessionRequest("PUT", url, ro, s.HTTPClient)
+// The options are merged with the options the session was created with
+func (s *Session) Put(ctx context.Context, url string, options ...Option) (*Response, error) {
+	return s.Request(ctx, "PUT", url, options...)
 }
 
 // Patch takes 2 parameters and returns a Response struct. These two options are:
 //  1. A URL
-//  2. A RequestOptions struct
+//  2. A set of options for the request
 //
-// If you do not intend to use the `RequestOptions` you can just pass nil
-// A new session is created by calling NewSession with a request options struct
-func (s *Session) Patch(ctx context.Context, url string, ro *RequestOptions) (*Response, error) {
-	ro = s.combineRequestOptions(ro)
-	if ctx != nil {
-		ro.Context = ctx
-	}
-	return doSessionRequest("PATCH", url, ro, s.HTTPClient)
+// The options are merged with the options the session was created with
+func (s *Session) Patch(ctx context.Context, url string, options ...Option) (*Response, error) {
+	return s.Request(ctx, "PATCH", url, options...)
 }
 
 // Delete takes 2 parameters and returns a Response struct. These two options are:
 //  1. A URL
-//  2. A RequestOptions struct
+//  2. A set of options for the request
 //
-// If you do not intend to use the `RequestOptions` you can just pass nil
-// A new session is created by calling NewSession with a request options struct
-func (s *Session) Delete(ctx context.Context, url string, ro *RequestOptions) (*Response, error) {
-	ro = s.combineRequestOptions(ro)
-	if ctx != nil {
-		ro.Context = ctx
-	}
-	return doSessionRequest("DELETE", url, ro, s.HTTPClient)
+// The options are merged with the options the session was created with
+func (s *Session) Delete(ctx context.Context, url string, options ...Option) (*Response, error) {
+	return s.Request(ctx, "DELETE", url, options...)
 }
 
 // Post takes 2 parameters and returns a Response channel. These two options are:
 //  1. A URL
-//  2. A RequestOptions struct
+//  2. A set of options for the request
 //
-// If you do not intend to use the `RequestOptions` you can just pass nil
-// A new session is created by calling NewSession with a request options struct
-func (s *Session) Post(ctx context.Context, url string, ro *RequestOptions) (*Response, error) {
-	ro = s.combineRequestOptions(ro)
-	if ctx != nil {
-		ro.Context = ctx
-	}
-	return doSessionRequest("POST", url, ro, s.HTTPClient)
+// The options are merged with the options the session was created with
+func (s *Session) Post(ctx context.Context, url string, options ...Option) (*Response, error) {
+	return s.Request(ctx, "POST", url, options...)
 }
 
 // Head takes 2 parameters and returns a Response channel. These two options are:
 //  1. A URL
-//  2. A RequestOptions struct
+//  2. A set of options for the request
 //
-// If you do not intend to use the `RequestOptions` you can just pass nil
-// A new session is created by calling NewSession with a request options struct
-func (s *Session) Head(ctx context.Context, url string, ro *RequestOptions) (*Response, error) {
-	ro = s.combineRequestOptions(ro)
-	if ctx != nil {
-		ro.Context = ctx
-	}
-	return doSessionRequest("HEAD", url, ro, s.HTTPClient)
+// The options are merged with the options the session was created with
+func (s *Session) Head(ctx context.Context, url string, options ...Option) (*Response, error) {
+	return s.Request(ctx, "HEAD", url, options...)
 }
 
 // Options takes 2 parameters and returns a Response struct. These two options are:
 //  1. A URL
-//  2. A RequestOptions struct
+//  2. A set of options for the request
 //
-// If you do not intend to use the `RequestOptions` you can just pass nil
-// A new session is created by calling NewSession with a request options struct
-func (s *Session) Options(ctx context.Context, url string, ro *RequestOptions) (*Response, error) {
-	ro = s.combineRequestOptions(ro)
-	if ctx != nil {
-		ro.Context = ctx
-	}
-	return doSessionRequest("OPTIONS", url, ro, s.HTTPClient)
+// The options are merged with the options the session was created with
+func (s *Session) Options(ctx context.Context, url string, options ...Option) (*Response, error) {
+	return s.Request(ctx, "OPTIONS", url, options...)
 }
 
 // CloseIdleConnections closes the idle connections that a session client may make use of
 func (s *Session) CloseIdleConnections() {
-	s.HTTPClient.Transport.(*http.Transport).CloseIdleConnections()
+	s.HTTPClient.CloseIdleConnections()
+
+	if s.clients != nil {
+		s.clients.closeIdleConnections()
+	}
+}
+
+func firstNonZero[T comparable](values ...T) T {
+	var zero T
+	for _, v := range values {
+		if v != zero {
+			return v
+		}
+	}
+	return zero
+}
+
+func firstNonNilSlice[T any](values ...[]T) []T {
+	for _, v := range values {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+func mergeMaps[K comparable, V any](base, override map[K]V) map[K]V {
+	if len(base) == 0 && len(override) == 0 {
+		return override
+	}
+
+	merged := make(map[K]V, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+func chainBeforeRequest(first, second func(req *http.Request) error) func(req *http.Request) error {
+	if first == nil {
+		return second
+	}
+	if second == nil {
+		return first
+	}
+	return func(req *http.Request) error {
+		if err := first(req); err != nil {
+			return err
+		}
+		return second(req)
+	}
 }
@@ -0,0 +1,311 @@
+package grequests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SessionOptionsSuite struct {
+	suite.Suite
+}
+
+// newEchoServer returns a server that responds with the request headers, query and cookies
+func newEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookies := map[string]string{}
+		for _, c := range r.Cookies() {
+			cookies[c.Name] = c.Value
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"headers": r.Header,
+			"query":   r.URL.Query(),
+			"cookies": cookies,
+		})
+	}))
+}
+
+type echoResponse struct {
+	Headers http.Header         `json:"headers"`
+	Query   map[string][]string `json:"query"`
+	Cookies map[string]string   `json:"cookies"`
+}
+
+func (s *SessionOptionsSuite) TestSessionOptions() {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	session := NewSession(
+		FromRequestOptions(&RequestOptions{
+			Params:  map[string]string{"a": "1", "b": "1"},
+			Headers: map[string]string{"X-Session": "1"},
+		}),
+		UserAgent("session-agent"),
+		BasicAuth("user", "pass"),
+		Cookies([]*http.Cookie{{Name: "session", Value: "1"}}),
+	)
+
+	resp, err := session.Get(context.Background(), srv.URL,
+		FromRequestOptions(&RequestOptions{Params: map[string]string{"b": "2"}}),
+		Cookies([]*http.Cookie{{Name: "request", Value: "2"}}),
+	)
+	s.Require().NoError(err)
+
+	var echo echoResponse
+	s.Require().NoError(resp.JSON(&echo))
+	s.Equal("session-agent", echo.Headers.Get("User-Agent"))
+	s.Equal("1", echo.Headers.Get("X-Session"))
+	s.Contains(echo.Headers.Get("Authorization"), "Basic")
+	s.Equal([]string{"1"}, echo.Query["a"])
+	s.Equal([]string{"2"}, echo.Query["b"])
+	s.Equal("1", echo.Cookies["session"])
+	s.Equal("2", echo.Cookies["request"])
+}
+
+func (s *SessionOptionsSuite) TestRequestOptionsTakePrecedence() {
+	sessionHook := func(req *http.Request) error { return nil }
+	session := NewSession(
+		UserAgent("session"),
+		DialTimeout(time.Second),
+		RequestTimeout(time.Second),
+		RedirectLimit(5),
+		BasicAuth("session", "pass"),
+	)
+	session.RequestOptions.BeforeRequest = sessionHook
+
+	ro := &RequestOptions{}
+	applyOptions(ro, []Option{
+		UserAgent("request"),
+		RequestTimeout(2 * time.Second),
+		RedirectLimit(-1),
+		BasicAuth("request", "pass"),
+		JSON("{}"),
+	})
+	out := session.combineRequestOptions(ro)
+
+	s.Equal("request", out.UserAgent)
+	s.Equal(time.Second, out.DialTimeout)
+	s.Equal(2*time.Second, out.RequestTimeout)
+	s.Equal(-1, out.RedirectLimit)
+	s.Equal([]string{"request", "pass"}, out.Auth)
+	s.Equal("{}", out.JSON)
+	s.True(out.UseCookieJar)
+	s.NotNil(out.BeforeRequest)
+}
+
+func (s *SessionOptionsSuite) TestBodyIsNotInherited() {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	session := NewSession(
+		FromRequestOptions(&RequestOptions{
+			Data:        map[string]string{"session": "1"},
+			XML:         struct{ Session string }{"1"},
+			RequestBody: strings.NewReader("session"),
+			Files:       []FileUpload{{FileName: "session.txt", FileContents: io.NopCloser(strings.NewReader("session"))}},
+		}),
+		JSON(map[string]string{"session": "1"}),
+	)
+	s.Require().NotNil(session.RequestOptions.JSON)
+
+	ro := &RequestOptions{}
+	applyOptions(ro, []Option{FromRequestOptions(&RequestOptions{Data: map[string]string{"request": "2"}})})
+	out := session.combineRequestOptions(ro)
+	s.Equal(map[string]string{"request": "2"}, out.Data)
+	s.Nil(out.JSON)
+	s.Nil(out.XML)
+	s.Nil(out.RequestBody)
+	s.Nil(out.Files)
+
+	for i := 0; i < 2; i++ {
+		resp, err := session.Get(context.Background(), srv.URL)
+		s.Require().NoError(err)
+
+		var echo echoResponse
+		s.Require().NoError(resp.JSON(&echo))
+		s.Empty(echo.Headers.Get("Content-Type"))
+	}
+}
+
+func (s *SessionOptionsSuite) TestMergeSensitiveHeaders() {
+	session := NewSession(SensitiveHTTPHeaders("X-Session-Secret"))
+	ro := &RequestOptions{}
+	SensitiveHTTPHeaders("X-Request-Secret").Apply(ro)
+
+	out := session.combineRequestOptions(ro)
+	s.Contains(out.SensitiveHTTPHeaders, "X-Session-Secret")
+	s.Contains(out.SensitiveHTTPHeaders, "X-Request-Secret")
+}
+
+func (s *SessionOptionsSuite) TestBeforeRequestChain() {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	var calls []string
+	session := NewSession(BeforeRequest(func(req *http.Request) error {
+		calls = append(calls, "session")
+		req.Header.Set("X-Hook", "session")
+		return nil
+	}))
+
+	resp, err := session.Get(context.Background(), srv.URL, BeforeRequest(func(req *http.Request) error {
+		calls = append(calls, "request")
+		s.Equal("session", req.Header.Get("X-Hook"))
+		return nil
+	}))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	s.Equal([]string{"session", "request"}, calls)
+
+	// The session hook on its own is still run
+	calls = nil
+	_, err = session.Get(context.Background(), srv.URL)
+	s.Require().NoError(err)
+	s.Equal([]string{"session"}, calls)
+}
+
+func (s *SessionOptionsSuite) TestNilOptionsAreIgnored() {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	session := NewSession(nil)
+	resp, err := session.Get(context.Background(), srv.URL, nil)
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+}
+
+func TestSessionOptionsSuite(t *testing.T) {
+	suite.Run(t, new(SessionOptionsSuite))
+}
+
+type SessionConcurrencySuite struct {
+	suite.Suite
+}
+
+// newRedirectServer redirects /{n} to /{n-1} and echoes the X-Secret header at /0.
+// Every redirect switches between the 127.0.0.1 and localhost host names.
+func newRedirectServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if n > 0 {
+			host, port, _ := net.SplitHostPort(r.Host)
+			if host == "localhost" {
+				host = "127.0.0.1"
+			} else {
+				host = "localhost"
+			}
+			http.Redirect(w, r, "http://"+net.JoinHostPort(host, port)+"/"+strconv.Itoa(n-1), http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get("X-Secret")))
+	}))
+}
+
+func (s *SessionConcurrencySuite) TestPerRequestRedirectPolicy() {
+	srv := newRedirectServer()
+	defer srv.Close()
+
+	session := NewSession(FromRequestOptions(&RequestOptions{Headers: map[string]string{"X-Secret": "s3cr3t"}}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			switch i % 3 {
+			case 0:
+				_, err := session.Get(context.Background(), srv.URL+"/3", RedirectLimit(2))
+				s.ErrorIs(err, ErrRedirectLimitExceeded)
+			case 1:
+				resp, err := session.Get(context.Background(), srv.URL+"/3", RedirectLimit(10))
+				if s.NoError(err) {
+					s.Equal("s3cr3t", resp.String())
+				}
+			case 2:
+				resp, err := session.Get(context.Background(), srv.URL+"/3", SensitiveHTTPHeaders("X-Secret"))
+				if s.NoError(err) {
+					s.Empty(resp.String())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (s *SessionConcurrencySuite) TestOptionsAreNeverMutated() {
+	srv := newRedirectServer()
+	defer srv.Close()
+
+	jar, _ := cookiejar.New(nil)
+	shared := &RequestOptions{
+		CookieJar: jar,
+		Headers:   map[string]string{"X-Secret": "s3cr3t"},
+	}
+	session := NewSession(UserAgent("session"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := session.Get(context.Background(), srv.URL+"/2", FromRequestOptions(shared))
+			s.NoError(err)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := DoRegularRequest("GET", srv.URL+"/2", shared)
+			s.NoError(err)
+		}()
+	}
+	wg.Wait()
+
+	s.False(shared.UseCookieJar)
+	s.Zero(shared.RedirectLimit)
+	s.Nil(shared.SensitiveHTTPHeaders)
+	s.Empty(shared.UserAgent)
+	s.Len(shared.Headers, 1)
+	s.Nil(session.HTTPClient.CheckRedirect)
+}
+
+func (s *SessionConcurrencySuite) TestPackageLevelRedirectPolicy() {
+	srv := newRedirectServer()
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := Get(context.Background(), srv.URL+"/3", RedirectLimit(1))
+			s.ErrorIs(err, ErrRedirectLimitExceeded)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := Get(context.Background(), srv.URL+"/3")
+			s.NoError(err)
+		}()
+	}
+	wg.Wait()
+
+	s.Nil(http.DefaultClient.CheckRedirect)
+}
+
+func TestSessionConcurrencySuite(t *testing.T) {
+	suite.Run(t, new(SessionConcurrencySuite))
+}
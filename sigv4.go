@@ -0,0 +1,528 @@
+package grequests
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm        = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat       = "20060102T150405Z"
+	sigV4DateFormat       = "20060102"
+	sigV4UnsignedPayload  = "UNSIGNED-PAYLOAD"
+	sigV4StreamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	sigV4EmptySHA256      = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// sigV4IgnoredHeaders are never signed, they are either set by the signer or
+// likely to be changed on the way to the server
+var sigV4IgnoredHeaders = map[string]struct{}{
+	"authorization":    {},
+	"user-agent":       {},
+	"x-amzn-trace-id":  {},
+	"expect":           {},
+	"connection":       {},
+	"content-length":   {},
+	"accept-encoding":  {},
+	"proxy-connection": {},
+}
+
+// AWSCredentials are the credentials used by `SigV4` to sign requests
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// SessionToken is only needed with temporary credentials
+	SessionToken string
+}
+
+// SigV4PayloadMode defines how the request body is covered by the signature
+type SigV4PayloadMode int
+
+const (
+	// SigV4SignedPayload hashes the whole body before the request is sent. A body
+	// that can't be read twice is buffered in memory
+	SigV4SignedPayload SigV4PayloadMode = iota
+
+	// SigV4UnsignedPayload leaves the body out of the signature (S3 only)
+	SigV4UnsignedPayload
+
+	// SigV4StreamingPayload signs the body chunk by chunk while it is sent
+	// (S3 only). The length of the body has to be known
+	SigV4StreamingPayload
+)
+
+// SigV4Signer signs requests with AWS Signature Version 4. It is an
+// `AuthOption`, use it like any other option or `Presign` URLs with it.
+type SigV4Signer struct {
+	Credentials AWSCredentials
+	Region      string
+	Service     string
+
+	// Payload is how the body is signed, it defaults to `SigV4SignedPayload`
+	Payload SigV4PayloadMode
+
+	// ChunkSize is the size of the chunks of a streaming payload, it defaults
+	// to 64KB
+	ChunkSize int
+
+	now func() time.Time
+}
+
+func (s *SigV4Signer) Apply(ro *RequestOptions) {
+	ro.Authenticator = s
+}
+
+func (s *SigV4Signer) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	return false, nil
+}
+
+// Authenticate signs the request, adding the `Authorization` and `X-Amz-*`
+// headers. Requests it already signed (retries) are signed again
+func (s *SigV4Signer) Authenticate(req *http.Request) error {
+	t := s.time()
+	unwrapStreamingBody(req)
+
+	req.Header.Set("X-Amz-Date", t.Format(sigV4TimeFormat))
+	if s.Credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.Credentials.SessionToken)
+	}
+
+	var (
+		payloadHash   string
+		err           error
+		contentLength bool
+		source        sigV4StreamSource
+	)
+	switch {
+	case s.Payload == SigV4UnsignedPayload:
+		payloadHash = sigV4UnsignedPayload
+	case s.Payload == SigV4StreamingPayload && hasBody(req):
+		if source, err = s.prepareStreamingBody(req); err != nil {
+			return err
+		}
+		payloadHash, contentLength = sigV4StreamingPayload, true
+	default:
+		if payloadHash, err = hashBody(req); err != nil {
+			return err
+		}
+	}
+
+	// S3 wants the payload hash as a header, other services don't care
+	if s.Service == "s3" || payloadHash == sigV4UnsignedPayload || payloadHash == sigV4StreamingPayload {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	req.URL.RawQuery = sigV4CanonicalQuery(req.URL.RawQuery)
+
+	headers, signedHeaders := sigV4CanonicalHeaders(req, contentLength)
+	signature := s.sign(t, s.canonicalRequest(req, headers, signedHeaders, payloadHash))
+
+	// The signature of the first chunk is chained to the one of the request
+	if payloadHash == sigV4StreamingPayload {
+		s.streamBody(req, t, signature, source)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.Credentials.AccessKeyID, s.scope(t), signedHeaders, signature))
+	return nil
+}
+
+// Presign returns the URL with the signature in its query string, so that the
+// resource can be fetched by anyone (for the expiry duration) without the
+// credentials. Headers that must be sent along are signed too.
+func (s *SigV4Signer) Presign(method, rawURL string, expires time.Duration, headers map[string]string) (string, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	t := s.time()
+	_, signedHeaders := sigV4CanonicalHeaders(req, false)
+
+	query := req.URL.Query()
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", s.Credentials.AccessKeyID+"/"+s.scope(t))
+	query.Set("X-Amz-Date", t.Format(sigV4TimeFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", signedHeaders)
+	if s.Credentials.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.Credentials.SessionToken)
+	}
+	req.URL.RawQuery = sigV4CanonicalQuery(query.Encode())
+
+	payloadHash := sigV4EmptySHA256
+	if s.Service == "s3" {
+		payloadHash = sigV4UnsignedPayload
+	}
+
+	canonicalHeaders, _ := sigV4CanonicalHeaders(req, false)
+	signature := s.sign(t, s.canonicalRequest(req, canonicalHeaders, signedHeaders, payloadHash))
+
+	req.URL.RawQuery += "&X-Amz-Signature=" + signature
+	return req.URL.String(), nil
+}
+
+func (s *SigV4Signer) time() time.Time {
+	if s.now != nil {
+		return s.now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (s *SigV4Signer) chunkSize() int {
+	if s.ChunkSize <= 0 {
+		return 64 * 1024
+	}
+	return s.ChunkSize
+}
+
+func (s *SigV4Signer) scope(t time.Time) string {
+	return strings.Join([]string{t.Format(sigV4DateFormat), s.Region, s.Service, "aws4_request"}, "/")
+}
+
+func (s *SigV4Signer) signingKey(t time.Time) []byte {
+	key := hmacSHA256([]byte("AWS4"+s.Credentials.SecretAccessKey), t.Format(sigV4DateFormat))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func (s *SigV4Signer) canonicalRequest(req *http.Request, headers, signedHeaders, payloadHash string) string {
+	return strings.Join([]string{
+		req.Method,
+		s.canonicalURI(req.URL),
+		req.URL.RawQuery,
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+}
+
+// sign returns the signature of the canonical request
+func (s *SigV4Signer) sign(t time.Time, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		t.Format(sigV4TimeFormat),
+		s.scope(t),
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+	return hex.EncodeToString(hmacSHA256(s.signingKey(t), stringToSign))
+}
+
+// canonicalURI returns the path of the URL as SigV4 wants it. S3 object keys
+// are taken literally and encoded once, the paths of the other services are
+// normalized and encoded twice: the path sent (already encoded once) is
+// encoded again
+func (s *SigV4Signer) canonicalURI(u *url.URL) string {
+	if s.Service == "s3" {
+		if u.Path == "" {
+			return "/"
+		}
+		return sigV4Escape(u.Path, false)
+	}
+
+	p := sigV4EscapedPath(u)
+	if p == "" {
+		return "/"
+	}
+
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return sigV4Escape(cleaned, false)
+}
+
+// sigV4EscapedPath returns the path of the URL as it is sent. An opaque URL
+// ("//host/path") is sent as is, without being escaped by net/url
+func sigV4EscapedPath(u *url.URL) string {
+	if u.Opaque == "" {
+		return u.EscapedPath()
+	}
+
+	rest := strings.TrimPrefix(u.Opaque, "//")
+	if rest == u.Opaque {
+		return u.Opaque
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return rest[i:]
+	}
+	return "/"
+}
+
+// sigV4StreamSource is the body of a request before it is encoded with
+// aws-chunked: a retry of the request is encoded again from it
+type sigV4StreamSource struct {
+	getBody  func() (io.ReadCloser, error)
+	length   int64
+	encoding string
+}
+
+// unwrapStreamingBody turns a retry of a request with a streaming payload (its
+// body is the aws-chunked encoding of a new copy of the original body) back
+// into the original request
+func unwrapStreamingBody(req *http.Request) {
+	chunked, ok := req.Body.(*sigV4ChunkReader)
+	if !ok {
+		return
+	}
+
+	req.Body, req.GetBody, req.ContentLength = chunked.body, chunked.source.getBody, chunked.source.length
+	if chunked.source.encoding != "" {
+		req.Header.Set("Content-Encoding", chunked.source.encoding)
+	} else {
+		req.Header.Del("Content-Encoding")
+	}
+	req.Header.Del("X-Amz-Decoded-Content-Length")
+}
+
+// prepareStreamingBody sets the headers describing an aws-chunked body, they
+// are part of the signature
+func (s *SigV4Signer) prepareStreamingBody(req *http.Request) (sigV4StreamSource, error) {
+	if req.ContentLength <= 0 {
+		return sigV4StreamSource{}, errors.New("grequests: streaming SigV4 signing requires the length of the body")
+	}
+
+	source := sigV4StreamSource{getBody: req.GetBody, length: req.ContentLength, encoding: req.Header.Get("Content-Encoding")}
+	if source.encoding != "" {
+		req.Header.Set("Content-Encoding", "aws-chunked,"+source.encoding)
+	} else {
+		req.Header.Set("Content-Encoding", "aws-chunked")
+	}
+	req.Header.Set("X-Amz-Decoded-Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	req.ContentLength = sigV4ChunkedLength(req.ContentLength, s.chunkSize())
+	return source, nil
+}
+
+// streamBody replaces the body with one signed chunk by chunk (the
+// aws-chunked content encoding)
+func (s *SigV4Signer) streamBody(req *http.Request, t time.Time, seed string, source sigV4StreamSource) {
+	newReader := func(body io.ReadCloser) io.ReadCloser {
+		return &sigV4ChunkReader{
+			body:      body,
+			source:    source,
+			key:       s.signingKey(t),
+			timestamp: t.Format(sigV4TimeFormat),
+			scope:     s.scope(t),
+			previous:  seed,
+			chunk:     make([]byte, s.chunkSize()),
+		}
+	}
+
+	req.Body = newReader(req.Body)
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return newReader(body), nil
+		}
+	}
+}
+
+// sigV4ChunkedLength is the length of the aws-chunked encoding of a body
+func sigV4ChunkedLength(length int64, chunkSize int) int64 {
+	// size;chunk-signature=<64 hex>\r\n<data>\r\n
+	chunkLength := func(size int64) int64 {
+		return int64(len(strconv.FormatInt(size, 16))) + int64(len(";chunk-signature=")) + 64 + 2 + size + 2
+	}
+
+	full := length / int64(chunkSize)
+	total := full*chunkLength(int64(chunkSize)) + chunkLength(0)
+	if rest := length % int64(chunkSize); rest != 0 {
+		total += chunkLength(rest)
+	}
+	return total
+}
+
+// sigV4ChunkReader encodes the body into signed chunks
+type sigV4ChunkReader struct {
+	body      io.ReadCloser
+	source    sigV4StreamSource
+	key       []byte
+	timestamp string
+	scope     string
+	previous  string
+
+	chunk   []byte
+	pending bytes.Buffer
+	done    bool
+}
+
+func (c *sigV4ChunkReader) Read(p []byte) (int, error) {
+	for c.pending.Len() == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(c.body, c.chunk)
+		if n > 0 {
+			c.writeChunk(c.chunk[:n])
+		}
+
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			c.writeChunk(nil)
+			c.done = true
+		default:
+			return 0, err
+		}
+	}
+
+	return c.pending.Read(p)
+}
+
+func (c *sigV4ChunkReader) writeChunk(data []byte) {
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm + "-PAYLOAD",
+		c.timestamp,
+		c.scope,
+		c.previous,
+		sigV4EmptySHA256,
+		sha256Hex(data),
+	}, "\n")
+	c.previous = hex.EncodeToString(hmacSHA256(c.key, stringToSign))
+
+	fmt.Fprintf(&c.pending, "%x;chunk-signature=%s\r\n", len(data), c.previous)
+	c.pending.Write(data)
+	c.pending.WriteString("\r\n")
+}
+
+func (c *sigV4ChunkReader) Close() error {
+	return c.body.Close()
+}
+
+// hashBody returns the hex encoded SHA-256 of the body
+func hashBody(req *http.Request) (string, error) {
+	if !hasBody(req) {
+		return sigV4EmptySHA256, nil
+	}
+
+	hash := sha256.New()
+	if err := digestBody(req, hash); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sigV4CanonicalHeaders returns the canonical headers block (with its
+// trailing blank line) and the list of signed headers
+func sigV4CanonicalHeaders(req *http.Request, contentLength bool) (string, string) {
+	values := map[string][]string{}
+	for key, v := range req.Header {
+		name := strings.ToLower(key)
+		if _, ignored := sigV4IgnoredHeaders[name]; ignored {
+			continue
+		}
+		values[name] = append(values[name], v...)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	values["host"] = []string{host}
+
+	if contentLength {
+		values["content-length"] = []string{strconv.FormatInt(req.ContentLength, 10)}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		trimmed := make([]string, len(values[name]))
+		for i, value := range values[name] {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		b.WriteString(name + ":" + strings.Join(trimmed, ",") + "\n")
+	}
+
+	return b.String(), strings.Join(names, ";")
+}
+
+// sigV4CanonicalQuery sorts and encodes the query string
+func sigV4CanonicalQuery(rawQuery string) string {
+	type pair struct{ key, value string }
+
+	var pairs []pair
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		pairs = append(pairs, pair{sigV4Escape(key, true), sigV4Escape(value, true)})
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].key != pairs[j].key {
+			return pairs[i].key < pairs[j].key
+		}
+		return pairs[i].value < pairs[j].value
+	})
+
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p.key + "=" + p.value
+	}
+	return strings.Join(encoded, "&")
+}
+
+// sigV4Escape percent-encodes everything but the unreserved characters of
+// RFC 3986 (and the slashes of a path)
+func sigV4Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,131 @@
+package grequests
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TLSCertificate is a client certificate (and its private key) presented to
+// servers that ask for one. They are either PEM encoded or read from the
+// files, which take precedence.
+type TLSCertificate struct {
+	CertPEM []byte
+	KeyPEM  []byte
+
+	CertFile string
+	KeyFile  string
+}
+
+func (c TLSCertificate) load() (tls.Certificate, error) {
+	var (
+		cert tls.Certificate
+		err  error
+	)
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err = tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	} else {
+		cert, err = tls.X509KeyPair(c.CertPEM, c.KeyPEM)
+	}
+
+	if err != nil {
+		return cert, fmt.Errorf("grequests: unable to load the client certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// key identifies the certificate in a `clientKey`
+func (c TLSCertificate) key() string {
+	return fmt.Sprintf("%q:%q:%x:%x", c.CertFile, c.KeyFile, sha256.Sum256(c.CertPEM), sha256.Sum256(c.KeyPEM))
+}
+
+// customTLS reports if the request needs more than the default TLS configuration
+func (ro RequestOptions) customTLS() bool {
+	switch {
+	case len(ro.ClientCertificates) != 0:
+	case ro.RootCAs != nil:
+	case len(ro.RootCAFiles) != 0:
+	case ro.TLSMinVersion != 0:
+	case ro.TLSServerName != "":
+	case len(ro.TLSConfig) != 0:
+	case len(ro.PinnedPublicKeys) != 0:
+	default:
+		return false
+	}
+	return true
+}
+
+// buildTLSConfig returns the TLS configuration of the transport. Files are
+// read every time the configuration is built.
+func buildTLSConfig(ro RequestOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: ro.InsecureSkipVerify,
+		RootCAs:            ro.RootCAs,
+		MinVersion:         ro.TLSMinVersion,
+		ServerName:         ro.TLSServerName,
+	}
+
+	for _, certificate := range ro.ClientCertificates {
+		cert, err := certificate.load()
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+
+	if len(ro.RootCAFiles) != 0 {
+		// The pool we were given may be shared, we add the files to a copy
+		var pool *x509.CertPool
+		if ro.RootCAs != nil {
+			pool = ro.RootCAs.Clone()
+		} else if systemPool, err := x509.SystemCertPool(); err == nil {
+			pool = systemPool
+		} else {
+			pool = x509.NewCertPool()
+		}
+
+		for _, file := range ro.RootCAFiles {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("grequests: unable to read the CA file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("grequests: no certificate found in the CA file %s", file)
+			}
+		}
+		config.RootCAs = pool
+	}
+
+	for _, fn := range ro.TLSConfig {
+		fn(config)
+	}
+
+	return config, nil
+}
+
+// tlsClientKey returns the TLS settings of a `clientKey`
+func tlsClientKey(ro RequestOptions) (certificates, caFiles string) {
+	keys := make([]string, 0, len(ro.ClientCertificates))
+	for _, certificate := range ro.ClientCertificates {
+		keys = append(keys, certificate.key())
+	}
+	return strings.Join(keys, ","), strings.Join(ro.RootCAFiles, "\x00")
+}
+
+// failingTransport fails every request with the error the transport couldn't
+// be built with, that way the error is returned by the request
+type failingTransport struct {
+	err error
+}
+
+func (t failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	return nil, t.err
+}
@@ -0,0 +1,305 @@
+package grequests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type TLSSuite struct {
+	suite.Suite
+
+	pki *testPKI
+}
+
+// testCertificate is a certificate with its private key
+type testCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// issueCertificate creates a certificate from the template signed by the
+// parent (self signed when parent is nil)
+func issueCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// testPKI is a CA that issued a client certificate and an intermediate CA,
+// which issued the server certificate
+type testPKI struct {
+	ca           *testCertificate
+	intermediate *testCertificate
+	server       *testCertificate
+	client       *testCertificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	pki := &testPKI{}
+	pki.ca = issueCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "grequests test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+
+	pki.intermediate = issueCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "grequests test intermediate CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, pki.ca)
+
+	// The server certificate is only valid for a name, not for the address
+	// the test server listens on
+	pki.server = issueCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		DNSNames:    []string{"server.grequests.test"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, pki.intermediate)
+
+	pki.client = issueCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, pki.ca)
+
+	return pki
+}
+
+func (p *testPKI) caPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(p.ca.cert)
+	return pool
+}
+
+// tlsServer returns a server that presents the server certificate (and the
+// intermediate), requires a client certificate issued by the CA and
+// responds with its common name
+func (p *testPKI) tlsServer(config func(*tls.Config)) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) != 0 {
+			_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+		}
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{p.server.cert.Raw, p.intermediate.cert.Raw},
+			PrivateKey:  p.server.key,
+		}},
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  p.caPool(),
+	}
+	if config != nil {
+		config(srv.TLS)
+	}
+	srv.StartTLS()
+	return srv
+}
+
+func (s *TLSSuite) SetupSuite() {
+	s.pki = newTestPKI(s.T())
+}
+
+func (s *TLSSuite) SetupTest() {
+	ResetClientPool()
+}
+
+func (s *TLSSuite) writeFile(name string, data []byte) string {
+	path := filepath.Join(s.T().TempDir(), name)
+	s.Require().NoError(os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func (s *TLSSuite) TestMutualTLS() {
+	srv := s.pki.tlsServer(nil)
+	defer srv.Close()
+
+	resp, err := Get(context.Background(), srv.URL,
+		RootCAs(s.pki.caPool()), TLSServerName("server.grequests.test"), ClientCertificate(s.pki.client.certPEM, s.pki.client.keyPEM))
+	s.Require().NoError(err)
+	s.Equal("client", resp.String())
+
+	resp, err = Get(context.Background(), srv.URL,
+		RootCAFiles(s.writeFile("ca.pem", s.pki.ca.certPEM)), TLSServerName("server.grequests.test"),
+		ClientCertificateFiles(s.writeFile("client.pem", s.pki.client.certPEM), s.writeFile("client-key.pem", s.pki.client.keyPEM)))
+	s.Require().NoError(err)
+	s.Equal("client", resp.String())
+
+	// The server refuses requests without a client certificate
+	_, err = Get(context.Background(), srv.URL, RootCAs(s.pki.caPool()), TLSServerName("server.grequests.test"))
+	s.Error(err)
+}
+
+func (s *TLSSuite) TestServerVerification() {
+	srv := s.pki.tlsServer(func(config *tls.Config) { config.ClientAuth = tls.NoClientCert })
+	defer srv.Close()
+
+	// Neither the system pool nor the address match the server certificate
+	_, err := Get(context.Background(), srv.URL, TLSServerName("server.grequests.test"))
+	s.ErrorIs(err, ErrTLSVerify)
+
+	_, err = Get(context.Background(), srv.URL, RootCAs(s.pki.caPool()))
+	s.ErrorIs(err, ErrTLSVerify)
+
+	// The CA files are added to the pool provided, which is left untouched
+	pool := x509.NewCertPool()
+	resp, err := Get(context.Background(), srv.URL,
+		RootCAs(pool), RootCAFiles(s.writeFile("ca.pem", s.pki.ca.certPEM)), TLSServerName("server.grequests.test"))
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	s.True(pool.Equal(x509.NewCertPool()))
+}
+
+func (s *TLSSuite) TestMinVersionAndConfig() {
+	srv := s.pki.tlsServer(func(config *tls.Config) {
+		config.ClientAuth = tls.NoClientCert
+		config.MaxVersion = tls.VersionTLS12
+	})
+	defer srv.Close()
+
+	options := []Option{RootCAs(s.pki.caPool()), TLSServerName("server.grequests.test")}
+
+	_, err := Get(context.Background(), srv.URL, append(options, TLSMinVersion(tls.VersionTLS13))...)
+	s.ErrorContains(err, "protocol version not supported")
+
+	var version uint16
+	resp, err := Get(context.Background(), srv.URL, append(options, TLSConfig(func(config *tls.Config) {
+		// Every other setting has been applied
+		s.Equal("server.grequests.test", config.ServerName)
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			version = state.Version
+			return nil
+		}
+	}))...)
+	s.Require().NoError(err)
+	s.True(resp.Ok)
+	s.Equal(uint16(tls.VersionTLS12), version)
+}
+
+func (s *TLSSuite) TestLoadErrors() {
+	missing := filepath.Join(s.T().TempDir(), "missing.pem")
+
+	_, err := Get(context.Background(), "https://127.0.0.1:1", ClientCertificateFiles(missing, missing))
+	s.ErrorIs(err, os.ErrNotExist)
+
+	_, err = Get(context.Background(), "https://127.0.0.1:1", ClientCertificate(s.pki.client.certPEM, s.pki.server.keyPEM))
+	s.ErrorContains(err, "unable to load the client certificate")
+
+	_, err = Get(context.Background(), "https://127.0.0.1:1", RootCAFiles(s.writeFile("ca.pem", []byte("not a certificate"))))
+	s.ErrorContains(err, "no certificate found")
+
+	// Clients that couldn't be built aren't pooled
+	s.Equal(0, defaultClientPool.len())
+
+	// The error is returned by every request of a session
+	session := NewSession(RootCAFiles(missing))
+	_, err = session.Get(context.Background(), "https://127.0.0.1:1")
+	s.ErrorIs(err, os.ErrNotExist)
+}
+
+func (s *TLSSuite) TestComposesWithTransportSettings() {
+	srv := s.pki.tlsServer(nil)
+	defer srv.Close()
+
+	options := []Option{
+		RootCAs(s.pki.caPool()), TLSServerName("server.grequests.test"), ClientCertificate(s.pki.client.certPEM, s.pki.client.keyPEM),
+		DialTimeout(time.Second), LocalAddr(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}),
+	}
+
+	session := NewSession(options...)
+	defer session.CloseIdleConnections()
+
+	resp, err := session.Get(context.Background(), srv.URL)
+	s.Require().NoError(err)
+	s.Equal("client", resp.String())
+
+	// Pooled clients are shared by requests with the same TLS settings only
+	ro := RequestOptions{}
+	applyOptions(&ro, options)
+	a, b := pooledHTTPClient(ro), pooledHTTPClient(ro)
+	s.Same(a.Transport, b.Transport)
+
+	ro.TLSMinVersion = tls.VersionTLS13
+	s.NotSame(a.Transport, pooledHTTPClient(ro).Transport)
+
+	ro.TLSConfig = []func(*tls.Config){func(*tls.Config) {}}
+	s.NotSame(pooledHTTPClient(ro).Transport, pooledHTTPClient(ro).Transport)
+}
+
+func (s *TLSSuite) TestSessionRequestOptions() {
+	srv := s.pki.tlsServer(nil)
+	defer srv.Close()
+
+	session := NewSession(TLSServerName("server.grequests.test"))
+	defer session.CloseIdleConnections()
+
+	// The session has neither the CA nor a client certificate
+	_, err := session.Get(context.Background(), srv.URL)
+	s.ErrorIs(err, ErrTLSVerify)
+
+	resp, err := session.Get(context.Background(), srv.URL,
+		RootCAs(s.pki.caPool()), ClientCertificate(s.pki.client.certPEM, s.pki.client.keyPEM))
+	s.Require().NoError(err)
+	s.Equal("client", resp.String())
+
+	var configured bool
+	resp, err = session.Get(context.Background(), srv.URL,
+		RootCAFiles(s.writeFile("ca.pem", s.pki.ca.certPEM)),
+		ClientCertificateFiles(s.writeFile("client.pem", s.pki.client.certPEM), s.writeFile("client-key.pem", s.pki.client.keyPEM)),
+		TLSConfig(func(*tls.Config) { configured = true }))
+	s.Require().NoError(err)
+	s.Equal("client", resp.String())
+	s.True(configured)
+
+	_, err = session.Get(context.Background(), srv.URL, RootCAs(s.pki.caPool()),
+		ClientCertificate(s.pki.client.certPEM, s.pki.client.keyPEM), TLSServerName("other.grequests.test"))
+	s.ErrorIs(err, ErrTLSVerify)
+}
+
+func TestTLSSuite(t *testing.T) {
+	suite.Run(t, new(TLSSuite))
+}
@@ -51,31 +51,42 @@ var (
 // because Go's XML library only supports XML encoded in UTF-8
 type XMLCharDecoder func(charset string, input io.Reader) (io.Reader, error)
 
+// addRedirectFunctionality installs the redirect policy of the request on
+// the client. The client must not be shared with other requests (it is a
+// per request copy) so that every request is evaluated against its own options.
+// A `CheckRedirect` function that has been provided by the user is left untouched.
 func addRedirectFunctionality(client *http.Client, ro *RequestOptions) {
 	if client.CheckRedirect != nil {
 		return
 	}
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+	client.CheckRedirect = redirectPolicy(ro.RedirectLimit, sensitiveHTTPHeaders(ro))
+}
 
-		if ro.RedirectLimit < 0 {
-			return http.ErrUseLastResponse
-		}
+// redirectPolicy returns a `CheckRedirect` function for the provided settings.
+// The settings are captured by value – the returned function never modifies
+// the request options and is safe for concurrent use.
+func redirectPolicy(redirectLimit int, sensitiveHTTPHeaders map[string]struct{}) func(req *http.Request, via []*http.Request) error {
+	if redirectLimit == 0 {
+		redirectLimit = RequestRedirectLimit
+	}
 
-		if ro.RedirectLimit == 0 {
-			ro.RedirectLimit = RequestRedirectLimit
-		}
+	if sensitiveHTTPHeaders == nil {
+		sensitiveHTTPHeaders = RequestSensitiveHTTPHeaders
+	}
 
-		if len(via) >= ro.RedirectLimit {
-			return ErrRedirectLimitExceeded
+	return func(req *http.Request, via []*http.Request) error {
+
+		if redirectLimit < 0 {
+			return http.ErrUseLastResponse
 		}
 
-		if ro.SensitiveHTTPHeaders == nil {
-			ro.SensitiveHTTPHeaders = RequestSensitiveHTTPHeaders
+		if len(via) >= redirectLimit {
+			return ErrRedirectLimitExceeded
 		}
 
 		for k, vv := range via[0].Header {
 			// Is this a sensitive header?
-			if _, found := ro.SensitiveHTTPHeaders[k]; found {
+			if _, found := sensitiveHTTPHeaders[k]; found {
 				continue
 			}
 
@@ -84,6 +95,14 @@ func addRedirectFunctionality(client *http.Client, ro *RequestOptions) {
 			}
 		}
 
+		// net/http forwards some headers to the same domain on its own – sensitive
+		// headers must never leave the host they were meant for
+		if req.URL.Host != via[0].URL.Host {
+			for k := range sensitiveHTTPHeaders {
+				req.Header.Del(k)
+			}
+		}
+
 		return nil
 	}
 }
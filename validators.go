@@ -0,0 +1,464 @@
+package grequests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ExpectStatusClass returns an `AfterResponse` hook that fails unless the
+// response status code belongs to the class provided (e.g. 2 for 2xx)
+func ExpectStatusClass(class int) func(*Response) error {
+	return func(r *Response) error {
+		if r.StatusCode/100 != class {
+			return fmt.Errorf("grequests: expected a %dxx status code, got %d", class, r.StatusCode)
+		}
+		return nil
+	}
+}
+
+// ExpectContentType returns an `AfterResponse` hook that fails unless the
+// response media type is one of the content types provided. Parameters such as
+// the charset are ignored.
+func ExpectContentType(contentTypes ...string) func(*Response) error {
+	return func(r *Response) error {
+		header := r.Header.Get("Content-Type")
+		mediaType, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			return fmt.Errorf("grequests: invalid Content-Type %q: %w", header, err)
+		}
+
+		for _, ct := range contentTypes {
+			if strings.EqualFold(mediaType, ct) {
+				return nil
+			}
+		}
+		return fmt.Errorf("grequests: unexpected Content-Type %q, expected one of %q", mediaType, contentTypes)
+	}
+}
+
+// ExpectJSONSchema returns an `AfterResponse` hook that fails unless the
+// response body is JSON that matches the schema provided. The body is buffered
+// so it can still be read with `JSON`, `String` or `Bytes` afterwards.
+//
+// The following JSON Schema keywords are supported: type, enum, const,
+// properties, required, additionalProperties, items, minItems, maxItems,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength,
+// pattern, allOf, anyOf, oneOf and not. Annotations ($schema, $id, $comment,
+// title, description, default, examples, format, deprecated, readOnly and
+// writeOnly) are allowed but not checked. The schema is checked once, here:
+// schemas using any other keyword ($ref for instance) make every response fail
+// rather than being partially validated.
+func ExpectJSONSchema(schema []byte) func(*Response) error {
+	parsed, parseErr := parseJSONSchema(schema)
+
+	return func(r *Response) error {
+		if parseErr != nil {
+			return fmt.Errorf("grequests: invalid JSON schema: %w", parseErr)
+		}
+
+		body := r.Bytes()
+		if r.Error != nil {
+			return r.Error
+		}
+
+		var document interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&document); err != nil {
+			return fmt.Errorf("grequests: response is not valid JSON: %w", err)
+		}
+
+		if err := validateJSONSchema(parsed, document, "$"); err != nil {
+			return fmt.Errorf("grequests: response does not match JSON schema: %w", err)
+		}
+		return nil
+	}
+}
+
+// jsonSchemaKeywords maps the keywords we know about to whether they hold
+// subschemas that have to be checked as well
+var jsonSchemaKeywords = map[string]bool{
+	"type": false, "enum": false, "const": false, "required": false,
+	"minItems": false, "maxItems": false, "minimum": false, "maximum": false,
+	"exclusiveMinimum": false, "exclusiveMaximum": false, "minLength": false,
+	"maxLength": false, "pattern": false,
+
+	"properties": true, "additionalProperties": true, "items": true,
+	"allOf": true, "anyOf": true, "oneOf": true, "not": true,
+
+	// Annotations
+	"$schema": false, "$id": false, "$comment": false, "title": false,
+	"description": false, "default": false, "examples": false, "format": false,
+	"deprecated": false, "readOnly": false, "writeOnly": false,
+}
+
+// parseJSONSchema decodes the schema and checks that every keyword it uses is
+// supported. Patterns are compiled, they are stored in the schema as
+// *regexp.Regexp values
+func parseJSONSchema(schema []byte) (interface{}, error) {
+	var parsed interface{}
+	if err := json.Unmarshal(schema, &parsed); err != nil {
+		return nil, err
+	}
+	if err := compileJSONSchema(parsed, "#"); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+func compileJSONSchema(schema interface{}, path string) error {
+	if _, ok := schema.(bool); ok {
+		return nil
+	}
+
+	object, ok := schema.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: invalid schema", path)
+	}
+
+	for keyword, value := range object {
+		subschemas, known := jsonSchemaKeywords[keyword]
+		if !known {
+			return fmt.Errorf("%s: unsupported keyword %q", path, keyword)
+		}
+
+		if keyword == "pattern" {
+			pattern, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("%s/pattern: expected a string", path)
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("%s: invalid pattern %q: %w", path, pattern, err)
+			}
+			object[keyword] = re
+		}
+
+		if !subschemas {
+			continue
+		}
+
+		switch keyword {
+		case "properties":
+			properties, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s/properties: expected an object", path)
+			}
+			for name, sub := range properties {
+				if err := compileJSONSchema(sub, path+"/properties/"+name); err != nil {
+					return err
+				}
+			}
+
+		case "allOf", "anyOf", "oneOf":
+			list, ok := value.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s/%s: expected an array", path, keyword)
+			}
+			for i, sub := range list {
+				if err := compileJSONSchema(sub, fmt.Sprintf("%s/%s/%d", path, keyword, i)); err != nil {
+					return err
+				}
+			}
+
+		default:
+			if err := compileJSONSchema(value, path+"/"+keyword); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateJSONSchema(schema, value interface{}, path string) error {
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			return fmt.Errorf("%s: no value is allowed", path)
+		}
+		return nil
+	case map[string]interface{}:
+		return validateJSONSchemaObject(s, value, path)
+	}
+	return fmt.Errorf("%s: invalid schema", path)
+}
+
+func validateJSONSchemaObject(schema map[string]interface{}, value interface{}, path string) error {
+	if t, ok := schema["type"]; ok && !matchesJSONType(t, value) {
+		return fmt.Errorf("%s: expected type %v, got %s", path, t, jsonTypeOf(value))
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of %v", path, enum)
+		}
+	}
+
+	if c, ok := schema["const"]; ok && !jsonEqual(c, value) {
+		return fmt.Errorf("%s: value must be %v", path, c)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if err := validateJSONObject(schema, v, path); err != nil {
+			return err
+		}
+	case []interface{}:
+		if err := validateJSONArray(schema, v, path); err != nil {
+			return err
+		}
+	case json.Number:
+		if err := validateJSONNumber(schema, v, path); err != nil {
+			return err
+		}
+	case string:
+		if err := validateJSONString(schema, v, path); err != nil {
+			return err
+		}
+	}
+
+	return validateJSONSchemaCombinators(schema, value, path)
+}
+
+func validateJSONSchemaCombinators(schema map[string]interface{}, value interface{}, path string) error {
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			if err := validateJSONSchema(sub, value, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if validateJSONSchema(sub, value, path) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: value does not match any schema in anyOf", path)
+		}
+	}
+
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, sub := range oneOf {
+			if validateJSONSchema(sub, value, path) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: value matches %d schemas in oneOf, expected exactly 1", path, matches)
+		}
+	}
+
+	if not, ok := schema["not"]; ok && validateJSONSchema(not, value, path) == nil {
+		return fmt.Errorf("%s: value must not match the schema in not", path)
+	}
+
+	return nil
+}
+
+func validateJSONObject(schema map[string]interface{}, object map[string]interface{}, path string) error {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, found := object[name]; !found {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	// Sort the keys so that the reported error is deterministic
+	keys := make([]string, 0, len(object))
+	for k := range object {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if sub, ok := properties[k]; ok {
+			if err := validateJSONSchema(sub, object[k], path+"."+k); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if additional, ok := schema["additionalProperties"]; ok {
+			if err := validateJSONSchema(additional, object[k], path+"."+k); err != nil {
+				return fmt.Errorf("%s: additional property %q is not allowed: %w", path, k, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateJSONArray(schema map[string]interface{}, array []interface{}, path string) error {
+	if lower, ok := jsonSchemaInt(schema, "minItems"); ok && len(array) < lower {
+		return fmt.Errorf("%s: expected at least %d items, got %d", path, lower, len(array))
+	}
+
+	if upper, ok := jsonSchemaInt(schema, "maxItems"); ok && len(array) > upper {
+		return fmt.Errorf("%s: expected at most %d items, got %d", path, upper, len(array))
+	}
+
+	if items, ok := schema["items"]; ok {
+		for i, item := range array {
+			if err := validateJSONSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateJSONNumber(schema map[string]interface{}, number json.Number, path string) error {
+	n, err := number.Float64()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if lower, ok := schema["minimum"].(float64); ok && n < lower {
+		return fmt.Errorf("%s: %v is less than the minimum of %v", path, n, lower)
+	}
+
+	if upper, ok := schema["maximum"].(float64); ok && n > upper {
+		return fmt.Errorf("%s: %v is greater than the maximum of %v", path, n, upper)
+	}
+
+	if lower, ok := schema["exclusiveMinimum"].(float64); ok && n <= lower {
+		return fmt.Errorf("%s: %v must be greater than %v", path, n, lower)
+	}
+
+	if upper, ok := schema["exclusiveMaximum"].(float64); ok && n >= upper {
+		return fmt.Errorf("%s: %v must be less than %v", path, n, upper)
+	}
+
+	return nil
+}
+
+func validateJSONString(schema map[string]interface{}, str string, path string) error {
+	length := utf8.RuneCountInString(str)
+
+	if lower, ok := jsonSchemaInt(schema, "minLength"); ok && length < lower {
+		return fmt.Errorf("%s: expected at least %d characters, got %d", path, lower, length)
+	}
+
+	if upper, ok := jsonSchemaInt(schema, "maxLength"); ok && length > upper {
+		return fmt.Errorf("%s: expected at most %d characters, got %d", path, upper, length)
+	}
+
+	if re, ok := schema["pattern"].(*regexp.Regexp); ok && !re.MatchString(str) {
+		return fmt.Errorf("%s: %q does not match pattern %q", path, str, re.String())
+	}
+
+	return nil
+}
+
+func jsonSchemaInt(schema map[string]interface{}, keyword string) (int, bool) {
+	f, ok := schema[keyword].(float64)
+	return int(f), ok
+}
+
+func matchesJSONType(schemaType interface{}, value interface{}) bool {
+	switch t := schemaType.(type) {
+	case string:
+		return matchesSingleJSONType(t, value)
+	case []interface{}:
+		for _, st := range t {
+			if name, ok := st.(string); ok && matchesSingleJSONType(name, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesSingleJSONType(schemaType string, value interface{}) bool {
+	actual := jsonTypeOf(value)
+	if schemaType == "number" && actual == "integer" {
+		return true
+	}
+	return schemaType == actual
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+// jsonEqual compares a value from the schema (decoded with float64 numbers)
+// with a value from the document (decoded with json.Number)
+func jsonEqual(schemaValue, value interface{}) bool {
+	if n, ok := value.(json.Number); ok {
+		f, err := n.Float64()
+		expected, isNumber := schemaValue.(float64)
+		return err == nil && isNumber && f == expected
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		expected, ok := schemaValue.([]interface{})
+		if !ok || len(expected) != len(v) {
+			return false
+		}
+		for i := range v {
+			if !jsonEqual(expected[i], v[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		expected, ok := schemaValue.(map[string]interface{})
+		if !ok || len(expected) != len(v) {
+			return false
+		}
+		for k := range v {
+			e, found := expected[k]
+			if !found || !jsonEqual(e, v[k]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(schemaValue, value)
+}
@@ -0,0 +1,182 @@
+package grequests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ValidatorsSuite struct {
+	suite.Suite
+}
+
+const userSchema = `{
+	"type": "object",
+	"required": ["name", "age"],
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"email": {"type": "string", "pattern": "@"},
+		"tags": {"type": "array", "items": {"enum": ["a", "b"]}, "maxItems": 2}
+	},
+	"additionalProperties": false
+}`
+
+func (s *ValidatorsSuite) TestAfterResponseHook() {
+	srv := newGetServer()
+	defer srv.Close()
+
+	var seen int
+	resp, err := Get(context.Background(), srv.URL, AfterResponse(func(r *Response) error {
+		seen = r.StatusCode
+		return nil
+	}))
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, seen)
+	s.NoError(resp.Error)
+
+	hookErr := errors.New("rejected")
+	resp, err = Get(context.Background(), srv.URL, AfterResponse(func(r *Response) error { return hookErr }))
+	s.ErrorIs(err, hookErr)
+	s.ErrorIs(resp.Error, hookErr)
+	s.Equal(http.StatusOK, resp.StatusCode)
+}
+
+func (s *ValidatorsSuite) TestAfterResponseStopsAtFirstError() {
+	srv := newGetServer()
+	defer srv.Close()
+
+	var calls int
+	count := func(r *Response) error { calls++; return nil }
+	_, err := Get(context.Background(), srv.URL, AfterResponse(count, ExpectStatusClass(4), count))
+	s.Error(err)
+	s.Equal(1, calls)
+}
+
+func (s *ValidatorsSuite) TestSessionAfterResponse() {
+	srv := newStatusServer(http.StatusNotFound)
+	defer srv.Close()
+
+	session := NewSession(AfterResponse(ExpectStatusClass(2)))
+	_, err := session.Get(context.Background(), srv.URL)
+	s.EqualError(err, "grequests: expected a 2xx status code, got 404")
+
+	var calls []string
+	session = NewSession(AfterResponse(func(r *Response) error { calls = append(calls, "session"); return nil }))
+	_, err = session.Get(context.Background(), srv.URL, AfterResponse(func(r *Response) error { calls = append(calls, "request"); return nil }))
+	s.NoError(err)
+	s.Equal([]string{"session", "request"}, calls)
+}
+
+func (s *ValidatorsSuite) TestExpectStatusClass() {
+	s.NoError(ExpectStatusClass(2)(&Response{StatusCode: 204}))
+	s.NoError(ExpectStatusClass(3)(&Response{StatusCode: 301}))
+	s.Error(ExpectStatusClass(2)(&Response{StatusCode: 500}))
+}
+
+func (s *ValidatorsSuite) TestExpectContentType() {
+	resp := &Response{Header: http.Header{"Content-Type": {"Application/JSON; charset=utf-8"}}}
+	s.NoError(ExpectContentType("application/json")(resp))
+	s.NoError(ExpectContentType("text/plain", "application/json")(resp))
+	s.Error(ExpectContentType("text/html")(resp))
+	s.Error(ExpectContentType("application/json")(&Response{Header: http.Header{}}))
+}
+
+func (s *ValidatorsSuite) TestExpectJSONSchema() {
+	srv := newJSONServer(map[string]any{"name": "levi", "age": 30, "tags": []string{"a"}})
+	defer srv.Close()
+
+	resp, err := Get(context.Background(), srv.URL,
+		AfterResponse(ExpectContentType("application/json"), ExpectJSONSchema([]byte(userSchema))))
+	s.Require().NoError(err)
+
+	// The body is still available after validation
+	var data map[string]any
+	s.NoError(resp.JSON(&data))
+	s.Equal("levi", data["name"])
+}
+
+func (s *ValidatorsSuite) TestExpectJSONSchemaFailures() {
+	cases := map[string]string{
+		`{"name": "levi"}`:                                  `missing required property "age"`,
+		`{"name": "", "age": 1}`:                            `$.name: expected at least 1 characters`,
+		`{"name": "levi", "age": 1.5}`:                      `$.age: expected type integer`,
+		`{"name": "levi", "age": -1}`:                       `$.age: -1 is less than the minimum`,
+		`{"name": "levi", "age": 1, "email": "x"}`:          `$.email: "x" does not match pattern`,
+		`{"name": "levi", "age": 1, "tags": ["c"]}`:         `$.tags[0]: value is not one of`,
+		`{"name": "levi", "age": 1, "tags": ["a","b","a"]}`: `$.tags: expected at most 2 items`,
+		`{"name": "levi", "age": 1, "extra": true}`:         `additional property "extra" is not allowed`,
+		`[]`:       `$: expected type object, got array`,
+		`not json`: `response is not valid JSON`,
+	}
+
+	for body, expected := range cases {
+		body := body
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(body))
+		}))
+		_, err := Get(context.Background(), srv.URL, AfterResponse(ExpectJSONSchema([]byte(userSchema))))
+		srv.Close()
+		if s.Error(err, body) {
+			s.Contains(err.Error(), expected, body)
+		}
+	}
+}
+
+func (s *ValidatorsSuite) TestJSONSchemaCombinators() {
+	var schema interface{}
+	s.Require().NoError(json.Unmarshal([]byte(`{"anyOf": [{"type": "string"}, {"type": "integer"}], "not": {"const": 5}}`), &schema))
+
+	s.NoError(validateJSONSchema(schema, "str", "$"))
+	s.NoError(validateJSONSchema(schema, json.Number("4"), "$"))
+	s.Error(validateJSONSchema(schema, json.Number("5"), "$"))
+	s.Error(validateJSONSchema(schema, true, "$"))
+
+	var oneOf interface{}
+	s.Require().NoError(json.Unmarshal([]byte(`{"oneOf": [{"type": "number"}, {"type": "integer"}]}`), &oneOf))
+	s.NoError(validateJSONSchema(oneOf, json.Number("1.5"), "$"))
+	s.Error(validateJSONSchema(oneOf, json.Number("1"), "$"))
+
+	s.Error(validateJSONSchema(false, "anything", "$"))
+	s.NoError(validateJSONSchema(true, "anything", "$"))
+}
+
+func (s *ValidatorsSuite) TestInvalidSchema() {
+	srv := newJSONServer(map[string]any{})
+	defer srv.Close()
+
+	_, err := Get(context.Background(), srv.URL, AfterResponse(ExpectJSONSchema([]byte("{"))))
+	s.ErrorContains(err, "invalid JSON schema")
+}
+
+func (s *ValidatorsSuite) TestUnsupportedSchema() {
+	srv := newJSONServer(map[string]any{})
+	defer srv.Close()
+
+	cases := map[string]string{
+		`{"$ref": "#/$defs/user"}`:                                   `#: unsupported keyword "$ref"`,
+		`{"properties": {"a": {"uniqueItems": true}}}`:               `#/properties/a: unsupported keyword "uniqueItems"`,
+		`{"anyOf": [{"type": "string"}, {"patternProperties": {}}]}`: `#/anyOf/1: unsupported keyword "patternProperties"`,
+		`{"items": [{"type": "string"}]}`:                            `#/items: invalid schema`,
+		`{"pattern": "("}`:                                           `#: invalid pattern "("`,
+	}
+	for schema, expected := range cases {
+		_, err := Get(context.Background(), srv.URL, AfterResponse(ExpectJSONSchema([]byte(schema))))
+		s.ErrorContains(err, "invalid JSON schema", schema)
+		s.ErrorContains(err, expected, schema)
+	}
+
+	// Annotations are allowed
+	_, err := Get(context.Background(), srv.URL, AfterResponse(ExpectJSONSchema([]byte(
+		`{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "t", "type": "object", "format": "x"}`))))
+	s.NoError(err)
+}
+
+func TestValidatorsSuite(t *testing.T) {
+	suite.Run(t, new(ValidatorsSuite))
+}